@@ -0,0 +1,205 @@
+package abandonment
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"github.com/aaravmahajanofficial/ecommerce-project/notifications"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ABANDONED_CART_AFTER is how long a cart may sit untouched before it counts as abandoned,
+// ABANDONED_CART_INTERVAL how often the scheduler looks and ABANDONED_CART_MAX_REMINDERS how often one cart is reminded about
+
+type Config struct {
+	Idle_Threshold time.Duration
+	Interval       time.Duration
+	Max_Reminders  int
+	// upper bound of carts handled per run, the rest wait for the next one
+	Batch_Size int
+}
+
+// a cart whose reminder failed waits Interval before the next attempt, twice as long after every further failure
+const maxRetryBackoff = 24 * time.Hour
+
+func (config Config) retryBackoff(failures int) time.Duration {
+
+	backoff := config.Interval
+
+	for attempt := 0; attempt < failures && backoff < maxRetryBackoff; attempt++ {
+		backoff *= 2
+	}
+
+	return min(backoff, maxRetryBackoff)
+
+}
+
+func ConfigFromEnv() Config {
+
+	config := Config{
+		Idle_Threshold: 24 * time.Hour,
+		Interval:       15 * time.Minute,
+		Max_Reminders:  2,
+		Batch_Size:     500,
+	}
+
+	if value, err := time.ParseDuration(os.Getenv("ABANDONED_CART_AFTER")); err == nil && value > 0 {
+		config.Idle_Threshold = value
+	}
+
+	if value, err := time.ParseDuration(os.Getenv("ABANDONED_CART_INTERVAL")); err == nil && value > 0 {
+		config.Interval = value
+	}
+
+	if value, err := strconv.Atoi(os.Getenv("ABANDONED_CART_MAX_REMINDERS")); err == nil && value >= 0 {
+		config.Max_Reminders = value
+	}
+
+	return config
+
+}
+
+type Scheduler struct {
+	usersCollection *mongo.Collection
+	notifier        notifications.Notifier
+	config          Config
+	running         atomic.Bool
+}
+
+func NewScheduler(usersCollection *mongo.Collection, notifier notifications.Notifier, config Config) *Scheduler {
+
+	return &Scheduler{
+		usersCollection: usersCollection,
+		notifier:        notifier,
+		config:          config,
+	}
+
+}
+
+func (scheduler *Scheduler) Config() Config {
+	return scheduler.config
+}
+
+func (scheduler *Scheduler) Running() bool {
+	return scheduler.running.Load()
+}
+
+// checks for abandoned carts every interval until the context is cancelled
+
+func (scheduler *Scheduler) Run(ctx context.Context) {
+
+	scheduler.running.Store(true)
+	defer scheduler.running.Store(false)
+
+	ticker := time.NewTicker(scheduler.config.Interval)
+	defer ticker.Stop()
+
+	for {
+
+		if _, err := scheduler.RunOnce(ctx); err != nil {
+			slog.ErrorContext(ctx, "Run failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+	}
+
+}
+
+// sends the reminders that are due and returns how many went out
+// a reminder that cannot be sent is logged and its cart put back with a backoff, the rest of the batch still goes out
+
+func (scheduler *Scheduler) RunOnce(ctx context.Context) (int, error) {
+
+	if scheduler.config.Max_Reminders == 0 {
+		return 0, nil
+	}
+
+	idleSince := time.Now().Add(-scheduler.config.Idle_Threshold)
+	sent := 0
+
+	for claimed := 0; claimed < scheduler.config.Batch_Size; claimed++ {
+
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+
+		user, found, err := database.ClaimAbandonedCart(ctx, scheduler.usersCollection, idleSince, scheduler.config.Max_Reminders)
+
+		if err != nil {
+			return sent, err
+		}
+
+		if !found {
+			return sent, nil
+		}
+
+		if err = scheduler.notifier.Send(ctx, reminderFor(user)); err != nil {
+
+			retryAt := time.Now().Add(scheduler.config.retryBackoff(user.Cart_Reminder_Failures))
+			slog.ErrorContext(ctx, "RunOnce could not send a cart reminder", "error", err, "user_id", user.User_ID, "retry_at", retryAt)
+
+			// the claim stays, so the cart waits a whole idle threshold instead of the backoff
+			if err = database.ReleaseAbandonedCart(ctx, scheduler.usersCollection, user, retryAt); err != nil {
+				slog.ErrorContext(ctx, "RunOnce could not release a cart", "error", err, "user_id", user.User_ID)
+			}
+
+			continue
+
+		}
+
+		sent++
+
+	}
+
+	return sent, nil
+
+}
+
+func reminderFor(user models.User) notifications.Notification {
+
+	names := make([]string, 0, len(user.UserCart))
+	seen := make(map[string]bool)
+
+	for _, line := range user.UserCart {
+		if line.Product_Name != nil && !seen[*line.Product_Name] {
+			seen[*line.Product_Name] = true
+			names = append(names, *line.Product_Name)
+		}
+	}
+
+	notification := notifications.Notification{
+		Kind:    notifications.KindAbandonedCart,
+		User_ID: user.User_ID,
+		Subject: "You left something in your cart",
+		Body:    fmt.Sprintf("Your cart still has %d item(s) waiting for you: %s", len(user.UserCart), strings.Join(names, ", ")),
+		Data: map[string]string{
+			"items":    strconv.Itoa(len(user.UserCart)),
+			"reminder": strconv.Itoa(user.Cart_Reminders + 1),
+		},
+		Created_At: time.Now(),
+	}
+
+	if user.Email != nil {
+		notification.Email = *user.Email
+	}
+
+	if user.First_Name != nil {
+		notification.Body = "Hi " + *user.First_Name + ", " + strings.ToLower(notification.Body[:1]) + notification.Body[1:]
+	}
+
+	return notification
+
+}
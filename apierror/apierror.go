@@ -0,0 +1,217 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/locales/en"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+)
+
+// every failed request is answered with this body, eg :
+// {"error": {"code": "PRODUCT_NOT_FOUND", "message": "unable to find the specified product", "request_id": "6f1c..."}}
+
+type Envelope struct {
+	Error *Error `json:"error"`
+}
+
+type Error struct {
+	Status     int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Details    any    `json:"details,omitempty"`
+	Request_ID string `json:"request_id,omitempty"`
+	cause      error
+}
+
+func (err *Error) Error() string {
+	return err.Message
+}
+
+func (err *Error) Unwrap() error {
+	return err.cause
+}
+
+func New(status int, code string, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// returns a copy, so shared errors are never modified
+
+func (err *Error) WithDetails(details any) *Error {
+
+	copied := *err
+	copied.Details = details
+	return &copied
+
+}
+
+func BadRequest(code string, message string) *Error {
+	return New(http.StatusBadRequest, code, message)
+}
+
+func Unauthorized(code string, message string) *Error {
+	return New(http.StatusUnauthorized, code, message)
+}
+
+func Forbidden(code string, message string) *Error {
+	return New(http.StatusForbidden, code, message)
+}
+
+func NotFound(code string, message string) *Error {
+	return New(http.StatusNotFound, code, message)
+}
+
+func Conflict(code string, message string) *Error {
+	return New(http.StatusConflict, code, message)
+}
+
+// the request body could not be decoded at all
+var ErrInvalidJSON = BadRequest("INVALID_JSON", "Invalid JSON data")
+
+// errors carrying more than their message, eg : the warnings that blocked a checkout
+
+type detailedError interface {
+	error
+	ErrorDetails() any
+}
+
+// sentinel errors of the other packages are given a status and code once, when the program starts
+
+type mapping struct {
+	target error
+	status int
+	code   string
+}
+
+var (
+	mappingsMutex sync.RWMutex
+	mappings      []mapping
+)
+
+func Register(target error, status int, code string) {
+
+	mappingsMutex.Lock()
+	defer mappingsMutex.Unlock()
+
+	mappings = append(mappings, mapping{target: target, status: status, code: code})
+
+}
+
+// turns any error into the response it should produce, errors nobody registered are internal
+// and their message only shows up in the request log, it is never sent
+
+func From(err error) *Error {
+
+	var apiError *Error
+
+	if errors.As(err, &apiError) {
+		copied := *apiError
+		return &copied
+	}
+
+	var validationErrors validator.ValidationErrors
+
+	if errors.As(err, &validationErrors) {
+		return ValidationFailed(ValidationDetails(validationErrors))
+	}
+
+	mappingsMutex.RLock()
+	defer mappingsMutex.RUnlock()
+
+	for _, mapping := range mappings {
+		if errors.Is(err, mapping.target) {
+			apiError = &Error{Status: mapping.status, Code: mapping.code, Message: err.Error(), cause: err}
+			var detailed detailedError
+			if errors.As(err, &detailed) {
+				apiError.Details = detailed.ErrorDetails()
+			}
+			return apiError
+		}
+	}
+
+	return &Error{Status: http.StatusInternalServerError, Code: "INTERNAL_ERROR", Message: "Internal server error", cause: err}
+
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func ValidationFailed(details []FieldError) *Error {
+	return BadRequest("VALIDATION_FAILED", "Request validation failed").WithDetails(details)
+}
+
+// validation messages are written in English, eg : "first_name must be at least 2 characters in length"
+var translator, _ = ut.New(en.New()).GetTranslator("en")
+
+// validators whose errors reach From register their messages here, once when they are created
+
+func RegisterTranslations(validate *validator.Validate) error {
+
+	return en_translations.RegisterDefaultTranslations(validate, translator)
+
+}
+
+// the message of a custom rule, "{0}" is the field and "{1}" the rule's parameter, eg :
+// RegisterTranslation(validate, "currency", "{0} must be a supported currency code")
+
+func RegisterTranslation(validate *validator.Validate, rule string, text string) error {
+
+	return validate.RegisterTranslation(rule, translator, func(trans ut.Translator) error {
+		return trans.Add(rule, text, true)
+	}, func(trans ut.Translator, fieldError validator.FieldError) string {
+		message, err := trans.T(rule, fieldError.Field(), fieldError.Param())
+		if err != nil {
+			return fieldError.Error()
+		}
+		return message
+	})
+
+}
+
+func ValidationDetails(validationErrors validator.ValidationErrors) []FieldError {
+
+	details := make([]FieldError, 0, len(validationErrors))
+
+	for _, fieldError := range validationErrors {
+		details = append(details, FieldError{Field: fieldPath(fieldError), Rule: fieldError.Tag(), Message: fieldError.Translate(translator)})
+	}
+
+	return details
+
+}
+
+// the namespace without the struct's own name, eg : "VariantsRequest.variants[0].sku" -> "variants[0].sku"
+
+func fieldPath(fieldError validator.FieldError) string {
+
+	if _, path, found := strings.Cut(fieldError.Namespace(), "."); found {
+		return path
+	}
+
+	return fieldError.Field()
+
+}
+
+// handlers written as func(ctx) error are adapted here, a returned error is left to the error middleware
+
+func Handle(handler func(ctx *gin.Context) error) gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		if err := handler(ctx); err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+		}
+
+	}
+
+}
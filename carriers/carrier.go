@@ -0,0 +1,67 @@
+package carriers
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+)
+
+var (
+	ErrUnknownCarrier  = errors.New("carrier is not registered")
+	ErrInvalidTracking = errors.New("tracking number is not valid for this carrier")
+	ErrInvalidWebhook  = errors.New("unable to parse carrier webhook payload")
+)
+
+// a StatusUpdate is a single tracking event reported by a carrier for one of our shipments
+
+type StatusUpdate struct {
+	Tracking_Number string
+	Event           models.TrackingEvent
+}
+
+// every carrier integration implements this, so shipments never talk to a carrier API directly
+
+type Carrier interface {
+	Name() string
+	// books the shipment with the carrier and returns its tracking number
+	CreateShipment(ctx context.Context, shipment models.Shipment) (string, error)
+	// returns the full event history the carrier has for a tracking number
+	Track(ctx context.Context, trackingNumber string) ([]models.TrackingEvent, error)
+	// turns a raw webhook body pushed by the carrier into status updates
+	ParseWebhook(body []byte) ([]StatusUpdate, error)
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[string]Carrier{}
+)
+
+func Register(carrier Carrier) {
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	registry[carrier.Name()] = carrier
+
+}
+
+func Get(name string) (Carrier, error) {
+
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	carrier, ok := registry[name]
+
+	if !ok {
+		return nil, ErrUnknownCarrier
+	}
+
+	return carrier, nil
+
+}
+
+func init() {
+	Register(NewSimulatedCarrier(SimulatedStepInterval))
+}
@@ -0,0 +1,132 @@
+package carriers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+)
+
+const SimulatedCarrierName = "simulated"
+
+// how long the simulated parcel stays in each state before moving to the next one
+
+var SimulatedStepInterval = 2 * time.Minute
+
+// a local carrier used for development, it never leaves the process
+// the booking time is encoded in the tracking number, so tracking is stateless and survives restarts
+// eg : SIM-lq2v8k-6650f1c2a9e4 -> booked at base36(lq2v8k) unix seconds
+
+type SimulatedCarrier struct {
+	stepInterval time.Duration
+}
+
+func NewSimulatedCarrier(stepInterval time.Duration) *SimulatedCarrier {
+
+	return &SimulatedCarrier{
+		stepInterval: stepInterval,
+	}
+}
+
+var simulatedStages = []models.TrackingEvent{
+	{Status: models.ShipmentShipped, Description: "Shipment picked up by carrier", Location: "Origin facility"},
+	{Status: models.ShipmentInTransit, Description: "Shipment in transit", Location: "Regional hub"},
+	{Status: models.ShipmentOutForDelivery, Description: "Out for delivery", Location: "Destination facility"},
+	{Status: models.ShipmentDelivered, Description: "Delivered", Location: "Destination"},
+}
+
+func (carrier *SimulatedCarrier) Name() string {
+	return SimulatedCarrierName
+}
+
+func (carrier *SimulatedCarrier) CreateShipment(ctx context.Context, shipment models.Shipment) (string, error) {
+
+	bookedAt := strconv.FormatInt(time.Now().Unix(), 36)
+	return fmt.Sprintf("SIM-%s-%s", bookedAt, shipment.Shipment_ID.Hex()[12:]), nil
+
+}
+
+func (carrier *SimulatedCarrier) Track(ctx context.Context, trackingNumber string) ([]models.TrackingEvent, error) {
+
+	parts := strings.Split(trackingNumber, "-")
+
+	if len(parts) != 3 || parts[0] != "SIM" {
+		return nil, ErrInvalidTracking
+	}
+
+	bookedAtUnix, err := strconv.ParseInt(parts[1], 36, 64)
+
+	if err != nil {
+		return nil, ErrInvalidTracking
+	}
+
+	bookedAt := time.Unix(bookedAtUnix, 0)
+	events := make([]models.TrackingEvent, 0, len(simulatedStages))
+
+	for index, stage := range simulatedStages {
+
+		occurredAt := bookedAt.Add(time.Duration(index) * carrier.stepInterval)
+
+		if occurredAt.After(time.Now()) {
+			break
+		}
+
+		stage.Occurred_At = occurredAt
+		events = append(events, stage)
+
+	}
+
+	return events, nil
+
+}
+
+// the simulated webhook body mirrors what we expose, so it can be driven by hand with curl
+// eg : [{"tracking_number": "SIM-...", "status": "DELIVERED", "description": "Left at door"}]
+
+func (carrier *SimulatedCarrier) ParseWebhook(body []byte) ([]StatusUpdate, error) {
+
+	var payload []struct {
+		Tracking_Number string    `json:"tracking_number"`
+		Status          string    `json:"status"`
+		Description     string    `json:"description"`
+		Location        string    `json:"location"`
+		Occurred_At     time.Time `json:"occurred_at"`
+	}
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, ErrInvalidWebhook
+	}
+
+	updates := make([]StatusUpdate, 0, len(payload))
+
+	for _, entry := range payload {
+
+		if entry.Tracking_Number == "" || entry.Status == "" {
+			return nil, ErrInvalidWebhook
+		}
+
+		if entry.Occurred_At.IsZero() {
+			entry.Occurred_At = time.Now()
+		}
+
+		updates = append(updates, StatusUpdate{
+			Tracking_Number: entry.Tracking_Number,
+			Event: models.TrackingEvent{
+				Status:      entry.Status,
+				Description: entry.Description,
+				Location:    entry.Location,
+				Occurred_At: entry.Occurred_At,
+			},
+		})
+
+	}
+
+	return updates, nil
+
+}
+
+var _ Carrier = (*SimulatedCarrier)(nil)
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/importer"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// imports or exports the catalog without going through the API
+// eg : go run ./cmd/importer -file products.csv
+//      go run ./cmd/importer -export -format jsonl -file products.jsonl
+
+func main() {
+
+	path := flag.String("file", "", "file to import from, or to export to")
+	format := flag.String("format", "", "csv or jsonl, taken from the file extension when empty")
+	export := flag.Bool("export", false, "export the catalog instead of importing")
+	flag.Parse()
+
+	if *path == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*path), ".")
+	}
+
+	context := context.Background()
+
+	if err := database.WaitConnected(context, database.Client); err != nil {
+		log.Fatal(err)
+	}
+
+	productImporter := importer.NewImporter(
+		database.ProductData(database.Client, "Products"),
+		database.CategoryData(database.Client, "Categories"),
+		nil,
+	)
+
+	if *export {
+
+		file, err := os.Create(*path)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err = productImporter.Export(context, *format, file); err != nil {
+			log.Fatal(err)
+		}
+
+		if err = file.Close(); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+
+	}
+
+	file, err := os.Open(*path)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer file.Close()
+
+	job := models.ImportJob{Job_ID: primitive.NewObjectID(), Format: *format, Errors: make([]models.ImportRowError, 0)}
+
+	err = productImporter.Run(context, &job, file, func(progress models.ImportJob) {
+		fmt.Fprintf(os.Stderr, "processed %d, inserted %d, updated %d, failed %d\n", progress.Processed, progress.Inserted, progress.Updated, progress.Failed)
+	})
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, _ := json.MarshalIndent(job, "", "  ")
+	fmt.Println(string(report))
+
+	if job.Failed > 0 {
+		os.Exit(1)
+	}
+
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/abandonment"
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/notifications"
+	"github.com/gin-gonic/gin"
+)
+
+var CartReminders = abandonment.NewScheduler(UserCollection, notifierFromEnv(), abandonment.ConfigFromEnv())
+
+func notifierFromEnv() notifications.Notifier {
+
+	notifier, err := notifications.NewFromEnv()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return notifier
+
+}
+
+type ReminderRun struct {
+	Sent int `json:"sent"`
+}
+
+func GetAbandonedCartMetrics() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		metrics, err := database.GetAbandonedCartMetrics(context, UserCollection, CartReminders.Config().Idle_Threshold)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, metrics)
+		return nil
+
+	})
+
+}
+
+// sends the due reminders right away instead of waiting for the next scheduled run
+
+func SendCartReminders() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		sent, err := CartReminders.RunOnce(context)
+
+		if err != nil {
+			// reminders sent before the failure are still reported
+			return apierror.From(err).WithDetails(ReminderRun{Sent: sent})
+		}
+
+		ctx.IndentedJSON(http.StatusOK, ReminderRun{Sent: sent})
+		return nil
+
+	})
+
+}
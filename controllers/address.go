@@ -3,276 +3,354 @@ package controllers
 import (
 	"context"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
 	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"github.com/aaravmahajanofficial/ecommerce-project/postal"
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func AddAddress() gin.HandlerFunc {
+type AddressValidation struct {
+	Valid       bool                  `json:"valid"`
+	Serviceable bool                  `json:"serviceable"`
+	Field       string                `json:"field,omitempty"`
+	Error       string                `json:"error,omitempty"`
+	Errors      []apierror.FieldError `json:"errors,omitempty"`
+	Address     *models.Address       `json:"address,omitempty"`
+}
 
-	return func(ctx *gin.Context) {
+func addressFromBody(ctx *gin.Context) (models.Address, error) {
 
-		userIDFromQuery := ctx.Query("id")
+	var address models.Address
 
-		if userIDFromQuery == "" {
-			log.Println("User ID is empty")
-			ctx.Header("Content-Type", "application/json")
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"ERROR": "UserID is empty."})
-			ctx.Abort()
-			return
-		}
+	if err := bindJSON(ctx, &address); err != nil {
+		return models.Address{}, err
+	}
 
-		userID, err := primitive.ObjectIDFromHex(userIDFromQuery)
+	// the postal code decides city, district and state, and whether we deliver there
+	if err := postal.Places.Complete(&address); err != nil {
+		return models.Address{}, apierror.From(err).WithDetails([]apierror.FieldError{{Field: "pin_code", Rule: "postal_code", Message: err.Error()}})
+	}
 
-		if err != nil {
-			log.Println(err)
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
-			return
-		}
+	return address, nil
 
-		var newAddress models.Address
-		newAddress.Address_id = primitive.NewObjectID()
+}
 
-		if err = ctx.BindJSON(&newAddress); err != nil {
-			ctx.JSON(http.StatusNotAcceptable, gin.H{"error": "Invalid JSON data"})
-			return
-		}
+// eg : PUT /addresses/default?id=<address id>&type=shipping
 
-		context, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
+type DefaultAddressRequest struct {
+	Address_ID primitive.ObjectID `uri:"id" form:"id" validate:"required"`
+	Type       string             `form:"type" validate:"required,oneof=shipping billing" doc:"which default to set"`
+}
 
-		// aggregate the records, of the user
+// eg : GET /postalcodes/560001?country=IN
 
-		filter := bson.D{{Key: "$match", Value: bson.D{{Key: "_id", Value: userID}}}}
-		unwind := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$address"}}}}
-		group := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$address_value"}, primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}}}}}
+type PostalCodeRequest struct {
+	Code    string `uri:"code" validate:"required,max=20"`
+	Country string `form:"country" validate:"omitempty,len=2" doc:"ISO 3166 code, defaults to IN"`
+}
 
-		// eg :
-		/*
-			1. get the user record with id -> userId
-			{
-			  "$match": {
-			    "_id": ObjectId("60e91db17f20d4d4f899fb56")
-			  }
-			}
+// lets the frontend check an address while it is typed, the response always has status 200 and
+// carries the completed address when it is valid, eg :
+// {"valid": false, "serviceable": false, "field": "pin_code", "error": "postal code does not match the format of the country"}
+// every invalid field is listed in "errors", "field" and "error" repeat the first one
 
-			2. after unwinding the documents ->
-				{
-					"_id": ObjectId("60e91db17f20d4d4f899fb56"),
-					"name": "John Doe",
-					"address": { "address_id": ObjectId("60e91db17f20d4d4f899fb57"), "city": "New York" }
-				},
-				{
-					"_id": ObjectId("60e91db17f20d4d4f899fb56"),
-					"name": "John Doe",
-					"address": { "address_id": ObjectId("60e91db17f20d4d4f899fb58"), "city": "San Francisco" }
-				}
+func ValidateAddress() gin.HandlerFunc {
 
-			3. Groups by address id
+	return apierror.Handle(func(ctx *gin.Context) error {
 
-				[
-					{ "_id": ObjectId("60e91db17f20d4d4f899fb57"), "count": 1 },
-					{ "_id": ObjectId("60e91db17f20d4d4f899fb58"), "count": 1 }
-				]
+		var address models.Address
 
-		*/
+		if err := bindJSON(ctx, &address); err != nil {
 
-		cursor, err := UserCollection.Aggregate(context, mongo.Pipeline{filter, unwind, group})
+			var validationErrors validator.ValidationErrors
+
+			if !errors.As(err, &validationErrors) {
+				return err
+			}
+
+			details := apierror.ValidationDetails(validationErrors)
+			ctx.IndentedJSON(http.StatusOK, AddressValidation{Field: details[0].Field, Error: details[0].Message, Errors: details})
+			return nil
 
-		if err != nil {
-			log.Println(err)
-			ctx.IndentedJSON(http.StatusInternalServerError, "Internal Server Error")
-			return
 		}
 
-		defer cursor.Close(context)
+		if err := postal.Places.Complete(&address); err != nil {
+			details := []apierror.FieldError{{Field: "pin_code", Rule: "postal_code", Message: err.Error()}}
+			ctx.IndentedJSON(http.StatusOK, AddressValidation{Field: "pin_code", Error: err.Error(), Errors: details})
+			return nil
+		}
+
+		ctx.IndentedJSON(http.StatusOK, AddressValidation{Valid: true, Serviceable: address.Serviceable, Address: &address})
+		return nil
 
-		var currentAddresses []bson.M
+	})
 
-		if err = cursor.All(context, &currentAddresses); err != nil {
-			log.Println(err)
-			ctx.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "error fetching adddress details"})
-			return
+}
+
+// autofill for a single postal code
+
+func LookupPostalCode() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request PostalCodeRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
 		}
 
-		var totalAddresses int32
+		place, err := postal.Places.Lookup(request.Country, request.Code)
 
-		for _, value := range currentAddresses {
-			totalAddresses += value["count"].(int32)
+		if err != nil {
+			return err
 		}
 
-		if totalAddresses < 2 {
+		ctx.IndentedJSON(http.StatusOK, place)
+		return nil
 
-			// add the address
-			filter := bson.D{primitive.E{Key: "_id", Value: userID}}
-			update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "address", Value: newAddress}}}}
-			_, err := UserCollection.UpdateOne(context, filter, update)
+	})
 
-			if err != nil {
-				ctx.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to update data"})
-				return
-			}
+}
 
-			ctx.IndentedJSON(http.StatusOK, gin.H{"message": "Address added successfully"})
+func ListAddresses() gin.HandlerFunc {
 
-		} else {
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
 
-			ctx.IndentedJSON(http.StatusBadRequest, "Not Allowed")
+		addresses, err := database.ListAddresses(context, UserCollection, ctx.GetString("UID"))
 
+		if err != nil {
+			return err
 		}
 
-	}
+		ctx.IndentedJSON(http.StatusOK, addresses)
+		return nil
+
+	})
 
 }
 
-func EditHomeAddress() gin.HandlerFunc {
+func CreateAddress() gin.HandlerFunc {
 
-	return func(ctx *gin.Context) {
+	return apierror.Handle(func(ctx *gin.Context) error {
 
-		userIDFromQuery := ctx.Query("id")
+		address, err := addressFromBody(ctx)
 
-		if userIDFromQuery == "" {
-			log.Println("User ID is empty")
-			ctx.Header("Content-Type", "application/json")
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"ERROR": "UserID is empty."})
-			ctx.Abort()
-			return
+		if err != nil {
+			return err
 		}
 
-		userID, err := primitive.ObjectIDFromHex(userIDFromQuery)
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		address, err = database.AddAddress(context, UserCollection, ctx.GetString("UID"), address)
 
 		if err != nil {
-			log.Println(err)
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
-			return
+			return err
 		}
 
-		var newAddress models.Address
+		ctx.IndentedJSON(http.StatusCreated, address)
+		return nil
+
+	})
 
-		if err = ctx.BindJSON(&newAddress); err != nil {
+}
 
-			ctx.JSON(http.StatusNotAcceptable, gin.H{"error": "Invalid JSON data"})
-			return
+func UpdateAddress() gin.HandlerFunc {
 
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var target IDRequest
+
+		if err := bindParams(ctx, &target); err != nil {
+			return err
+		}
+
+		address, err := addressFromBody(ctx)
+
+		if err != nil {
+			return err
 		}
 
-		context, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
 		defer cancel()
 
-		filter := bson.D{primitive.E{Key: "_id", Value: userID}}
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address.0.house_name", Value: newAddress.House}, primitive.E{Key: "address.0.street_name", Value: newAddress.Street}, primitive.E{Key: "address.0.city_name", Value: newAddress.City}, primitive.E{Key: "address.0.pin_code", Value: newAddress.Pincode}}}}
-		_, err = UserCollection.UpdateOne(context, filter, update)
+		address, err = database.UpdateAddress(context, UserCollection, ctx.GetString("UID"), target.ID, address)
 
 		if err != nil {
-			ctx.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
-			return
+			return err
 		}
 
-		ctx.IndentedJSON(http.StatusOK, "Successfully updated home address")
+		ctx.IndentedJSON(http.StatusOK, address)
+		return nil
 
-	}
+	})
 
 }
 
-func EditWorkAddress() gin.HandlerFunc {
+func RemoveAddress() gin.HandlerFunc {
 
-	return func(ctx *gin.Context) {
+	return apierror.Handle(func(ctx *gin.Context) error {
 
-		userIDFromQuery := ctx.Query("id")
+		var request IDRequest
 
-		if userIDFromQuery == "" {
-			log.Println("User ID is empty")
-			ctx.Header("Content-Type", "application/json")
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"ERROR": "UserID is empty."})
-			ctx.Abort()
-			return
+		if err := bindParams(ctx, &request); err != nil {
+			return err
 		}
 
-		userID, err := primitive.ObjectIDFromHex(userIDFromQuery)
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
 
-		if err != nil {
-			log.Println(err)
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
-			return
+		if err := database.DeleteAddress(context, UserCollection, ctx.GetString("UID"), request.ID); err != nil {
+			return err
 		}
 
-		var newAddress models.Address
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Successfully deleted the address"})
+		return nil
+
+	})
 
-		if err = ctx.BindJSON(&newAddress); err != nil {
+}
+
+func SetDefaultAddress() gin.HandlerFunc {
 
-			ctx.JSON(http.StatusNotAcceptable, gin.H{"error": "Invalid JSON data"})
-			return
+	return apierror.Handle(func(ctx *gin.Context) error {
 
+		var request DefaultAddressRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
 		}
 
-		context, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
 		defer cancel()
 
-		filter := bson.D{primitive.E{Key: "_id", Value: userID}}
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address.1.house_name", Value: newAddress.House}, primitive.E{Key: "address.1.street_name", Value: newAddress.Street}, primitive.E{Key: "address.1.city_name", Value: newAddress.City}, primitive.E{Key: "address.1.pin_code", Value: newAddress.Pincode}}}}
-		_, err = UserCollection.UpdateOne(context, filter, update)
+		if err := database.SetDefaultAddress(context, UserCollection, ctx.GetString("UID"), request.Address_ID, request.Type); err != nil {
+			return err
+		}
+
+		addresses, err := database.ListAddresses(context, UserCollection, ctx.GetString("UID"))
 
 		if err != nil {
-			ctx.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
-			return
+			return err
 		}
 
-		ctx.IndentedJSON(http.StatusOK, "Successfully updated home address")
+		ctx.IndentedJSON(http.StatusOK, addresses)
+		return nil
 
-	}
+	})
 
 }
 
-func DeleteAddress() gin.HandlerFunc {
+func AddAddress() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
 
-	return func(ctx *gin.Context) {
+		newAddress, err := addressFromBody(ctx)
 
-		// get the user id from the query
+		if err != nil {
+			return err
+		}
 
-		userIDFromQuery := ctx.Query("id")
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
 
-		if userIDFromQuery == "" {
-			log.Println("User ID is empty")
-			ctx.Header("Content-Type", "application/json")
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"ERROR": "UserID is empty."})
-			ctx.Abort()
-			return
+		if _, err = database.AddAddress(context, UserCollection, ctx.GetString("UID"), newAddress); err != nil {
+			return err
 		}
 
-		userId, err := primitive.ObjectIDFromHex(userIDFromQuery)
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Address added successfully"})
+		return nil
+
+	})
+
+}
+
+// the home and work routes predate the address book, they edit the first and second address of the book
+
+func editAddressAt(ctx *gin.Context, position int) error {
+
+	newAddress, err := addressFromBody(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+	defer cancel()
+
+	addresses, err := database.ListAddresses(context, UserCollection, ctx.GetString("UID"))
+
+	if err != nil {
+		return err
+	}
+
+	if position >= len(addresses) {
+		return database.ErrCantFindAddress
+	}
+
+	if _, err = database.UpdateAddress(context, UserCollection, ctx.GetString("UID"), addresses[position].Address_id, newAddress); err != nil {
+		return err
+	}
+
+	ctx.IndentedJSON(http.StatusOK, Message{Message: "Successfully updated address"})
+	return nil
+
+}
+
+func EditHomeAddress() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+		return editAddressAt(ctx, 0)
+	})
+
+}
+
+func EditWorkAddress() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+		return editAddressAt(ctx, 1)
+	})
+
+}
+
+func DeleteAddress() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		userID, err := primitive.ObjectIDFromHex(ctx.GetString("UID"))
 
 		if err != nil {
-			log.Println(err)
-			ctx.AbortWithError(http.StatusInternalServerError, errors.New("Internal Server Error"))
-			return
+			return database.ErrUserIDIsNotValid
 		}
 
 		addresses := make([]models.Address, 0)
 
-		context, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
 		defer cancel()
 
-		// get this user from the documents
-		filter := bson.D{primitive.E{Key: "_id", Value: userId}}
-		updatedValue := bson.D{primitive.E{Key: "$set", Value: primitive.E{Key: "address", Value: addresses}}}
-
-		_, err = UserCollection.UpdateOne(context, filter, updatedValue)
-
-		if err != nil {
-			log.Println(err)
-			ctx.IndentedJSON(http.StatusNotFound, gin.H{"ERROR": "Something Went Wrong!"})
-			return
+		// clears the whole address book, single addresses are removed through DELETE /addresses
+		filter := bson.D{primitive.E{Key: "_id", Value: userID}}
+		updatedValue := bson.D{primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "address", Value: addresses}}}}
 
+		if _, err = UserCollection.UpdateOne(context, filter, updatedValue); err != nil {
+			slog.ErrorContext(ctx.Request.Context(), "DeleteAddress failed", "error", err)
+			return err
 		}
 
-		ctx.IndentedJSON(http.StatusOK, gin.H{"Message": "Successfully Deleted!"})
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Successfully Deleted!"})
+		return nil
 
-	}
+	})
 
 }
@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-playground/validator/v10/non-standard/validators"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var Validate = newValidator()
+
+// errors name fields the way clients send them, the json key for bodies, the query key for query strings
+// and the parameter name for paths
+
+func newValidator() *validator.Validate {
+
+	validate := validator.New()
+
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+
+		for _, tag := range []string{"json", "form", "uri"} {
+			if name, _, _ := strings.Cut(field.Tag.Get(tag), ","); name != "" && name != "-" {
+				return name
+			}
+		}
+
+		return ""
+
+	})
+
+	validate.RegisterValidation("currency", func(field validator.FieldLevel) bool {
+		return models.IsSupportedCurrency(strings.ToUpper(field.Field().String()))
+	})
+	validate.RegisterValidation("notblank", validators.NotBlank)
+
+	if err := apierror.RegisterTranslations(validate); err != nil {
+		log.Fatal(err)
+	}
+
+	for rule, text := range map[string]string{
+		"currency": "{0} must be a supported currency code",
+		"notblank": "{0} must not be blank",
+	} {
+		if err := apierror.RegisterTranslation(validate, rule, text); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return validate
+
+}
+
+var objectIDType = reflect.TypeOf(primitive.ObjectID{})
+
+// routes that only act on a single resource, eg : /api/v1/reviews/:id or /reviews?id=...
+
+type IDRequest struct {
+	ID primitive.ObjectID `uri:"id" form:"id" validate:"required"`
+}
+
+// what a parameter that could not be converted had to be, eg : "limit must be a number"
+var conversionMessages = map[string]string{
+	"objectid": "must be a valid ID",
+	"number":   "must be a number",
+	"boolean":  "must be true or false",
+}
+
+// every handler reads its input into a request struct and has it checked here before using it, eg :
+/*
+	type reviewListRequest struct {
+		Product_ID primitive.ObjectID `form:"productID" validate:"required"`
+		Limit      int                `form:"limit" validate:"omitempty,min=1,max=100"`
+	}
+*/
+// query fields carry a "form" tag, a "uri" tag names the path parameter v1 routes carry the value in,
+// routes without that parameter, eg : the legacy ones, send it in the query under the "form" name instead
+// eg : `uri:"id" form:"orderID"` reads /api/v1/orders/:id/tracking and /orders/tracking?orderID=...
+
+func bindParams(ctx *gin.Context, request any) error {
+
+	value := reflect.ValueOf(request).Elem()
+	details := make([]apierror.FieldError, 0)
+
+	for index := 0; index < value.NumField(); index++ {
+
+		field := value.Type().Field(index)
+		name, _, _ := strings.Cut(field.Tag.Get("form"), ",")
+		param := field.Tag.Get("uri")
+
+		var values []string
+		var ok bool
+
+		_, inPath := ctx.Params.Get(param)
+
+		switch {
+		case param != "" && (inPath || name == ""):
+			name = param
+			values, ok = []string{ctx.Param(param)}, true
+		case name != "":
+			values, ok = ctx.GetQueryArray(name)
+			// fields of a multipart body count too, eg : the "alt" of an image upload
+			// urlencoded bodies are left alone, raw uploads are often sent with that content type
+			if !ok && ctx.ContentType() == binding.MIMEMultipartPOSTForm {
+				values, ok = ctx.GetPostFormArray(name)
+			}
+		default:
+			continue
+		}
+
+		// an empty value is the same as a missing one, eg : ?variantID=
+		if !ok || (len(values) == 1 && values[0] == "") {
+			continue
+		}
+
+		if rule := setParamValue(value.Field(index), values); rule != "" {
+			details = append(details, apierror.FieldError{Field: name, Rule: rule, Message: name + " " + conversionMessages[rule]})
+		}
+
+	}
+
+	if len(details) > 0 {
+		return apierror.ValidationFailed(details)
+	}
+
+	return Validate.Struct(request)
+
+}
+
+// returns the rule the value broke, "" once it is set
+
+func setParamValue(field reflect.Value, values []string) string {
+
+	switch {
+	case field.Type() == objectIDType:
+		id, err := primitive.ObjectIDFromHex(values[0])
+		if err != nil {
+			return "objectid"
+		}
+		field.Set(reflect.ValueOf(id))
+		return ""
+	case field.Kind() == reflect.Pointer:
+		target := reflect.New(field.Type().Elem())
+		if rule := setParamValue(target.Elem(), values); rule != "" {
+			return rule
+		}
+		field.Set(target)
+		return ""
+	case field.Kind() == reflect.Slice:
+		field.Set(reflect.ValueOf(values))
+		return ""
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(values[0])
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		number, err := strconv.ParseInt(values[0], 10, field.Type().Bits())
+		if err != nil {
+			return "number"
+		}
+		field.SetInt(number)
+	case reflect.Float64:
+		number, err := strconv.ParseFloat(values[0], 64)
+		if err != nil {
+			return "number"
+		}
+		field.SetFloat(number)
+	case reflect.Bool:
+		flag, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return "boolean"
+		}
+		field.SetBool(flag)
+	}
+
+	return ""
+
+}
+
+// a body of the wrong type for a field is reported against that field, anything else unreadable as invalid JSON
+
+func bindJSON(ctx *gin.Context, request any) error {
+
+	if err := ctx.ShouldBindJSON(request); err != nil {
+
+		var typeError *json.UnmarshalTypeError
+
+		if errors.As(err, &typeError) && typeError.Field != "" {
+			return apierror.ValidationFailed([]apierror.FieldError{{Field: typeError.Field, Rule: "type", Message: typeError.Field + " must be " + jsonKind(typeError.Type)}})
+		}
+
+		slog.DebugContext(ctx.Request.Context(), "request body is not valid JSON", "error", err)
+		return apierror.ErrInvalidJSON
+
+	}
+
+	return Validate.Struct(request)
+
+}
+
+// both halves of a request, eg : the product from the query and the review from the body
+
+func bindRequest(ctx *gin.Context, query any, body any) error {
+
+	if err := bindParams(ctx, query); err != nil {
+		return err
+	}
+
+	return bindJSON(ctx, body)
+
+}
+
+func jsonKind(valueType reflect.Type) string {
+
+	for valueType.Kind() == reflect.Pointer {
+		valueType = valueType.Elem()
+	}
+
+	switch valueType.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	default:
+		return "a number"
+	}
+
+}
@@ -2,15 +2,15 @@ package controllers
 
 import (
 	"context"
-	"errors"
-	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/currency"
 	"github.com/aaravmahajanofficial/ecommerce-project/database"
 	"github.com/aaravmahajanofficial/ecommerce-project/models"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -28,244 +28,223 @@ func NewApplication(productCollection *mongo.Collection, userCollection *mongo.C
 	}
 }
 
-// use "AbortWithError", when dealing with critical functions, like validation errors, database queries, authorization errors and "JSON" or "IndentedJSON" only when simple logging like success code etc.
+type CartListing struct {
+	Total                    models.Money         `json:"total"`
+	UserCart                 []models.ProductUser `json:"userCart"`
+	Warnings                 []models.CartWarning `json:"warnings"`
+	Requires_Acknowledgement bool                 `json:"requires_acknowledgement"`
+}
 
-func (app *Application) AddToCart() gin.HandlerFunc {
+// the "currency" query parameter is optional and defaults to the base currency of the store,
+// request structs check it with the "currency" rule
 
-	return func(ctx *gin.Context) {
+func displayCurrency(code string) string {
 
-		// get the product id from the query parameter
-		productQueryID := ctx.Query("id")
+	if code == "" {
+		return currency.Rates.Base()
+	}
 
-		if productQueryID == "" {
-			log.Println("Product ID is empty")
-			ctx.AbortWithError(http.StatusBadRequest, errors.New("ProductID is empty."))
-			return
-		}
+	return strings.ToUpper(code)
 
-		userQueryID := ctx.Query("userID")
+}
 
-		if userQueryID == "" {
-			log.Println("User ID is empty")
-			ctx.AbortWithError(http.StatusBadRequest, errors.New("UserID is empty."))
-			return
-		}
+// "variantID" is optional, products with variants reject requests without it,
+// the cart is always the signed in user's, whatever user id a legacy client still sends
 
-		productID, err := primitive.ObjectIDFromHex(productQueryID)
+type AddToCartRequest struct {
+	Product_ID primitive.ObjectID  `form:"id" validate:"required" doc:"product ID"`
+	Variant_ID *primitive.ObjectID `form:"variantID" doc:"required for products with variants"`
+}
 
-		if err != nil {
-			log.Println(err)
-			ctx.AbortWithStatus(http.StatusInternalServerError)
-			return
+type RemoveItemRequest struct {
+	Product_ID primitive.ObjectID  `uri:"id" form:"id" validate:"required" doc:"product ID"`
+	Variant_ID *primitive.ObjectID `form:"variantID" doc:"required for products with variants"`
+}
 
-		}
+type CartRequest struct {
+	Currency string `form:"currency" validate:"omitempty,currency" doc:"ISO 4217 code prices are converted to, defaults to the base currency"`
+}
 
-		context, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
+// "shippingAddressID" and "billingAddressID" pick entries of the address book, the flagged defaults are used without them
 
-		err = database.AddProductToCart(context, app.productsCollection, app.usersCollection, productID, userQueryID)
+type CheckoutRequest struct {
+	Currency            string              `form:"currency" validate:"omitempty,currency" doc:"ISO 4217 code prices are converted to, defaults to the base currency"`
+	Shipping_Address_ID *primitive.ObjectID `form:"shippingAddressID" doc:"defaults to the default shipping address"`
+	Billing_Address_ID  *primitive.ObjectID `form:"billingAddressID" doc:"defaults to the default billing address"`
+}
 
-		if err != nil {
-			ctx.IndentedJSON(http.StatusInternalServerError, err)
-		}
+type InstantBuyRequest struct {
+	Product_ID          primitive.ObjectID  `form:"id" validate:"required" doc:"product ID"`
+	Variant_ID          *primitive.ObjectID `form:"variantID" doc:"required for products with variants"`
+	Currency            string              `form:"currency" validate:"omitempty,currency" doc:"ISO 4217 code prices are converted to, defaults to the base currency"`
+	Shipping_Address_ID *primitive.ObjectID `form:"shippingAddressID" doc:"defaults to the default shipping address"`
+	Billing_Address_ID  *primitive.ObjectID `form:"billingAddressID" doc:"defaults to the default billing address"`
+}
 
-		ctx.IndentedJSON(200, "Successfully Added to Cart")
+func (app *Application) AddToCart() gin.HandlerFunc {
 
-	}
+	return apierror.Handle(func(ctx *gin.Context) error {
 
-}
+		var request AddToCartRequest
 
-func (app *Application) RemoveItem() gin.HandlerFunc {
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
 
-	return func(ctx *gin.Context) {
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
 
-		productQueryID := ctx.Query("id")
-		if productQueryID == "" {
-			log.Println("Product ID is empty")
-			ctx.AbortWithError(http.StatusBadRequest, errors.New("ProductID is empty."))
-			return
+		if err := database.AddProductToCart(context, app.productsCollection, app.usersCollection, request.Product_ID, request.Variant_ID, ctx.GetString("UID")); err != nil {
+			return err
 		}
 
-		userQueryID := ctx.Query("userId")
-		if userQueryID == "" {
-			log.Println("User ID is empty")
-			ctx.AbortWithError(http.StatusBadRequest, errors.New("UserID is empty."))
-			return
-		}
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Successfully Added to Cart"})
+		return nil
 
-		productId, err := primitive.ObjectIDFromHex(productQueryID)
+	})
 
-		if err != nil {
-			log.Println(err)
-			ctx.AbortWithStatus(http.StatusInternalServerError)
-			return
+}
+
+func (app *Application) RemoveItem() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
 
+		var request RemoveItemRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
 		}
 
-		context, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
 		defer cancel()
 
-		err = database.RemoveCartItem(context, app.productsCollection, app.usersCollection, productId, userQueryID)
-
-		if err != nil {
-			ctx.IndentedJSON(http.StatusInternalServerError, err)
+		if err := database.RemoveCartItem(context, app.productsCollection, app.usersCollection, request.Product_ID, request.Variant_ID, ctx.GetString("UID")); err != nil {
+			return err
 		}
 
-		ctx.IndentedJSON(200, "Successfully Removed from Cart")
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Successfully Removed from Cart"})
+		return nil
 
-	}
+	})
 
 }
 
 func GetItemFromCart() gin.HandlerFunc {
 
-	return func(ctx *gin.Context) {
-
-		userIDFromQuery := ctx.Query("id")
-
-		if userIDFromQuery == "" {
-			log.Println("User ID is empty")
-			ctx.Header("Content-Type", "application/json")
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"ERROR": "UserID is empty."})
-			ctx.Abort()
-			return
-		}
+	return apierror.Handle(func(ctx *gin.Context) error {
 
-		userID, err := primitive.ObjectIDFromHex(userIDFromQuery)
+		var request CartRequest
 
-		if err != nil {
-			log.Println(err)
-			ctx.IndentedJSON(http.StatusInternalServerError, gin.H{"ERROR": "Internal Server Error"})
-			return
+		if err := bindParams(ctx, &request); err != nil {
+			return err
 		}
 
-		context, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
 		defer cancel()
 
-		var userModel models.User
+		// the cart is validated against the current catalog every time it is listed
+		cartItems, warnings, err := database.RefreshCart(context, ProductsCollection, UserCollection, ctx.GetString("UID"))
 
-		err = UserCollection.FindOne(context, bson.D{primitive.E{Key: "_id", Value: userID}}).Decode(&userModel)
+		if err != nil {
+			return err
+		}
 
-		// get the user documents
+		if len(cartItems) == 0 && len(warnings) == 0 {
+			return database.ErrCartIsEmpty
+		}
 
-		filter := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: userID}}}}
-		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$usercart"}}}}
-		group := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, primitive.E{Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}}}}}}
+		// prices are shown in the requested currency, lines saved in another currency are converted for display only
 
-		cursor, err := UserCollection.Aggregate(context, mongo.Pipeline{filter, unwind, group})
+		pricedItems, total, err := database.PriceCartItems(cartItems, displayCurrency(request.Currency))
 
 		if err != nil {
-			log.Println("Error in aggregation:", err)
-			ctx.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "error calculating cart total"})
-			return
+			return err
 		}
 
-		defer cursor.Close(context)
-
-		var results []bson.M
+		response := CartListing{
+			Total:                    total,
+			UserCart:                 pricedItems,
+			Warnings:                 warnings,
+			Requires_Acknowledgement: len(warnings) > 0,
+		}
 
-		if err = cursor.All(context, &results); err != nil {
+		ctx.IndentedJSON(http.StatusOK, response)
+		return nil
 
-			log.Println("Error fetching aggregation results:", err)
-			ctx.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "error fetching cart details"})
-			return
+	})
 
-		}
+}
 
-		if len(results) == 0 {
-			ctx.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "no items in cart"})
-			return
-		}
+// clears the warnings shown by the cart listing, checkout is possible again afterwards
 
-		// aggregation framework always returns an array of documents
+func AcknowledgeCartChanges() gin.HandlerFunc {
 
-		// eg :
-		/*
-			[
-				{
-					"_id": ObjectId("60d5f483f8658e6b9c8e4d65"),
-					"total": 3.7
-				}
-			]
-		*/
+	return apierror.Handle(func(ctx *gin.Context) error {
 
-		total := results[0]["total"]
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
 
-		response := gin.H{
-			"total":    total,
-			"userCart": userModel.UserCart,
+		if err := database.AcknowledgeCartWarnings(context, UserCollection, ctx.GetString("UID")); err != nil {
+			return err
 		}
 
-		ctx.IndentedJSON(http.StatusOK, response)
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Successfully acknowledged the cart changes"})
+		return nil
 
-	}
+	})
 
 }
 
-func (app *Application) BuyFromCart() gin.HandlerFunc {
+// changes the customer has not acknowledged come back as CART_CHANGED, with the warnings as details
 
-	return func(ctx *gin.Context) {
+func (app *Application) BuyFromCart() gin.HandlerFunc {
 
-		userQueryId := ctx.Query("userId")
+	return apierror.Handle(func(ctx *gin.Context) error {
 
-		if userQueryId == "" {
-			log.Print("User ID is empty")
+		var request CheckoutRequest
 
-			ctx.AbortWithError(http.StatusBadRequest, errors.New("UserID is empty."))
-			return
+		if err := bindParams(ctx, &request); err != nil {
+			return err
 		}
 
-		context, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
 		defer cancel()
 
-		err := database.BuyItemFromCart(context, app.usersCollection, userQueryId)
+		addressChoice := database.CheckoutAddresses{Shipping_ID: request.Shipping_Address_ID, Billing_ID: request.Billing_Address_ID}
 
-		if err != nil {
-			ctx.IndentedJSON(http.StatusInternalServerError, err)
+		if err := database.BuyItemFromCart(context, app.productsCollection, app.usersCollection, ctx.GetString("UID"), displayCurrency(request.Currency), addressChoice); err != nil {
+			return err
 		}
 
-		ctx.IndentedJSON(200, "Successfully Placed the Order")
-	}
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Successfully Placed the Order"})
+		return nil
+
+	})
 
 }
 
 func (app *Application) InstantBuy() gin.HandlerFunc {
 
-	return func(ctx *gin.Context) {
+	return apierror.Handle(func(ctx *gin.Context) error {
 
-		productQueryID := ctx.Query("id")
-		if productQueryID == "" {
-			log.Println("Product ID is empty")
-			ctx.AbortWithError(http.StatusBadRequest, errors.New("ProductID is empty."))
-			return
-		}
+		var request InstantBuyRequest
 
-		userQueryID := ctx.Query("userId")
-		if userQueryID == "" {
-			log.Println("User ID is empty")
-			ctx.AbortWithError(http.StatusBadRequest, errors.New("UserID is empty."))
-			return
+		if err := bindParams(ctx, &request); err != nil {
+			return err
 		}
 
-		productId, err := primitive.ObjectIDFromHex(productQueryID)
-
-		if err != nil {
-			log.Println(err)
-			ctx.AbortWithStatus(http.StatusInternalServerError)
-			return
-
-		}
-
-		context, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
 		defer cancel()
 
-		err = database.InstantBuy(context, app.productsCollection, app.usersCollection, productId, userQueryID)
+		addressChoice := database.CheckoutAddresses{Shipping_ID: request.Shipping_Address_ID, Billing_ID: request.Billing_Address_ID}
 
-		if err != nil {
-			ctx.IndentedJSON(http.StatusInternalServerError, err)
+		if err := database.InstantBuy(context, app.productsCollection, app.usersCollection, request.Product_ID, request.Variant_ID, ctx.GetString("UID"), displayCurrency(request.Currency), addressChoice); err != nil {
+			return err
 		}
 
-		ctx.IndentedJSON(200, "Successfully Placed the Order")
-	}
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Successfully Placed the Order"})
+		return nil
+
+	})
 
 }
@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"github.com/aaravmahajanofficial/ecommerce-project/search"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var CategoriesCollection = database.CategoryData(database.Client, "Categories")
+
+// the category followed by a page of its products, eg : {"category": {...}, "items": [...], "next_cursor": "...", ...}
+
+type CategoryProducts struct {
+	Category models.Category `json:"category"`
+	database.ProductPage
+}
+
+type CategoryProductsRequest struct {
+	Slug string `uri:"slug" form:"slug" validate:"required,max=100"`
+}
+
+// fields left out of the body keep their current value, sending "parent_id" moves the category with its subtree
+// and "move_to_root": true moves it back to the top level
+
+type CategoryChanges struct {
+	Name         *string             `json:"name" validate:"omitempty,min=2,max=60"`
+	Slug         string              `json:"slug" validate:"max=100"`
+	Description  *string             `json:"description" validate:"omitempty,max=1000"`
+	Parent_ID    *primitive.ObjectID `json:"parent_id"`
+	Move_To_Root bool                `json:"move_to_root"`
+}
+
+type ProductCategoriesRequest struct {
+	Category_IDs []primitive.ObjectID `json:"category_ids" validate:"required,min=1"`
+}
+
+func GetCategoryTree() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		tree, err := database.GetCategoryTree(context, CategoriesCollection)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, tree)
+		return nil
+
+	})
+
+}
+
+// products assigned to any category below the requested one are included
+
+func GetCategoryProducts() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request CategoryProductsRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		query, err := productListQueryFromRequest(ctx, "newest")
+
+		if err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		category, page, err := database.ListCategoryProducts(context, CategoriesCollection, ProductsCollection, request.Slug, query)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, CategoryProducts{Category: category, ProductPage: page})
+		return nil
+
+	})
+
+}
+
+func CreateCategory() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var category models.Category
+
+		if err := bindJSON(ctx, &category); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		category, err := database.CreateCategory(context, CategoriesCollection, category)
+
+		if err != nil {
+			return err
+		}
+
+		search.Suggestions.MarkDirty()
+		ctx.IndentedJSON(http.StatusCreated, category)
+		return nil
+
+	})
+
+}
+
+func UpdateCategory() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var target IDRequest
+		var changes CategoryChanges
+
+		if err := bindRequest(ctx, &target, &changes); err != nil {
+			return err
+		}
+
+		if changes.Move_To_Root && changes.Parent_ID != nil {
+			return apierror.ValidationFailed([]apierror.FieldError{{Field: "move_to_root", Rule: "excluded_with", Message: "move_to_root cannot be sent together with parent_id"}})
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		category, err := database.UpdateCategory(context, CategoriesCollection, target.ID, models.Category{
+			Name:        changes.Name,
+			Slug:        changes.Slug,
+			Description: changes.Description,
+			Parent_ID:   changes.Parent_ID,
+		}, changes.Move_To_Root)
+
+		if err != nil {
+			return err
+		}
+
+		search.Suggestions.MarkDirty()
+		ctx.IndentedJSON(http.StatusOK, category)
+		return nil
+
+	})
+
+}
+
+func DeleteCategory() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request IDRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		if err := database.DeleteCategory(context, CategoriesCollection, ProductsCollection, request.ID); err != nil {
+			return err
+		}
+
+		search.Suggestions.MarkDirty()
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Category deleted successfully"})
+		return nil
+
+	})
+
+}
+
+func SetProductCategories() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var product IDRequest
+		var request ProductCategoriesRequest
+
+		if err := bindRequest(ctx, &product, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		if err := database.SetProductCategories(context, CategoriesCollection, ProductsCollection, product.ID, request.Category_IDs); err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Product categories updated successfully"})
+		return nil
+
+	})
+
+}
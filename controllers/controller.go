@@ -3,14 +3,17 @@ package controllers
 import (
 	"context"
 	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
 	"github.com/aaravmahajanofficial/ecommerce-project/database"
 	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"github.com/aaravmahajanofficial/ecommerce-project/search"
 	generate "github.com/aaravmahajanofficial/ecommerce-project/tokens"
 	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
@@ -18,7 +21,19 @@ import (
 
 var UserCollection = database.UserData(database.Client, "Users")
 var ProductsCollection = database.ProductData(database.Client, "Products")
-var Validate = validator.New()
+
+// the body of responses that only confirm an action, eg : {"message": "Review deleted successfully"}
+
+type Message struct {
+	Message string `json:"message"`
+}
+
+// what the handlers derive their database contexts from, lines logged with it carry the request's id, user and route
+// it is not cancelled when the client hangs up, a checkout that reached the database is finished either way
+
+func requestContext(ctx *gin.Context) context.Context {
+	return context.WithoutCancel(ctx.Request.Context())
+}
 
 func HashPassword(password string) string {
 
@@ -50,56 +65,37 @@ func VerifyPassword(userPassword string, givenPassword string) (bool, string) {
 
 func SignUp() gin.HandlerFunc {
 
-	return func(ctx *gin.Context) {
+	return apierror.Handle(func(ctx *gin.Context) error {
 
-		context, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
 
 		defer cancel()
 
 		var user models.User
 
-		// convert the incoming json in this type
-		if err := ctx.BindJSON(&user); err != nil {
-			log.Println(err)
-			// 400 Error
-			ctx.JSON(http.StatusBadRequest, gin.H{"Error": user})
-			return
-
-		}
-
-		// used for validate checks applied in the User Model
-
-		validationError := Validate.Struct(user)
-
-		if validationError != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{"ERROR": validationError})
+		// convert the incoming json in this type, checked against the validate tags of the User Model
+		if err := bindJSON(ctx, &user); err != nil {
+			return err
 		}
 
 		count, err := UserCollection.CountDocuments(context, bson.M{"email": user.Email})
-		defer cancel()
 
 		if err != nil {
-			log.Panic(err)
-			ctx.JSON(http.StatusInternalServerError, gin.H{"ERROR": err})
-			return
+			return err
 		}
 
 		if count > 0 {
-			ctx.JSON(http.StatusBadRequest, gin.H{"ERROR": "User already exists"})
+			return apierror.Conflict("USER_EXISTS", "User already exists")
 		}
 
 		count, err = UserCollection.CountDocuments(context, bson.M{"phone": user.Phone})
-		defer cancel()
 
 		if err != nil {
-			log.Panic(err)
-			ctx.JSON(http.StatusInternalServerError, gin.H{"ERROR": err})
-			return
+			return err
 		}
 
 		if count > 0 {
-			ctx.JSON(http.StatusBadRequest, gin.H{"ERROR": "Phone number already in use"})
-			return
+			return apierror.Conflict("PHONE_IN_USE", "Phone number already in use")
 		}
 
 		// we want to create the new User model for insertion to the mongoDb collection
@@ -109,7 +105,9 @@ func SignUp() gin.HandlerFunc {
 
 		user.ID = primitive.NewObjectID()
 		user.User_ID = user.ID.Hex()
-		token, refreshToken, _ := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID)
+		// roles are never taken from the request, admins are promoted directly in the database
+		user.Role = models.RoleUser
+		token, refreshToken, _ := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID, user.Role)
 		user.Token = &token
 		user.Refresh_Token = &refreshToken
 		user.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
@@ -117,61 +115,67 @@ func SignUp() gin.HandlerFunc {
 		user.UserCart = make([]models.ProductUser, 0)
 		user.Address_Details = make([]models.Address, 0)
 		user.Order_Status = make([]models.Order, 0)
-		_, insertError := UserCollection.InsertOne(context, user)
 
-		if insertError != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"ERROR": "USER NOT CREATED"})
+		if _, err = UserCollection.InsertOne(context, user); err != nil {
+			return err
 		}
 
-		defer cancel()
+		mergeGuestCartOnSignIn(context, ctx, user.User_ID)
 
-		ctx.JSON(http.StatusCreated, "Successfully Signed Up!")
+		ctx.JSON(http.StatusCreated, Message{Message: "Successfully Signed Up!"})
+		return nil
 
-	}
+	})
+
+}
 
+type Credentials struct {
+	Email    *string `json:"email" validate:"required,email"`
+	Password *string `json:"password" validate:"required"`
 }
 
+// the same answer for an unknown email and a wrong password, so accounts can not be probed
+var errInvalidCredentials = apierror.Unauthorized("INVALID_CREDENTIALS", "Username or Password is Incorrect")
+
 func Login() gin.HandlerFunc {
 
-	return func(ctx *gin.Context) {
+	return apierror.Handle(func(ctx *gin.Context) error {
 
 		// initialize the context
 
-		context, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
 		defer cancel()
 
-		var user models.User
+		var credentials Credentials
 		var userDataFromDB models.User
 
 		// bind the JSON
 
-		if err := ctx.BindJSON(&user); err != nil {
-			log.Println(err)
-			ctx.JSON(http.StatusBadRequest, gin.H{"ERROR": err})
-			return
+		if err := bindJSON(ctx, &credentials); err != nil {
+			return err
 		}
 
-		err := UserCollection.FindOne(context, bson.M{"email": user.Email}).Decode(&userDataFromDB)
-		defer cancel()
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"ERROR": "Incorrect email or password!"})
-			return
+		if err := UserCollection.FindOne(context, bson.M{"email": credentials.Email}).Decode(&userDataFromDB); err != nil {
+			slog.ErrorContext(ctx.Request.Context(), "Login failed", "error", err)
+			return errInvalidCredentials
 		}
 
-		PasswordIsValid, msg := VerifyPassword(*user.Password, *userDataFromDB.Password)
-		defer cancel()
-
-		if !PasswordIsValid {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"ERROR": msg})
-			return
+		if PasswordIsValid, _ := VerifyPassword(*credentials.Password, *userDataFromDB.Password); !PasswordIsValid {
+			return errInvalidCredentials
 		}
 
-		token, refreshToken, _ := generate.TokenGenerator(*userDataFromDB.Email, *userDataFromDB.First_Name, *userDataFromDB.Last_Name, userDataFromDB.User_ID)
-		defer cancel()
+		token, refreshToken, _ := generate.TokenGenerator(*userDataFromDB.Email, *userDataFromDB.First_Name, *userDataFromDB.Last_Name, userDataFromDB.User_ID, userDataFromDB.Role)
 
 		generate.UpdateAllTokens(token, refreshToken, userDataFromDB.User_ID)
-		ctx.JSON(http.StatusFound, userDataFromDB)
-	}
+
+		if cart, merged := mergeGuestCartOnSignIn(context, ctx, userDataFromDB.User_ID); merged {
+			userDataFromDB.UserCart = cart
+		}
+
+		ctx.JSON(http.StatusOK, userDataFromDB)
+		return nil
+
+	})
 
 }
 
@@ -179,94 +183,181 @@ func Login() gin.HandlerFunc {
 
 // }
 
-func SearchProduct() gin.HandlerFunc {
+// the listing parameters shared by every product listing, eg :
+// ?limit=20&cursor=...&sort=price_asc&min_price=10000&max_price=50000&min_rating=4&category=shoes&in_stock=true
+// &brand=acme&brand=globex&attr.size=M&attr.size=L
+// the "attr." filters are named after the product options, so they are read apart from the struct
+
+type ProductListRequest struct {
+	Sort       string   `form:"sort" doc:"order of the results"`
+	Cursor     string   `form:"cursor" doc:"the next_cursor of the previous page"`
+	Limit      int      `form:"limit" validate:"omitempty,min=1" doc:"page size, at most 100"`
+	Min_Price  *int64   `form:"min_price" validate:"omitempty,min=0" doc:"in minor units of the base currency"`
+	Max_Price  *int64   `form:"max_price" validate:"omitempty,min=0" doc:"in minor units of the base currency"`
+	Min_Rating *int     `form:"min_rating" validate:"omitempty,min=0,max=5" doc:"lowest average rating"`
+	Category   string   `form:"category" doc:"category slug, products of its subcategories are included"`
+	In_Stock   bool     `form:"in_stock" doc:"only products with stock left"`
+	Brands     []string `form:"brand" validate:"dive,required" doc:"repeat to match any of several brands"`
+}
 
-	return func(ctx *gin.Context) {
+func productListQueryFromRequest(ctx *gin.Context, defaultSort string) (database.ProductListQuery, error) {
 
-		var productsList []models.Product
+	var request ProductListRequest
 
-		context, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
+	if err := bindParams(ctx, &request); err != nil {
+		return database.ProductListQuery{}, err
+	}
 
-		cursor, err := ProductsCollection.Find(context, bson.D{{}})
+	query := database.ProductListQuery{
+		Sort:       request.Sort,
+		Cursor:     request.Cursor,
+		Limit:      request.Limit,
+		Min_Price:  request.Min_Price,
+		Max_Price:  request.Max_Price,
+		Min_Rating: request.Min_Rating,
+		Category:   request.Category,
+		In_Stock:   request.In_Stock,
+		Brands:     request.Brands,
+	}
 
-		if err != nil {
-			ctx.IndentedJSON(http.StatusInternalServerError, "Someting Went Wrong Please Try After Some Time")
-			return
+	if query.Sort == "" {
+		query.Sort = defaultSort
+	}
+
+	if query.Sort != defaultSort && !database.IsValidProductSort(query.Sort) {
+		return query, database.ErrInvalidSort
+	}
+
+	if query.Limit == 0 {
+		query.Limit = database.DefaultPageSize
+	}
+
+	for key, values := range ctx.Request.URL.Query() {
+
+		name, isAttribute := strings.CutPrefix(key, "attr.")
+
+		if !isAttribute {
+			continue
 		}
 
-		err = cursor.All(ctx, &productsList)
+		if query.Attributes == nil {
+			query.Attributes = make(map[string][]string)
+		}
+
+		query.Attributes[name] = values
+
+	}
+
+	return query, nil
+
+}
+
+func SearchProduct() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		query, err := productListQueryFromRequest(ctx, "newest")
 
-		// If there is a problem while fetching all the documents at once (e.g., a network failure or invalid data that cannot be unmarshalled into the productsList slice), the error will be caught here.
 		if err != nil {
-			log.Println(err)
-			ctx.AbortWithStatus(http.StatusInternalServerError)
-			return
+			return err
 		}
 
-		defer cursor.Close(context)
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
 
-		// After attempting to read all documents, if there were any errors encountered during the cursor’s lifetime (not just during the All method call), they will be caught here.
+		page, err := database.ListProducts(context, ProductsCollection, CategoriesCollection, bson.D{}, query)
 
-		if err := cursor.Err(); err != nil {
-			log.Println(err)
-			ctx.IndentedJSON(400, "Invalid")
-			return
+		if err != nil {
+			slog.ErrorContext(ctx.Request.Context(), "SearchProduct failed", "error", err)
+			return err
 		}
 
-		ctx.IndentedJSON(200, productsList)
+		ctx.IndentedJSON(200, page)
+		return nil
 
-	}
+	})
 
 }
 
+// "q" is the search text, "name" is still accepted for older clients
+
+type SearchRequest struct {
+	Q    string `form:"q" validate:"max=200" doc:"search text"`
+	Name string `form:"name" validate:"max=200" doc:"older name of q"`
+}
+
 func SearchProductByQuery() gin.HandlerFunc {
 
-	return func(ctx *gin.Context) {
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request SearchRequest
 
-		var searchResults []models.Product
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
 
-		searchQuery := ctx.Query("name")
+		searchQuery := request.Q
 
 		if searchQuery == "" {
+			searchQuery = request.Name
+		}
+
+		if searchQuery == "" {
+			return search.ErrEmptyQuery
+		}
 
-			log.Println("Query is Empty")
-			ctx.Header("Content-Type", "application/json")
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"ERROR": "Invalid Search Index"})
-			ctx.Abort()
-			return
+		query, err := productListQueryFromRequest(ctx, search.SortRelevance)
 
+		if err != nil {
+			return err
 		}
 
-		context, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
 		defer cancel()
 
-		cursor, err := ProductsCollection.Find(context, bson.M{"$regex": searchQuery})
+		page, err := search.Search(context, ProductsCollection, CategoriesCollection, searchQuery, query)
 
 		if err != nil {
-			log.Println(err)
-			ctx.IndentedJSON(404, "Something went wrong while fetching the items.")
-			return
+			slog.ErrorContext(ctx.Request.Context(), "SearchProductByQuery failed", "error", err)
+			return err
 		}
 
-		err = cursor.All(context, &searchResults)
-
-		if err != nil {
-			log.Print(err)
-			ctx.IndentedJSON(400, "Invalid Result")
-			return
+		// fuzzy hits mean the query was probably misspelt, it is not worth suggesting to others
+		if page.Total > 0 && !page.Fuzzy && query.Cursor == "" {
+			search.Suggestions.RecordQuery(searchQuery)
 		}
 
-		defer cursor.Close(context)
+		ctx.IndentedJSON(200, page)
+		return nil
+
+	})
+
+}
+
+type SuggestRequest struct {
+	Q     string `form:"q" validate:"max=200" doc:"text typed so far"`
+	Limit int    `form:"limit" validate:"omitempty,min=1" doc:"suggestions per kind"`
+}
+
+// served from memory on every keystroke, so it never touches the database
+
+func SuggestSearch() gin.HandlerFunc {
 
-		if err := cursor.Err(); err != nil {
-			log.Println(err)
-			ctx.IndentedJSON(400, "Invalid Request")
-			return
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request SuggestRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
 		}
 
-		ctx.IndentedJSON(200, searchResults)
+		if request.Limit == 0 {
+			request.Limit = search.DefaultSuggestLimit
+		}
 
-	}
+		ctx.JSON(http.StatusOK, search.Suggestions.Lookup(request.Q, request.Limit))
+		return nil
+
+	})
 
 }
@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/currency"
+	"github.com/gin-gonic/gin"
+)
+
+type ExchangeRateTable struct {
+	Base  string             `json:"base"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// the table used for display conversions, orders never change after checkout even when it does
+
+func ExchangeRates() gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		ctx.IndentedJSON(http.StatusOK, ExchangeRateTable{
+			Base:  currency.Rates.Base(),
+			Rates: currency.Rates.Snapshot(),
+		})
+
+	}
+
+}
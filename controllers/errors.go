@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/carriers"
+	"github.com/aaravmahajanofficial/ecommerce-project/currency"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/imaging"
+	"github.com/aaravmahajanofficial/ecommerce-project/importer"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"github.com/aaravmahajanofficial/ecommerce-project/postal"
+	"github.com/aaravmahajanofficial/ecommerce-project/search"
+	"github.com/aaravmahajanofficial/ecommerce-project/storage"
+)
+
+// the status and code every sentinel error of the lower packages is answered with,
+// errors missing here are reported as INTERNAL_ERROR without their message
+
+func init() {
+
+	for _, mapping := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		// products, variants and listings
+		{database.ErrCantFindProduct, http.StatusNotFound, "PRODUCT_NOT_FOUND"},
+		{database.ErrCantFindVariant, http.StatusNotFound, "VARIANT_NOT_FOUND"},
+		{database.ErrVariantRequired, http.StatusBadRequest, "VARIANT_REQUIRED"},
+		{database.ErrOutOfStock, http.StatusConflict, "OUT_OF_STOCK"},
+		{database.ErrInvalidVariants, http.StatusBadRequest, "INVALID_VARIANTS"},
+		{database.ErrSKUTaken, http.StatusConflict, "SKU_TAKEN"},
+		{database.ErrInvalidCursor, http.StatusBadRequest, "INVALID_CURSOR"},
+		{database.ErrInvalidSort, http.StatusBadRequest, "INVALID_SORT"},
+		{database.ErrInvalidAttribute, http.StatusBadRequest, "INVALID_ATTRIBUTE"},
+		{search.ErrEmptyQuery, http.StatusBadRequest, "EMPTY_QUERY"},
+		{currency.ErrUnsupportedCurrency, http.StatusBadRequest, "UNSUPPORTED_CURRENCY"},
+		{currency.ErrMissingRate, http.StatusUnprocessableEntity, "MISSING_EXCHANGE_RATE"},
+		{models.ErrInvalidAmount, http.StatusBadRequest, "INVALID_AMOUNT"},
+
+		// categories
+		{database.ErrCantFindCategory, http.StatusNotFound, "CATEGORY_NOT_FOUND"},
+		{database.ErrCategorySlugTaken, http.StatusConflict, "CATEGORY_SLUG_TAKEN"},
+		{database.ErrCategoryHasChildren, http.StatusConflict, "CATEGORY_HAS_CHILDREN"},
+		{database.ErrCategorySlugNotValid, http.StatusBadRequest, "INVALID_CATEGORY_SLUG"},
+		{database.ErrCategoryCycle, http.StatusBadRequest, "CATEGORY_CYCLE"},
+
+		// users, carts and checkout
+		{database.ErrUserIDIsNotValid, http.StatusBadRequest, "INVALID_USER_ID"},
+		{database.ErrCantGetItem, http.StatusNotFound, "USER_NOT_FOUND"},
+		{database.ErrCantFindCartItem, http.StatusNotFound, "CART_ITEM_NOT_FOUND"},
+		{database.ErrCartIsEmpty, http.StatusBadRequest, "CART_EMPTY"},
+		{database.ErrCartChanged, http.StatusConflict, "CART_CHANGED"},
+		{database.ErrCantFindGuestCart, http.StatusNotFound, "GUEST_CART_NOT_FOUND"},
+		{database.ErrGuestCartFull, http.StatusConflict, "GUEST_CART_FULL"},
+
+		// wishlists
+		{database.ErrCantFindWishlist, http.StatusNotFound, "WISHLIST_NOT_FOUND"},
+		{database.ErrCantFindWishlistItem, http.StatusNotFound, "WISHLIST_ITEM_NOT_FOUND"},
+		{database.ErrWishlistNameTaken, http.StatusConflict, "WISHLIST_NAME_TAKEN"},
+		{database.ErrTooManyWishlists, http.StatusConflict, "TOO_MANY_WISHLISTS"},
+		{database.ErrWishlistFull, http.StatusConflict, "WISHLIST_FULL"},
+		{database.ErrCantDeleteDefaultWishlist, http.StatusBadRequest, "DEFAULT_WISHLIST"},
+
+		// addresses
+		{database.ErrCantFindAddress, http.StatusNotFound, "ADDRESS_NOT_FOUND"},
+		{database.ErrAddressBookFull, http.StatusConflict, "ADDRESS_BOOK_FULL"},
+		{database.ErrInvalidAddressUsage, http.StatusBadRequest, "INVALID_ADDRESS_USAGE"},
+		{database.ErrNoShippingAddress, http.StatusUnprocessableEntity, "NO_SHIPPING_ADDRESS"},
+		{postal.ErrUnsupportedCountry, http.StatusBadRequest, "UNSUPPORTED_COUNTRY"},
+		{postal.ErrInvalidPostalCode, http.StatusBadRequest, "INVALID_POSTAL_CODE"},
+		{postal.ErrCityRequired, http.StatusBadRequest, "CITY_REQUIRED"},
+		{postal.ErrUnserviceable, http.StatusUnprocessableEntity, "UNSERVICEABLE_POSTAL_CODE"},
+
+		// orders and shipments
+		{database.ErrCantFindOrder, http.StatusNotFound, "ORDER_NOT_FOUND"},
+		{database.ErrCantFindShipment, http.StatusNotFound, "SHIPMENT_NOT_FOUND"},
+		{database.ErrInvalidShipmentItems, http.StatusBadRequest, "INVALID_SHIPMENT_ITEMS"},
+		{database.ErrInvalidTrackingStatus, http.StatusBadRequest, "INVALID_TRACKING_STATUS"},
+		{database.ErrShipmentAlreadyShipped, http.StatusConflict, "SHIPMENT_ALREADY_SHIPPED"},
+		{carriers.ErrUnknownCarrier, http.StatusBadRequest, "UNKNOWN_CARRIER"},
+		{carriers.ErrInvalidTracking, http.StatusBadRequest, "INVALID_TRACKING_NUMBER"},
+		{carriers.ErrInvalidWebhook, http.StatusBadRequest, "INVALID_WEBHOOK"},
+
+		// reviews
+		{database.ErrCantFindReview, http.StatusNotFound, "REVIEW_NOT_FOUND"},
+		{database.ErrReviewNotAllowed, http.StatusForbidden, "REVIEW_NOT_ALLOWED"},
+		{database.ErrNotReviewAuthor, http.StatusForbidden, "NOT_REVIEW_AUTHOR"},
+		{database.ErrAlreadyReviewed, http.StatusConflict, "ALREADY_REVIEWED"},
+		{database.ErrCantVoteReview, http.StatusConflict, "CANT_VOTE_REVIEW"},
+		{database.ErrInvalidReviewStatus, http.StatusBadRequest, "INVALID_REVIEW_STATUS"},
+
+		// images and imports
+		{database.ErrCantFindImage, http.StatusNotFound, "IMAGE_NOT_FOUND"},
+		{database.ErrInvalidImageList, http.StatusBadRequest, "INVALID_IMAGE_ORDER"},
+		{storage.ErrNotFound, http.StatusNotFound, "IMAGE_NOT_FOUND"},
+		{storage.ErrInvalidKey, http.StatusNotFound, "IMAGE_NOT_FOUND"},
+		{imaging.ErrTooLarge, http.StatusRequestEntityTooLarge, "IMAGE_TOO_LARGE"},
+		{imaging.ErrUnsupportedType, http.StatusUnsupportedMediaType, "UNSUPPORTED_IMAGE_TYPE"},
+		{imaging.ErrCantDecode, http.StatusBadRequest, "INVALID_IMAGE"},
+		{importer.ErrUnknownFormat, http.StatusBadRequest, "UNKNOWN_FORMAT"},
+		{importer.ErrCantFindJob, http.StatusNotFound, "IMPORT_JOB_NOT_FOUND"},
+	} {
+		apierror.Register(mapping.err, mapping.status, mapping.code)
+	}
+
+}
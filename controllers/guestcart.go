@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	generate "github.com/aaravmahajanofficial/ecommerce-project/tokens"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var GuestCartsCollection = database.GuestCartData(database.Client, "GuestCarts")
+
+// anonymous visitors send the token they were given in this header, Login and SignUp accept it too
+const CartTokenHeader = "Cart-Token"
+
+type GuestCartListing struct {
+	Cart_Token string               `json:"cart_token"`
+	Expires_At time.Time            `json:"expires_at"`
+	Total      models.Money         `json:"total"`
+	UserCart   []models.ProductUser `json:"userCart"`
+}
+
+func guestCartIDFromRequest(ctx *gin.Context) (primitive.ObjectID, bool) {
+
+	signedToken := ctx.GetHeader(CartTokenHeader)
+
+	if signedToken == "" {
+		return primitive.NilObjectID, false
+	}
+
+	claims, msg := generate.VerifyCartToken(signedToken)
+
+	if msg != "" {
+		slog.DebugContext(ctx.Request.Context(), "cart token rejected", "reason", msg)
+		return primitive.NilObjectID, false
+	}
+
+	cartID, err := primitive.ObjectIDFromHex(claims.Cart_ID)
+
+	if err != nil {
+		slog.DebugContext(ctx.Request.Context(), "cart token rejected", "reason", err)
+		return primitive.NilObjectID, false
+	}
+
+	return cartID, true
+
+}
+
+type GuestCartRequest struct {
+	Currency string `form:"currency" validate:"omitempty,currency" doc:"ISO 4217 code prices are converted to, defaults to the base currency"`
+}
+
+type GuestCartItemRequest struct {
+	Product_ID primitive.ObjectID  `uri:"id" form:"id" validate:"required" doc:"product ID"`
+	Variant_ID *primitive.ObjectID `form:"variantID" doc:"required for products with variants"`
+	Currency   string              `form:"currency" validate:"omitempty,currency" doc:"ISO 4217 code prices are converted to, defaults to the base currency"`
+}
+
+// the token is reissued on every response, its expiry follows the cart's
+
+func guestCartResponse(ctx *gin.Context, status int, cart models.GuestCart, currencyCode string) error {
+
+	signedToken, err := generate.CartTokenGenerator(cart.Cart_ID.Hex(), cart.Expires_At)
+
+	if err != nil {
+		return err
+	}
+
+	pricedItems, total, err := database.PriceCartItems(cart.Items, displayCurrency(currencyCode))
+
+	if err != nil {
+		return err
+	}
+
+	ctx.Header(CartTokenHeader, signedToken)
+	ctx.IndentedJSON(status, GuestCartListing{
+		Cart_Token: signedToken,
+		Expires_At: cart.Expires_At,
+		Total:      total,
+		UserCart:   pricedItems,
+	})
+
+	return nil
+
+}
+
+func GetGuestCart() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request GuestCartRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		cartID, ok := guestCartIDFromRequest(ctx)
+
+		if !ok {
+			return database.ErrCantFindGuestCart
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		cart, err := database.FindGuestCart(context, GuestCartsCollection, cartID)
+
+		if err != nil {
+			return err
+		}
+
+		return guestCartResponse(ctx, http.StatusOK, cart, request.Currency)
+
+	})
+
+}
+
+// a visitor without a valid token, or whose cart expired, gets a new cart and token
+
+func AddToGuestCart() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request GuestCartItemRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		cartID, ok := guestCartIDFromRequest(ctx)
+
+		if ok {
+			if _, err := database.FindGuestCart(context, GuestCartsCollection, cartID); err != nil {
+				ok = false
+			}
+		}
+
+		if !ok {
+
+			cart, err := database.CreateGuestCart(context, GuestCartsCollection)
+
+			if err != nil {
+				return err
+			}
+
+			cartID = cart.Cart_ID
+
+		}
+
+		cart, err := database.AddProductToGuestCart(context, ProductsCollection, GuestCartsCollection, cartID, request.Product_ID, request.Variant_ID)
+
+		if err != nil {
+			return err
+		}
+
+		return guestCartResponse(ctx, http.StatusOK, cart, request.Currency)
+
+	})
+
+}
+
+func RemoveFromGuestCart() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request GuestCartItemRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		cartID, ok := guestCartIDFromRequest(ctx)
+
+		if !ok {
+			return database.ErrCantFindGuestCart
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		cart, err := database.RemoveGuestCartItem(context, GuestCartsCollection, cartID, request.Product_ID, request.Variant_ID)
+
+		if err != nil {
+			return err
+		}
+
+		return guestCartResponse(ctx, http.StatusOK, cart, request.Currency)
+
+	})
+
+}
+
+// merges the visitor's guest cart into the user's cart when the request carries a cart token
+// a failed merge never fails the sign in, the guest cart is simply left for a later attempt
+
+func mergeGuestCartOnSignIn(context context.Context, ctx *gin.Context, userID string) ([]models.ProductUser, bool) {
+
+	cartID, ok := guestCartIDFromRequest(ctx)
+
+	if !ok {
+		return nil, false
+	}
+
+	cart, err := database.MergeGuestCart(context, UserCollection, GuestCartsCollection, cartID, userID)
+
+	if err != nil {
+		slog.ErrorContext(context, "mergeGuestCartOnSignIn failed", "error", err)
+		return nil, false
+	}
+
+	return cart, true
+
+}
@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/imaging"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"github.com/aaravmahajanofficial/ecommerce-project/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ImageStorage = imageStorageFromEnv()
+
+func imageStorageFromEnv() storage.Storage {
+
+	imageStorage, err := storage.NewFromEnv()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return imageStorage
+
+}
+
+// longest side in pixels of the generated derivatives
+const (
+	MediumImageSide    = 800
+	ThumbnailImageSide = 200
+)
+
+var errImageMissing = apierror.BadRequest("IMAGE_MISSING", "Form file \"image\" is missing")
+
+var errUnreadableUpload = apierror.BadRequest("UNREADABLE_UPLOAD", "Unable to read uploaded file")
+
+// the product the uploaded "image" form file belongs to, alt is the text shown when it cannot be displayed
+
+type ImageUploadRequest struct {
+	Product_ID primitive.ObjectID `uri:"id" form:"id" validate:"required"`
+	Alt        string             `form:"alt" validate:"max=200" doc:"also accepted as a form field"`
+}
+
+type ImageRequest struct {
+	Product_ID primitive.ObjectID `uri:"id" form:"id" validate:"required"`
+	Image_ID   primitive.ObjectID `uri:"imageID" form:"imageID" validate:"required"`
+}
+
+// the catch all "*key" of /images/*key, eg : "/products/<product id>/<image id>/thumb.jpg"
+
+type ImageKeyRequest struct {
+	Key string `uri:"key" validate:"required,max=300"`
+}
+
+// every image of the product, in the new order
+
+type ImageOrderRequest struct {
+	Image_IDs []primitive.ObjectID `json:"image_ids" validate:"required"`
+}
+
+type storedObject struct {
+	key         string
+	contentType string
+	data        []byte
+}
+
+// expects a multipart form with an "image" file and an optional "alt" text
+
+func UploadProductImage() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		// leaves room for the multipart framing around the file itself, set before the form is read for the alt text
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, imaging.MaxUploadBytes+(64<<10))
+
+		var request ImageUploadRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		fileHeader, err := ctx.FormFile("image")
+
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return imaging.ErrTooLarge
+			}
+			return errImageMissing
+		}
+
+		file, err := fileHeader.Open()
+
+		if err != nil {
+			return errUnreadableUpload
+		}
+
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, imaging.MaxUploadBytes+1))
+
+		if err != nil {
+			return errUnreadableUpload
+		}
+
+		decoded, contentType, err := imaging.Decode(data)
+
+		if err != nil {
+			return err
+		}
+
+		imageID := primitive.NewObjectID()
+		prefix := fmt.Sprintf("products/%s/%s/", request.Product_ID.Hex(), imageID.Hex())
+		objects := []storedObject{{key: prefix + "original." + imaging.Extension(contentType), contentType: contentType, data: data}}
+
+		for _, derivative := range []struct {
+			name string
+			side int
+		}{{"medium", MediumImageSide}, {"thumb", ThumbnailImageSide}} {
+
+			encoded, encodedType, err := imaging.Encode(imaging.Resize(decoded, derivative.side), contentType)
+
+			if err != nil {
+				return err
+			}
+
+			objects = append(objects, storedObject{key: prefix + derivative.name + "." + imaging.Extension(encodedType), contentType: encodedType, data: encoded})
+
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		for index, object := range objects {
+			if err = ImageStorage.Put(context, object.key, object.contentType, bytes.NewReader(object.data)); err != nil {
+				slog.ErrorContext(ctx.Request.Context(), "UploadProductImage failed", "error", err)
+				deleteStoredObjects(context, objects[:index])
+				return err
+			}
+		}
+
+		image := models.ProductImage{
+			Image_ID:      imageID,
+			Alt:           request.Alt,
+			Content_Type:  contentType,
+			Width:         decoded.Bounds().Dx(),
+			Height:        decoded.Bounds().Dy(),
+			Original_Key:  objects[0].key,
+			Medium_Key:    objects[1].key,
+			Thumbnail_Key: objects[2].key,
+			Original_URL:  ImageStorage.URL(objects[0].key),
+			Medium_URL:    ImageStorage.URL(objects[1].key),
+			Thumbnail_URL: ImageStorage.URL(objects[2].key),
+			Uploaded_At:   time.Now(),
+		}
+
+		images, err := database.AddProductImage(context, ProductsCollection, request.Product_ID, image)
+
+		if err != nil {
+			deleteStoredObjects(context, objects)
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusCreated, images)
+		return nil
+
+	})
+
+}
+
+func deleteStoredObjects(context context.Context, objects []storedObject) {
+
+	for _, object := range objects {
+		if err := ImageStorage.Delete(context, object.key); err != nil {
+			slog.ErrorContext(context, "deleteStoredObjects failed", "error", err)
+		}
+	}
+
+}
+
+func DeleteProductImage() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request ImageRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		removed, err := database.RemoveProductImage(context, ProductsCollection, request.Product_ID, request.Image_ID)
+
+		if err != nil {
+			return err
+		}
+
+		// the product no longer points at the files, a failed delete only leaves orphans behind
+		deleteStoredObjects(context, []storedObject{{key: removed.Original_Key}, {key: removed.Medium_Key}, {key: removed.Thumbnail_Key}})
+
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Image deleted successfully"})
+		return nil
+
+	})
+
+}
+
+func ReorderProductImages() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var product IDRequest
+		var request ImageOrderRequest
+
+		if err := bindRequest(ctx, &product, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		images, err := database.ReorderProductImages(context, ProductsCollection, product.ID, request.Image_IDs)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, images)
+		return nil
+
+	})
+
+}
+
+// image keys contain a fresh id per upload, so a stored object never changes and can be cached for a year
+
+func ServeImage() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request ImageKeyRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		key := strings.TrimPrefix(request.Key, "/")
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 30*time.Second)
+		defer cancel()
+
+		object, info, err := ImageStorage.Get(context, key)
+
+		if err != nil {
+			return err
+		}
+
+		defer object.Close()
+
+		if info.Content_Type != "" {
+			ctx.Header("Content-Type", info.Content_Type)
+		}
+
+		ctx.Header("Cache-Control", "public, max-age=31536000, immutable")
+		ctx.Header("ETag", fmt.Sprintf(`"%x-%x"`, info.Modified_At.Unix(), info.Size))
+
+		http.ServeContent(ctx.Writer, ctx.Request, path.Base(key), info.Modified_At, object)
+		return nil
+
+	})
+
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/importer"
+	"github.com/gin-gonic/gin"
+)
+
+var ImportJobsCollection = database.ImportJobData(database.Client, "ImportJobs")
+
+var ProductImporter = importer.NewImporter(ProductsCollection, CategoriesCollection, ImportJobsCollection)
+
+// largest import file accepted over HTTP, the command line importer has no limit
+const MaxImportBytes = 512 << 20
+
+type CatalogFormatRequest struct {
+	Format string `form:"format" validate:"omitempty,oneof=csv jsonl" doc:"defaults to csv"`
+}
+
+func (request CatalogFormatRequest) format() string {
+
+	if request.Format != "" {
+		return request.Format
+	}
+
+	return importer.FormatCSV
+
+}
+
+// accepts a multipart form with a "file" field or the file as the raw request body
+// the file is processed in the background, the returned job is polled for progress
+
+func ImportProducts() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, MaxImportBytes)
+
+		// a large file takes longer to upload than the server's read timeout allows
+		if err := http.NewResponseController(ctx.Writer).SetReadDeadline(time.Now().Add(30 * time.Minute)); err != nil {
+			slog.WarnContext(ctx.Request.Context(), "extending the read deadline failed", "error", err)
+		}
+
+		var request CatalogFormatRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		format := request.format()
+
+		var upload io.Reader = ctx.Request.Body
+
+		if fileHeader, err := ctx.FormFile("file"); err == nil {
+
+			file, err := fileHeader.Open()
+
+			if err != nil {
+				return errUnreadableUpload
+			}
+
+			defer file.Close()
+			upload = file
+
+		}
+
+		// the upload is spooled to disk so the request can return before the import finishes
+		spool, err := os.CreateTemp("", "product-import-*."+format)
+
+		if err != nil {
+			slog.ErrorContext(ctx.Request.Context(), "ImportProducts failed", "error", err)
+			return err
+		}
+
+		written, err := io.Copy(spool, upload)
+		spool.Close()
+
+		if err != nil || written == 0 {
+			os.Remove(spool.Name())
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return apierror.New(http.StatusRequestEntityTooLarge, "IMPORT_TOO_LARGE", fmt.Sprintf("Import files are limited to %d MB", MaxImportBytes>>20))
+			}
+			return apierror.BadRequest("IMPORT_FILE_MISSING", "Import file is missing or empty")
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		job, err := ProductImporter.Start(context, format, spool.Name())
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusAccepted, job)
+		return nil
+
+	})
+
+}
+
+func GetImportJob() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request IDRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		job, err := ProductImporter.FindJob(context, request.ID)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, job)
+		return nil
+
+	})
+
+}
+
+func ExportProducts() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request CatalogFormatRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		format := request.format()
+		contentType := "text/csv"
+
+		if format == importer.FormatJSONL {
+			contentType = "application/x-ndjson"
+		}
+
+		// large catalogs take a while to stream, longer than the server's write timeout allows
+		context, cancel := context.WithTimeout(requestContext(ctx), 30*time.Minute)
+		defer cancel()
+
+		if err := http.NewResponseController(ctx.Writer).SetWriteDeadline(time.Now().Add(30 * time.Minute)); err != nil {
+			slog.WarnContext(ctx.Request.Context(), "extending the write deadline failed", "error", err)
+		}
+
+		ctx.Header("Content-Type", contentType)
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"products-%s.%s\"", time.Now().Format("20060102"), format))
+		ctx.Status(http.StatusOK)
+
+		// the status is already sent once rows are streaming, failures can only be logged
+		if err := ProductImporter.Export(context, format, ctx.Writer); err != nil {
+			slog.ErrorContext(ctx.Request.Context(), "streaming the export failed", "error", err)
+		}
+		return nil
+
+	})
+
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// the level only lasts until the process restarts, LOG_LEVEL sets the one it starts with
+
+type LogLevel struct {
+	Level string `json:"level" validate:"required,oneof=debug info warn error"`
+}
+
+func currentLogLevel() LogLevel {
+	return LogLevel{Level: strings.ToLower(logging.Level.Level().String())}
+}
+
+func GetLogLevel() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		ctx.IndentedJSON(http.StatusOK, currentLogLevel())
+		return nil
+
+	})
+
+}
+
+// eg : {"level": "debug"} while chasing a bug in production, then back to "info"
+
+func SetLogLevel() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request LogLevel
+
+		if err := bindJSON(ctx, &request); err != nil {
+			return err
+		}
+
+		level, err := logging.ParseLevel(request.Level)
+
+		if err != nil {
+			return err
+		}
+
+		previous := currentLogLevel()
+		logging.Level.Set(level)
+
+		// logged at warn, so the change shows up whichever level it moved to
+		slog.WarnContext(ctx.Request.Context(), "log level changed", "from", previous.Level, "to", request.Level)
+
+		ctx.IndentedJSON(http.StatusOK, currentLogLevel())
+		return nil
+
+	})
+
+}
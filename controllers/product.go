@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"github.com/gin-gonic/gin"
+)
+
+type VariantsRequest struct {
+	Options  []models.ProductOption `json:"options" validate:"dive"`
+	Variants []models.Variant       `json:"variants" validate:"dive"`
+}
+
+// replaces the options and variants of a product, eg :
+/*
+	{
+		"options": [{"name": "size", "values": ["S", "M"]}, {"name": "colour", "values": ["red"]}],
+		"variants": [
+			{"sku": "TEE-RED-S", "options": {"size": "S", "colour": "red"}, "stock": 10},
+			{"sku": "TEE-RED-M", "options": {"size": "M", "colour": "red"}, "stock": 4, "price": {"amount": 54900, "currency": "INR"}}
+		]
+	}
+*/
+
+func SetProductVariants() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var target IDRequest
+		var request VariantsRequest
+
+		if err := bindRequest(ctx, &target, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		product, err := database.SetProductVariants(context, ProductsCollection, target.ID, request.Options, request.Variants)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, product)
+		return nil
+
+	})
+
+}
@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ReviewsCollection = database.ReviewData(database.Client, "Reviews")
+
+type ReviewPage struct {
+	Items  []models.Review `json:"items"`
+	Total  int64           `json:"total"`
+	Limit  int             `json:"limit"`
+	Offset int             `json:"offset"`
+}
+
+// newest first unless sorted by "helpful"
+
+type ReviewListRequest struct {
+	Product_ID primitive.ObjectID `uri:"id" form:"productID" validate:"required"`
+	Limit      int                `form:"limit" validate:"omitempty,min=1,max=100" doc:"page size"`
+	Offset     int                `form:"offset" validate:"min=0" doc:"reviews to skip"`
+	Sort       string             `form:"sort" validate:"omitempty,oneof=newest helpful" doc:"newest first unless sorted by helpful votes"`
+}
+
+type ReviewProductRequest struct {
+	Product_ID primitive.ObjectID `uri:"id" form:"productID" validate:"required"`
+}
+
+type ModerationRequest struct {
+	Status string `json:"status" validate:"required,oneof=PUBLISHED REJECTED"`
+	Note   string `json:"note" validate:"max=500"`
+}
+
+// eg : "Aarav M."
+
+func reviewAuthorName(ctx *gin.Context) string {
+
+	name := ctx.GetString("First_Name")
+
+	if lastName := []rune(ctx.GetString("Last_Name")); len(lastName) > 0 {
+		name += " " + string(lastName[0]) + "."
+	}
+
+	return name
+
+}
+
+func GetProductReviews() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request ReviewListRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		if request.Limit == 0 {
+			request.Limit = database.DefaultPageSize
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		reviews, total, err := database.ListProductReviews(context, ReviewsCollection, request.Product_ID, request.Sort, request.Offset, request.Limit)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, ReviewPage{Items: reviews, Total: total, Limit: request.Limit, Offset: request.Offset})
+		return nil
+
+	})
+
+}
+
+func CreateReview() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var product ReviewProductRequest
+		var review models.Review
+
+		if err := bindRequest(ctx, &product, &review); err != nil {
+			return err
+		}
+
+		// the author always comes from the token, never from the body
+		review.Product_ID = product.Product_ID
+		review.User_ID = ctx.GetString("UID")
+		review.Author_Name = reviewAuthorName(ctx)
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		review, err := database.CreateReview(context, ProductsCollection, ReviewsCollection, ShipmentsCollection, review)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusCreated, review)
+		return nil
+
+	})
+
+}
+
+func UpdateReview() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var target IDRequest
+		var changes models.Review
+
+		if err := bindRequest(ctx, &target, &changes); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		review, err := database.UpdateReview(context, ProductsCollection, ReviewsCollection, target.ID, ctx.GetString("UID"), changes)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, review)
+		return nil
+
+	})
+
+}
+
+func DeleteReview() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request IDRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		if err := database.DeleteReview(context, ProductsCollection, ReviewsCollection, request.ID, ctx.GetString("UID")); err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Review deleted successfully"})
+		return nil
+
+	})
+
+}
+
+func VoteReviewHelpful() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request IDRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		review, err := database.VoteReviewHelpful(context, ReviewsCollection, request.ID, ctx.GetString("UID"))
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, review)
+		return nil
+
+	})
+
+}
+
+func ModerateReview() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var target IDRequest
+		var request ModerationRequest
+
+		if err := bindRequest(ctx, &target, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		review, err := database.ModerateReview(context, ProductsCollection, ReviewsCollection, target.ID, request.Status, request.Note)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, review)
+		return nil
+
+	})
+
+}
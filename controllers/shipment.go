@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/carriers"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ShipmentsCollection = database.ShipmentData(database.Client, "Shipments")
+
+// carriers calling the webhook must send this value in the "X-Carrier-Secret" header,
+// without it the webhook is refused, anyone could otherwise mark shipments as delivered
+var CarrierWebhookSecret = os.Getenv("CARRIER_WEBHOOK_SECRET")
+
+// carriers batch their updates, a body beyond this is refused before it is read into memory
+const MaxWebhookBytes = 1 << 20
+
+// without a carrier the simulated one is used
+
+type ShipmentRequest struct {
+	Carrier string                `json:"carrier" validate:"max=40"`
+	Items   []models.ShipmentItem `json:"items" validate:"dive"`
+}
+
+type ShipRequest struct {
+	Tracking_Number string `json:"tracking_number" validate:"max=100"`
+}
+
+type OrderRequest struct {
+	Order_ID primitive.ObjectID `uri:"id" form:"orderID" validate:"required"`
+}
+
+type CarrierWebhookRequest struct {
+	Carrier string `uri:"carrier" form:"carrier" validate:"required"`
+}
+
+type OrderTracking struct {
+	Order_ID           primitive.ObjectID `json:"order_id"`
+	Fulfillment_Status string             `json:"fulfillment_status"`
+	Shipments          []models.Shipment  `json:"shipments"`
+}
+
+// carriers send batches, updates for unknown tracking numbers are received but not applied
+
+type WebhookResult struct {
+	Received int `json:"received"`
+	Applied  int `json:"applied"`
+}
+
+func CreateShipment() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var order OrderRequest
+		var request ShipmentRequest
+
+		if err := bindRequest(ctx, &order, &request); err != nil {
+			return err
+		}
+
+		if request.Carrier == "" {
+			request.Carrier = carriers.SimulatedCarrierName
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		shipment, err := database.CreateShipment(context, UserCollection, ShipmentsCollection, order.Order_ID, request.Carrier, request.Items)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusCreated, shipment)
+		return nil
+
+	})
+
+}
+
+func ShipShipment() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var shipment IDRequest
+
+		if err := bindParams(ctx, &shipment); err != nil {
+			return err
+		}
+
+		// the body is optional, without a tracking number the shipment is booked with its carrier
+		var request ShipRequest
+
+		if ctx.Request.ContentLength > 0 {
+			if err := bindJSON(ctx, &request); err != nil {
+				return err
+			}
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		shipped, err := database.ShipShipment(context, UserCollection, ShipmentsCollection, shipment.ID, request.Tracking_Number)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, shipped)
+		return nil
+
+	})
+
+}
+
+// customers can only track their own orders, the owner comes from the token and not the query
+
+func TrackOrder() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request OrderRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		orderID := request.Order_ID
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		order, ownerID, err := database.FindOrder(context, UserCollection, orderID)
+
+		if err != nil || ownerID != ctx.GetString("UID") {
+			return database.ErrCantFindOrder
+		}
+
+		shipments, err := database.RefreshOrderShipments(context, UserCollection, ShipmentsCollection, orderID)
+
+		if err != nil {
+			return err
+		}
+
+		// the fulfillment status may have moved while refreshing the shipments
+		if order, _, err = database.FindOrder(context, UserCollection, orderID); err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, OrderTracking{
+			Order_ID:           order.Order_ID,
+			Fulfillment_Status: order.Fulfillment,
+			Shipments:          shipments,
+		})
+		return nil
+
+	})
+
+}
+
+func CarrierWebhook() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		if CarrierWebhookSecret == "" {
+			return apierror.New(http.StatusServiceUnavailable, "CARRIER_WEBHOOK_DISABLED", "Carrier webhooks are not configured")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(ctx.GetHeader("X-Carrier-Secret")), []byte(CarrierWebhookSecret)) != 1 {
+			return apierror.Unauthorized("INVALID_CARRIER_SECRET", "Invalid carrier secret")
+		}
+
+		var request CarrierWebhookRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		carrier, err := carriers.Get(request.Carrier)
+
+		if err != nil {
+			// the carrier is part of the webhook url, an unknown one is a missing resource
+			return apierror.NotFound("UNKNOWN_CARRIER", err.Error())
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, MaxWebhookBytes))
+
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return apierror.New(http.StatusRequestEntityTooLarge, "WEBHOOK_TOO_LARGE", fmt.Sprintf("Webhook bodies are limited to %d MB", MaxWebhookBytes>>20))
+			}
+			return apierror.BadRequest("INVALID_BODY", "Invalid request body")
+		}
+
+		updates, err := carrier.ParseWebhook(body)
+
+		if err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		applied := 0
+
+		for _, update := range updates {
+
+			_, err := database.ApplyTrackingEvents(context, UserCollection, ShipmentsCollection, carrier.Name(), update.Tracking_Number, []models.TrackingEvent{update.Event})
+
+			// unknown tracking numbers are skipped, carriers retry the whole batch on any non 2xx response
+			if err != nil {
+				slog.WarnContext(ctx.Request.Context(), "tracking update skipped", "carrier", carrier.Name(), "tracking_number", update.Tracking_Number, "error", err)
+				continue
+			}
+
+			applied++
+
+		}
+
+		ctx.IndentedJSON(http.StatusOK, WebhookResult{Received: len(updates), Applied: applied})
+		return nil
+
+	})
+
+}
@@ -0,0 +1,351 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var WishlistsCollection = database.WishlistData(database.Client, "Wishlists")
+
+type WishlistNameRequest struct {
+	Name string `json:"name" validate:"notblank,max=60"`
+}
+
+type WishlistShare struct {
+	Share_Token string `json:"share_token"`
+	Share_Path  string `json:"share_path"`
+}
+
+// without an "id" every list of the user is returned
+
+type WishlistsRequest struct {
+	Wishlist_ID *primitive.ObjectID `uri:"id" form:"id" doc:"only this wishlist"`
+}
+
+type SharedWishlistRequest struct {
+	Token string `uri:"token" validate:"required,max=100"`
+}
+
+// a product is addressed by "id" and "variantID", like on the cart routes,
+// "wishlistID" is optional and requests without it use the default list
+// eg : /api/v1/wishlists/:id/items/:productID or /wishlists/items?wishlistID=...&id=...
+
+type WishlistItemRequest struct {
+	Product_ID  primitive.ObjectID  `uri:"productID" form:"id" validate:"required" doc:"product ID"`
+	Variant_ID  *primitive.ObjectID `form:"variantID" doc:"required for products with variants"`
+	Wishlist_ID *primitive.ObjectID `uri:"id" form:"wishlistID" doc:"defaults to the default wishlist"`
+}
+
+// the same item seen from the cart, the path names the product, eg : /api/v1/cart/items/:id/move-to-wishlist
+
+type CartItemToWishlistRequest struct {
+	Product_ID  primitive.ObjectID  `uri:"id" form:"id" validate:"required" doc:"product ID"`
+	Variant_ID  *primitive.ObjectID `form:"variantID" doc:"required for products with variants"`
+	Wishlist_ID *primitive.ObjectID `form:"wishlistID" doc:"defaults to the default wishlist"`
+}
+
+// the default list comes first
+
+func GetWishlists() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request WishlistsRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		if request.Wishlist_ID != nil {
+
+			wishlist, err := database.GetWishlist(context, ProductsCollection, WishlistsCollection, request.Wishlist_ID, ctx.GetString("UID"))
+
+			if err != nil {
+				return err
+			}
+
+			ctx.IndentedJSON(http.StatusOK, wishlist)
+			return nil
+
+		}
+
+		wishlists, err := database.ListWishlists(context, ProductsCollection, WishlistsCollection, ctx.GetString("UID"))
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, wishlists)
+		return nil
+
+	})
+
+}
+
+func CreateWishlist() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request WishlistNameRequest
+
+		if err := bindJSON(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		wishlist, err := database.CreateWishlist(context, WishlistsCollection, ctx.GetString("UID"), strings.TrimSpace(request.Name))
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusCreated, wishlist)
+		return nil
+
+	})
+
+}
+
+func RenameWishlist() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var target IDRequest
+		var request WishlistNameRequest
+
+		if err := bindRequest(ctx, &target, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		wishlist, err := database.RenameWishlist(context, WishlistsCollection, target.ID, ctx.GetString("UID"), strings.TrimSpace(request.Name))
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, wishlist)
+		return nil
+
+	})
+
+}
+
+func DeleteWishlist() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request IDRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		if err := database.DeleteWishlist(context, WishlistsCollection, request.ID, ctx.GetString("UID")); err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Successfully deleted the wishlist"})
+		return nil
+
+	})
+
+}
+
+func AddWishlistItem() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request WishlistItemRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		wishlist, err := database.AddWishlistItem(context, ProductsCollection, WishlistsCollection, request.Wishlist_ID, ctx.GetString("UID"), request.Product_ID, request.Variant_ID)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, wishlist)
+		return nil
+
+	})
+
+}
+
+func RemoveWishlistItem() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request WishlistItemRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		if err := database.RemoveWishlistItem(context, WishlistsCollection, request.Wishlist_ID, ctx.GetString("UID"), request.Product_ID, request.Variant_ID); err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Successfully removed the item from the wishlist"})
+		return nil
+
+	})
+
+}
+
+func MoveToWishlist() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request CartItemToWishlistRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		wishlist, err := database.MoveCartItemToWishlist(context, ProductsCollection, UserCollection, WishlistsCollection, ctx.GetString("UID"), request.Product_ID, request.Variant_ID, request.Wishlist_ID)
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, wishlist)
+		return nil
+
+	})
+
+}
+
+func MoveToCart() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request WishlistItemRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		if err := database.MoveWishlistItemToCart(context, ProductsCollection, UserCollection, WishlistsCollection, ctx.GetString("UID"), request.Product_ID, request.Variant_ID, request.Wishlist_ID); err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Successfully moved the item to the cart"})
+		return nil
+
+	})
+
+}
+
+func ShareWishlist() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request IDRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		token, err := database.ShareWishlist(context, WishlistsCollection, request.ID, ctx.GetString("UID"))
+
+		if err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, WishlistShare{Share_Token: token, Share_Path: "/api/v1/wishlists/shared/" + token})
+		return nil
+
+	})
+
+}
+
+func UnshareWishlist() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request IDRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		if err := database.UnshareWishlist(context, WishlistsCollection, request.ID, ctx.GetString("UID")); err != nil {
+			return err
+		}
+
+		ctx.IndentedJSON(http.StatusOK, Message{Message: "Successfully revoked the share link"})
+		return nil
+
+	})
+
+}
+
+// public and read only, the token is the only thing that grants access
+
+func GetSharedWishlist() gin.HandlerFunc {
+
+	return apierror.Handle(func(ctx *gin.Context) error {
+
+		var request SharedWishlistRequest
+
+		if err := bindParams(ctx, &request); err != nil {
+			return err
+		}
+
+		context, cancel := context.WithTimeout(requestContext(ctx), 100*time.Second)
+		defer cancel()
+
+		wishlist, err := database.FindSharedWishlist(context, ProductsCollection, WishlistsCollection, request.Token)
+
+		if err != nil {
+			return err
+		}
+
+		// the link itself is not handed on to whoever views it
+		wishlist.Share_Token = nil
+
+		ctx.IndentedJSON(http.StatusOK, wishlist)
+		return nil
+
+	})
+
+}
@@ -0,0 +1,189 @@
+package currency
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+)
+
+var (
+	ErrUnsupportedCurrency = errors.New("currency is not supported")
+	ErrMissingRate         = errors.New("no exchange rate configured for currency")
+)
+
+// every rate is the amount of that currency one unit of the base currency buys
+// eg : base INR, {"USD": 0.012} -> 1 INR = 0.012 USD
+// configured through the EXCHANGE_RATES environment variable as a JSON object
+
+type RateTable struct {
+	mutex sync.RWMutex
+	base  string
+	rates map[string]float64
+}
+
+// relative to INR, so they only apply when INR is the base, any other base needs EXCHANGE_RATES
+var defaultRates = map[string]float64{
+	"INR": 1,
+	"USD": 0.012,
+	"EUR": 0.011,
+	"GBP": 0.0095,
+}
+
+var Rates = loadRates()
+
+func loadRates() *RateTable {
+
+	base := strings.ToUpper(os.Getenv("BASE_CURRENCY"))
+
+	if base == "" {
+		base = "INR"
+	}
+
+	// every stored price is converted through the base, an unknown one could not price anything
+	if !models.IsSupportedCurrency(base) {
+		log.Fatal(fmt.Errorf("BASE_CURRENCY %q: %w", base, ErrUnsupportedCurrency))
+	}
+
+	rates := make(map[string]float64, len(defaultRates))
+
+	if base == "INR" {
+		for code, rate := range defaultRates {
+			rates[code] = rate
+		}
+	}
+
+	// configured rates replace the defaults of the same currency, a broken value leaves the defaults alone
+	if value := os.Getenv("EXCHANGE_RATES"); value != "" {
+
+		configured := make(map[string]float64)
+
+		err := json.Unmarshal([]byte(value), &configured)
+
+		if err != nil {
+			slog.Warn("invalid EXCHANGE_RATES, using the default table", "error", err)
+		}
+
+		if err == nil {
+			for code, rate := range configured {
+				rates[code] = rate
+			}
+		}
+
+	}
+
+	if len(rates) == 0 {
+		slog.Warn("no exchange rates for the base currency, only prices in it can be shown", "base", base)
+	}
+
+	table := &RateTable{base: base}
+	table.Set(rates)
+
+	return table
+
+}
+
+func (table *RateTable) Set(rates map[string]float64) {
+
+	normalized := make(map[string]float64, len(rates)+1)
+
+	for code, rate := range rates {
+
+		code = strings.ToUpper(code)
+
+		if !models.IsSupportedCurrency(code) || rate <= 0 {
+			slog.Warn("ignoring exchange rate", "currency", code)
+			continue
+		}
+
+		normalized[code] = rate
+
+	}
+
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	normalized[table.base] = 1
+	table.rates = normalized
+
+}
+
+func (table *RateTable) Base() string {
+
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+
+	return table.base
+
+}
+
+func (table *RateTable) Snapshot() map[string]float64 {
+
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+
+	snapshot := make(map[string]float64, len(table.rates))
+
+	for code, rate := range table.rates {
+		snapshot[code] = rate
+	}
+
+	return snapshot
+
+}
+
+// converted amounts are rounded to the nearest minor unit of the target currency
+
+func (table *RateTable) Convert(money models.Money, target string) (models.Money, error) {
+
+	target = strings.ToUpper(target)
+
+	if !models.IsSupportedCurrency(target) || !models.IsSupportedCurrency(money.Currency) {
+		return models.Money{}, ErrUnsupportedCurrency
+	}
+
+	if money.Currency == target {
+		return money, nil
+	}
+
+	table.mutex.RLock()
+	sourceRate, sourceOK := table.rates[money.Currency]
+	targetRate, targetOK := table.rates[target]
+	table.mutex.RUnlock()
+
+	if !sourceOK || !targetOK {
+		return models.Money{}, ErrMissingRate
+	}
+
+	major := float64(money.Amount) / math.Pow10(models.CurrencyExponent(money.Currency))
+	converted := major / sourceRate * targetRate
+
+	return models.Money{
+		Amount:   int64(math.Round(converted * math.Pow10(models.CurrencyExponent(target)))),
+		Currency: target,
+	}, nil
+
+}
+
+// an explicit entry in the product's price list always wins over a converted base price
+
+func PriceIn(base models.Money, priceList []models.Money, target string) (models.Money, error) {
+
+	target = strings.ToUpper(target)
+
+	for _, price := range priceList {
+		if price.Currency == target {
+			return price, nil
+		}
+	}
+
+	return Rates.Convert(base, target)
+
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrCantComputeCartMetrics = errors.New("unable to compute abandoned cart metrics")
+
+func EnsureAbandonedCartIndexes(context context.Context, usersCollection *mongo.Collection) error {
+
+	_, err := usersCollection.Indexes().CreateOne(context, mongo.IndexModel{
+		Keys:    bson.D{primitive.E{Key: "cart_updated_at", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+
+	if err != nil {
+		slog.ErrorContext(context, "EnsureAbandonedCartIndexes failed", "error", err)
+	}
+
+	return err
+
+}
+
+func abandonedCartFilter(idleSince time.Time) bson.D {
+
+	return bson.D{
+		primitive.E{Key: "usercart.0", Value: bson.D{primitive.E{Key: "$exists", Value: true}}},
+		primitive.E{Key: "cart_updated_at", Value: bson.D{primitive.E{Key: "$lte", Value: idleSince}}},
+	}
+
+}
+
+// picks one abandoned cart that is due a reminder and records the reminder in the same update,
+// so several instances running the scheduler never remind the same customer twice
+// reminders are spaced by the idle threshold and stop after maxReminders
+
+func ClaimAbandonedCart(context context.Context, usersCollection *mongo.Collection, idleSince time.Time, maxReminders int) (models.User, bool, error) {
+
+	filter := append(abandonedCartFilter(idleSince),
+		primitive.E{Key: "$and", Value: bson.A{
+			bson.D{primitive.E{Key: "$or", Value: bson.A{
+				bson.D{primitive.E{Key: "cart_reminders", Value: bson.D{primitive.E{Key: "$exists", Value: false}}}},
+				bson.D{primitive.E{Key: "cart_reminders", Value: bson.D{primitive.E{Key: "$lt", Value: maxReminders}}}},
+			}}},
+			bson.D{primitive.E{Key: "$or", Value: bson.A{
+				bson.D{primitive.E{Key: "cart_reminded_at", Value: bson.D{primitive.E{Key: "$exists", Value: false}}}},
+				bson.D{primitive.E{Key: "cart_reminded_at", Value: bson.D{primitive.E{Key: "$lte", Value: idleSince}}}},
+			}}},
+			bson.D{primitive.E{Key: "$or", Value: bson.A{
+				bson.D{primitive.E{Key: "cart_reminder_retry_at", Value: bson.D{primitive.E{Key: "$exists", Value: false}}}},
+				bson.D{primitive.E{Key: "cart_reminder_retry_at", Value: bson.D{primitive.E{Key: "$lte", Value: time.Now()}}}},
+			}}},
+		}},
+	)
+	// a pipeline, so the first reminder of a cart can bump the lifetime count in the same atomic update
+	reminders := bson.D{primitive.E{Key: "$ifNull", Value: bson.A{"$cart_reminders", 0}}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			primitive.E{Key: "cart_reminded_at", Value: time.Now()},
+			primitive.E{Key: "cart_reminders", Value: bson.D{primitive.E{Key: "$add", Value: bson.A{reminders, 1}}}},
+			// a failed attempt puts them back when it releases the claim
+			primitive.E{Key: "cart_reminder_failures", Value: "$$REMOVE"},
+			primitive.E{Key: "cart_reminder_retry_at", Value: "$$REMOVE"},
+			primitive.E{Key: "reminded_carts_total", Value: bson.D{primitive.E{Key: "$add", Value: bson.A{
+				bson.D{primitive.E{Key: "$ifNull", Value: bson.A{"$reminded_carts_total", 0}}},
+				bson.D{primitive.E{Key: "$cond", Value: bson.A{bson.D{primitive.E{Key: "$gt", Value: bson.A{reminders, 0}}}, 0, 1}}},
+			}}}},
+		}}},
+	}
+
+	var user models.User
+
+	err := usersCollection.FindOneAndUpdate(context, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&user)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.User{}, false, nil
+	}
+
+	if err != nil {
+		slog.ErrorContext(context, "ClaimAbandonedCart failed", "error", err)
+		return models.User{}, false, ErrCantUpdateUser
+	}
+
+	return user, true, nil
+
+}
+
+// undoes a claim whose reminder could not be sent, the cart is not claimed again before retryAt
+// so one cart that keeps failing does not hold up the others
+
+func ReleaseAbandonedCart(context context.Context, usersCollection *mongo.Collection, user models.User, retryAt time.Time) error {
+
+	decrements := bson.D{primitive.E{Key: "cart_reminders", Value: -1}}
+
+	// it was the cart's first reminder, so it was counted as a reminded cart
+	if user.Cart_Reminders == 0 {
+		decrements = append(decrements, primitive.E{Key: "reminded_carts_total", Value: -1})
+	}
+
+	retry := bson.D{
+		primitive.E{Key: "cart_reminder_failures", Value: user.Cart_Reminder_Failures + 1},
+		primitive.E{Key: "cart_reminder_retry_at", Value: retryAt},
+	}
+
+	update := bson.D{{Key: "$inc", Value: decrements}}
+
+	if user.Cart_Reminded_At != nil {
+		update = append(update, bson.E{Key: "$set", Value: append(retry, primitive.E{Key: "cart_reminded_at", Value: *user.Cart_Reminded_At})})
+	} else {
+		update = append(update, bson.E{Key: "$set", Value: retry}, bson.E{Key: "$unset", Value: bson.D{primitive.E{Key: "cart_reminded_at", Value: ""}}})
+	}
+
+	if _, err := usersCollection.UpdateOne(context, bson.D{primitive.E{Key: "_id", Value: user.ID}}, update); err != nil {
+		slog.ErrorContext(context, "ReleaseAbandonedCart failed", "error", err)
+		return ErrCantUpdateUser
+	}
+
+	return nil
+
+}
+
+func GetAbandonedCartMetrics(context context.Context, usersCollection *mongo.Collection, idleThreshold time.Duration) (models.AbandonedCartMetrics, error) {
+
+	idleSince := time.Now().Add(-idleThreshold)
+	count := bson.D{primitive.E{Key: "$count", Value: "count"}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$facet", Value: bson.D{
+			primitive.E{Key: "active", Value: bson.A{
+				bson.D{primitive.E{Key: "$match", Value: bson.D{primitive.E{Key: "usercart.0", Value: bson.D{primitive.E{Key: "$exists", Value: true}}}}}},
+				count,
+			}},
+			primitive.E{Key: "abandoned", Value: bson.A{
+				bson.D{primitive.E{Key: "$match", Value: abandonedCartFilter(idleSince)}},
+				count,
+			}},
+			primitive.E{Key: "reminded", Value: bson.A{
+				bson.D{primitive.E{Key: "$match", Value: append(abandonedCartFilter(idleSince), primitive.E{Key: "cart_reminders", Value: bson.D{primitive.E{Key: "$gt", Value: 0}}})}},
+				count,
+			}},
+			primitive.E{Key: "reminded_total", Value: bson.A{
+				bson.D{primitive.E{Key: "$match", Value: bson.D{primitive.E{Key: "reminded_carts_total", Value: bson.D{primitive.E{Key: "$gt", Value: 0}}}}}},
+				bson.D{primitive.E{Key: "$group", Value: bson.D{
+					primitive.E{Key: "_id", Value: nil},
+					primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: "$reminded_carts_total"}}},
+				}}},
+			}},
+			primitive.E{Key: "recovered", Value: bson.A{
+				bson.D{primitive.E{Key: "$match", Value: bson.D{primitive.E{Key: "orders.recovered_cart", Value: true}}}},
+				bson.D{primitive.E{Key: "$unwind", Value: "$orders"}},
+				bson.D{primitive.E{Key: "$match", Value: bson.D{primitive.E{Key: "orders.recovered_cart", Value: true}}}},
+				count,
+			}},
+			// carts hold lines in the currencies products were priced in, values are summed per currency
+			primitive.E{Key: "value", Value: bson.A{
+				bson.D{primitive.E{Key: "$match", Value: abandonedCartFilter(idleSince)}},
+				bson.D{primitive.E{Key: "$unwind", Value: "$usercart"}},
+				bson.D{primitive.E{Key: "$match", Value: bson.D{primitive.E{Key: "usercart.price.currency", Value: bson.D{primitive.E{Key: "$type", Value: "string"}}}}}},
+				bson.D{primitive.E{Key: "$group", Value: bson.D{
+					primitive.E{Key: "_id", Value: "$usercart.price.currency"},
+					primitive.E{Key: "amount", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price.amount"}}},
+				}}},
+				bson.D{primitive.E{Key: "$sort", Value: bson.D{primitive.E{Key: "_id", Value: 1}}}},
+			}},
+		}}},
+	}
+
+	cursor, err := usersCollection.Aggregate(context, pipeline)
+
+	if err != nil {
+		slog.ErrorContext(context, "GetAbandonedCartMetrics failed", "error", err)
+		return models.AbandonedCartMetrics{}, ErrCantComputeCartMetrics
+	}
+
+	type counted struct {
+		Count int `bson:"count"`
+	}
+
+	var results []struct {
+		Active    []counted `bson:"active"`
+		Abandoned []counted `bson:"abandoned"`
+		Reminded  []counted `bson:"reminded"`
+		Total     []counted `bson:"reminded_total"`
+		Recovered []counted `bson:"recovered"`
+		Value     []struct {
+			Currency string `bson:"_id"`
+			Amount   int64  `bson:"amount"`
+		} `bson:"value"`
+	}
+
+	if err = cursor.All(context, &results); err != nil || len(results) == 0 {
+		slog.ErrorContext(context, "GetAbandonedCartMetrics failed", "error", err)
+		return models.AbandonedCartMetrics{}, ErrCantComputeCartMetrics
+	}
+
+	first := func(values []counted) int {
+		if len(values) == 0 {
+			return 0
+		}
+		return values[0].Count
+	}
+
+	metrics := models.AbandonedCartMetrics{
+		Idle_Threshold:       idleThreshold.String(),
+		Active_Carts:         first(results[0].Active),
+		Abandoned_Carts:      first(results[0].Abandoned),
+		Reminded_Carts:       first(results[0].Reminded),
+		Reminded_Carts_Total: first(results[0].Total),
+		Recovered_Orders:     first(results[0].Recovered),
+		Abandoned_Value:      make([]models.Money, 0, len(results[0].Value)),
+	}
+
+	// both counts are cumulative, a recovered order always followed a reminded cart
+	// orders recovered before the lifetime count existed could push the rate past 1, it is capped there
+	if metrics.Reminded_Carts_Total > 0 {
+		metrics.Recovery_Rate = min(1, float64(metrics.Recovered_Orders)/float64(metrics.Reminded_Carts_Total))
+	}
+
+	for _, value := range results[0].Value {
+		metrics.Abandoned_Value = append(metrics.Abandoned_Value, models.NewMoney(value.Amount, value.Currency))
+	}
+
+	return metrics, nil
+
+}
@@ -0,0 +1,428 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"github.com/aaravmahajanofficial/ecommerce-project/postal"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindAddress     = errors.New("unable to find the specified address")
+	ErrAddressBookFull     = errors.New("address book is full")
+	ErrCantUpdateAddress   = errors.New("unable to update address")
+	ErrInvalidAddressUsage = errors.New("address default must be shipping or billing")
+	ErrNoShippingAddress   = errors.New("add a deliverable address to your address book before checking out")
+)
+
+// default flags an address can carry, see SetDefaultAddress
+const (
+	AddressShipping = "shipping"
+	AddressBilling  = "billing"
+)
+
+// ADDRESS_BOOK_LIMIT caps the addresses a user can keep
+var MaxAddresses = maxAddressesFromEnv()
+
+func maxAddressesFromEnv() int {
+
+	if value, err := strconv.Atoi(os.Getenv("ADDRESS_BOOK_LIMIT")); err == nil && value > 0 {
+		return value
+	}
+
+	return 10
+
+}
+
+func userObjectID(userID string) (primitive.ObjectID, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		slog.Error("userObjectID failed", "error", err)
+		return primitive.NilObjectID, ErrUserIDIsNotValid
+	}
+
+	return objectID, nil
+
+}
+
+func ListAddresses(context context.Context, usersCollection *mongo.Collection, userID string) ([]models.Address, error) {
+
+	objectID, err := userObjectID(userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+
+	filter := bson.D{primitive.E{Key: "_id", Value: objectID}}
+	projection := bson.D{primitive.E{Key: "address", Value: 1}}
+
+	if err = usersCollection.FindOne(context, filter, options.FindOne().SetProjection(projection)).Decode(&user); err != nil {
+		slog.ErrorContext(context, "ListAddresses failed", "error", err)
+		return nil, ErrCantGetItem
+	}
+
+	if user.Address_Details == nil {
+		return make([]models.Address, 0), nil
+	}
+
+	return user.Address_Details, nil
+
+}
+
+func FindAddress(context context.Context, usersCollection *mongo.Collection, userID string, addressID primitive.ObjectID) (models.Address, error) {
+
+	addresses, err := ListAddresses(context, usersCollection, userID)
+
+	if err != nil {
+		return models.Address{}, err
+	}
+
+	for _, address := range addresses {
+		if address.Address_id == addressID {
+			return address, nil
+		}
+	}
+
+	return models.Address{}, ErrCantFindAddress
+
+}
+
+// the first address of a user becomes the default for both shipping and billing
+
+func AddAddress(context context.Context, usersCollection *mongo.Collection, userID string, address models.Address) (models.Address, error) {
+
+	objectID, err := userObjectID(userID)
+
+	if err != nil {
+		return models.Address{}, err
+	}
+
+	addresses, err := ListAddresses(context, usersCollection, userID)
+
+	if err != nil {
+		return models.Address{}, err
+	}
+
+	address.Address_id = primitive.NewObjectID()
+
+	if len(addresses) == 0 {
+		address.Is_Default_Shipping = address.Serviceable
+		address.Is_Default_Billing = true
+	}
+
+	if address.Is_Default_Shipping && !address.Serviceable {
+		return models.Address{}, postal.ErrUnserviceable
+	}
+
+	// the limit is part of the filter, so two concurrent adds can not both take the last slot
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: objectID},
+		primitive.E{Key: "address." + strconv.Itoa(MaxAddresses-1), Value: bson.D{primitive.E{Key: "$exists", Value: false}}},
+	}
+	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "address", Value: address}}}}
+
+	result, err := usersCollection.UpdateOne(context, filter, update)
+
+	if err != nil {
+		slog.ErrorContext(context, "AddAddress failed", "error", err)
+		return models.Address{}, ErrCantUpdateAddress
+	}
+
+	if result.MatchedCount == 0 {
+		return models.Address{}, ErrAddressBookFull
+	}
+
+	for _, usage := range addressDefaults(address) {
+		if err = SetDefaultAddress(context, usersCollection, userID, address.Address_id, usage); err != nil {
+			return models.Address{}, err
+		}
+	}
+
+	return address, nil
+
+}
+
+func addressDefaults(address models.Address) []string {
+
+	usages := make([]string, 0, 2)
+
+	if address.Is_Default_Shipping {
+		usages = append(usages, AddressShipping)
+	}
+
+	if address.Is_Default_Billing {
+		usages = append(usages, AddressBilling)
+	}
+
+	return usages
+
+}
+
+// replaces the fields of one address, the default flags are only ever set here, never cleared,
+// a default moves by flagging another address
+
+func UpdateAddress(context context.Context, usersCollection *mongo.Collection, userID string, addressID primitive.ObjectID, address models.Address) (models.Address, error) {
+
+	objectID, err := userObjectID(userID)
+
+	if err != nil {
+		return models.Address{}, err
+	}
+
+	current, err := FindAddress(context, usersCollection, userID, addressID)
+
+	if err != nil {
+		return models.Address{}, err
+	}
+
+	// the default shipping address must stay deliverable
+	if (address.Is_Default_Shipping || current.Is_Default_Shipping) && !address.Serviceable {
+		return models.Address{}, postal.ErrUnserviceable
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: objectID}, primitive.E{Key: "address._id", Value: addressID}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "address.$.label", Value: address.Label},
+		primitive.E{Key: "address.$.house_name", Value: address.House},
+		primitive.E{Key: "address.$.street_name", Value: address.Street},
+		primitive.E{Key: "address.$.city_name", Value: address.City},
+		primitive.E{Key: "address.$.district", Value: address.District},
+		primitive.E{Key: "address.$.state", Value: address.State},
+		primitive.E{Key: "address.$.pin_code", Value: address.Pincode},
+		primitive.E{Key: "address.$.country", Value: address.Country},
+		primitive.E{Key: "address.$.serviceable", Value: address.Serviceable},
+	}}}
+
+	result, err := usersCollection.UpdateOne(context, filter, update)
+
+	if err != nil {
+		slog.ErrorContext(context, "UpdateAddress failed", "error", err)
+		return models.Address{}, ErrCantUpdateAddress
+	}
+
+	if result.MatchedCount == 0 {
+		return models.Address{}, ErrCantFindAddress
+	}
+
+	for _, usage := range addressDefaults(address) {
+		if err = SetDefaultAddress(context, usersCollection, userID, addressID, usage); err != nil {
+			return models.Address{}, err
+		}
+	}
+
+	return FindAddress(context, usersCollection, userID, addressID)
+
+}
+
+// flags one address as the default and clears the flag on every other address in the same update
+
+func SetDefaultAddress(context context.Context, usersCollection *mongo.Collection, userID string, addressID primitive.ObjectID, usage string) error {
+
+	var field string
+
+	switch usage {
+	case AddressShipping:
+		field = "is_default_shipping"
+	case AddressBilling:
+		field = "is_default_billing"
+	default:
+		return ErrInvalidAddressUsage
+	}
+
+	objectID, err := userObjectID(userID)
+
+	if err != nil {
+		return err
+	}
+
+	if usage == AddressShipping {
+
+		address, err := FindAddress(context, usersCollection, userID, addressID)
+
+		if err != nil {
+			return err
+		}
+
+		if !address.Serviceable {
+			return postal.ErrUnserviceable
+		}
+
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: objectID}, primitive.E{Key: "address._id", Value: addressID}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "address.$[target]." + field, Value: true},
+		primitive.E{Key: "address.$[other]." + field, Value: false},
+	}}}
+	arrayFilters := options.ArrayFilters{Filters: []interface{}{
+		bson.D{primitive.E{Key: "target._id", Value: addressID}},
+		bson.D{primitive.E{Key: "other._id", Value: bson.D{primitive.E{Key: "$ne", Value: addressID}}}},
+	}}
+
+	result, err := usersCollection.UpdateOne(context, filter, update, options.Update().SetArrayFilters(arrayFilters))
+
+	if err != nil {
+		slog.ErrorContext(context, "SetDefaultAddress failed", "error", err)
+		return ErrCantUpdateAddress
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrCantFindAddress
+	}
+
+	return nil
+
+}
+
+// removing a default address hands its flags to the oldest remaining address that can take them
+
+func DeleteAddress(context context.Context, usersCollection *mongo.Collection, userID string, addressID primitive.ObjectID) error {
+
+	objectID, err := userObjectID(userID)
+
+	if err != nil {
+		return err
+	}
+
+	removed, err := FindAddress(context, usersCollection, userID, addressID)
+
+	if err != nil {
+		return err
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: objectID}}
+	update := bson.D{{Key: "$pull", Value: bson.D{primitive.E{Key: "address", Value: bson.D{primitive.E{Key: "_id", Value: addressID}}}}}}
+
+	if _, err = usersCollection.UpdateOne(context, filter, update); err != nil {
+		slog.ErrorContext(context, "DeleteAddress failed", "error", err)
+		return ErrCantUpdateAddress
+	}
+
+	remaining, err := ListAddresses(context, usersCollection, userID)
+
+	if err != nil || len(remaining) == 0 {
+		return err
+	}
+
+	if removed.Is_Default_Billing {
+		if err = SetDefaultAddress(context, usersCollection, userID, remaining[0].Address_id, AddressBilling); err != nil {
+			return err
+		}
+	}
+
+	// only a deliverable address can take over shipping, without one the user picks a new default
+	if removed.Is_Default_Shipping {
+		for _, address := range remaining {
+			if address.Serviceable {
+				return SetDefaultAddress(context, usersCollection, userID, address.Address_id, AddressShipping)
+			}
+		}
+	}
+
+	return nil
+
+}
+
+// the addresses picked at checkout, nil picks the flagged default
+
+type CheckoutAddresses struct {
+	Shipping_ID *primitive.ObjectID
+	Billing_ID  *primitive.ObjectID
+}
+
+// a code the dataset does not know keeps the address deliverable, only a malformed one, eg : saved before
+// postal validation existed, still fails
+
+func refreshServiceable(address *models.Address) error {
+
+	pincode := ""
+
+	if address.Pincode != nil {
+		pincode = *address.Pincode
+	}
+
+	place, err := postal.Places.Lookup(address.Country, pincode)
+
+	if err != nil {
+		return err
+	}
+
+	address.Country = place.Country
+	address.Pincode = &place.Pincode
+	address.Serviceable = place.Serviceable
+
+	return nil
+
+}
+
+// resolves the checkout addresses and re-checks the shipping address is still served, the returned copies go into the order
+// without a default the first address of the book is used for shipping, and billing falls back to the shipping address
+
+func ResolveCheckoutAddresses(context context.Context, usersCollection *mongo.Collection, userID string, choice CheckoutAddresses) (models.Address, models.Address, error) {
+
+	addresses, err := ListAddresses(context, usersCollection, userID)
+
+	if err != nil {
+		return models.Address{}, models.Address{}, err
+	}
+
+	if len(addresses) == 0 {
+		return models.Address{}, models.Address{}, ErrNoShippingAddress
+	}
+
+	pick := func(addressID *primitive.ObjectID, flagged func(models.Address) bool, fallback *models.Address) (models.Address, error) {
+
+		for _, address := range addresses {
+			if (addressID != nil && address.Address_id == *addressID) || (addressID == nil && flagged(address)) {
+				return address, nil
+			}
+		}
+
+		if addressID != nil || fallback == nil {
+			return models.Address{}, ErrCantFindAddress
+		}
+
+		return *fallback, nil
+
+	}
+
+	shipping, err := pick(choice.Shipping_ID, func(address models.Address) bool { return address.Is_Default_Shipping }, &addresses[0])
+
+	if err != nil {
+		return models.Address{}, models.Address{}, err
+	}
+
+	billing, err := pick(choice.Billing_ID, func(address models.Address) bool { return address.Is_Default_Billing }, &shipping)
+
+	if err != nil {
+		return models.Address{}, models.Address{}, err
+	}
+
+	// stored addresses were completed when they were saved, only whether we still deliver there is checked again,
+	// eg : a postal code that stopped being served, the billing address is never delivered to
+	if err = refreshServiceable(&shipping); err != nil {
+		return models.Address{}, models.Address{}, err
+	}
+
+	if !shipping.Serviceable {
+		return models.Address{}, models.Address{}, postal.ErrUnserviceable
+	}
+
+	shipping.Is_Default_Shipping, shipping.Is_Default_Billing = false, false
+	billing.Is_Default_Shipping, billing.Is_Default_Billing = false, false
+
+	return shipping, billing, nil
+
+}
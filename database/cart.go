@@ -3,13 +3,15 @@ package database
 import (
 	"context"
 	"errors"
-	"log"
+	"log/slog"
 	"time"
 
+	"github.com/aaravmahajanofficial/ecommerce-project/currency"
 	"github.com/aaravmahajanofficial/ecommerce-project/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
@@ -20,39 +22,50 @@ var (
 	ErrCantRemoveItem     = errors.New("unable to remove item from cart")
 	ErrCantGetItem        = errors.New("unable to retrieve item from cart")
 	ErrCantBuyCartItem    = errors.New("unable to process the purchase of cart item")
+	ErrCantFindCartItem   = errors.New("product is not in the cart")
+	ErrCartIsEmpty        = errors.New("no items in cart")
 )
 
-func AddProductToCart(context context.Context, productsCollection *mongo.Collection, usersCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+// every change by the customer restarts the idle clock, and with it the abandoned cart reminders
 
-	filter := bson.D{primitive.E{Key: "_id", Value: productID}}
-	cursor, err := productsCollection.Find(context, filter)
+func cartModified(now time.Time) bson.D {
+
+	return bson.D{primitive.E{Key: "cart_updated_at", Value: now}, primitive.E{Key: "cart_reminders", Value: 0}}
+
+}
+
+func AddProductToCart(context context.Context, productsCollection *mongo.Collection, usersCollection *mongo.Collection, productID primitive.ObjectID, variantID *primitive.ObjectID, userID string) error {
+
+	product, err := FindProduct(context, productsCollection, productID)
 
 	if err != nil {
-		log.Println(err)
-		return ErrCantFindProduct
+		return err
 	}
 
-	var itemsToBeAdded []models.ProductUser
+	// products with variants are added as the selected SKU, with its own price
+	itemToBeAdded, err := CartLineForProduct(product, variantID)
 
-	if err = cursor.All(context, &itemsToBeAdded); err != nil {
-		log.Println(err)
-		return ErrCantDecodeProducts
+	if err != nil {
+		return err
 	}
 
 	objectID, err := primitive.ObjectIDFromHex(userID)
 
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(context, "AddProductToCart failed", "error", err)
 		return ErrUserIDIsNotValid
 	}
 
 	// need to find the document of the user, to insert this item in the user cart
-	filter = bson.D{primitive.E{Key: "_id", Value: objectID}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "usercart", Value: bson.D{primitive.E{Key: "$each", Value: itemsToBeAdded}}}}}}
+	filter := bson.D{primitive.E{Key: "_id", Value: objectID}}
+	update := bson.D{
+		{Key: "$push", Value: bson.D{primitive.E{Key: "usercart", Value: itemToBeAdded}}},
+		{Key: "$set", Value: cartModified(time.Now())},
+	}
 	_, err = usersCollection.UpdateOne(context, filter, update)
 
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(context, "AddProductToCart failed", "error", err)
 		return ErrCantUpdateUser
 	}
 
@@ -60,22 +73,32 @@ func AddProductToCart(context context.Context, productsCollection *mongo.Collect
 
 }
 
-func RemoveCartItem(context context.Context, productsCollection *mongo.Collection, usersCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+func RemoveCartItem(context context.Context, productsCollection *mongo.Collection, usersCollection *mongo.Collection, productID primitive.ObjectID, variantID *primitive.ObjectID, userID string) error {
 
 	// first convert the userID to primitive.ObjectID
 	objectID, err := primitive.ObjectIDFromHex(userID)
 
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(context, "RemoveCartItem failed", "error", err)
 		return ErrUserIDIsNotValid
 	}
 
+	// cart lines are documents, so the pull matches on their fields, only the selected variant is removed when one is given
+	line := bson.D{primitive.E{Key: "_id", Value: productID}}
+
+	if variantID != nil {
+		line = append(line, primitive.E{Key: "variant_id", Value: *variantID})
+	}
+
 	filter := bson.D{primitive.E{Key: "_id", Value: objectID}}
-	update := bson.D{{Key: "$pull", Value: bson.D{primitive.E{Key: "usercart", Value: productID}}}}
+	update := bson.D{
+		{Key: "$pull", Value: bson.D{primitive.E{Key: "usercart", Value: line}}},
+		{Key: "$set", Value: cartModified(time.Now())},
+	}
 	_, err = usersCollection.UpdateMany(context, filter, update)
 
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(context, "RemoveCartItem failed", "error", err)
 		return ErrCantUpdateUser
 	}
 
@@ -83,124 +106,185 @@ func RemoveCartItem(context context.Context, productsCollection *mongo.Collectio
 
 }
 
-func BuyItemFromCart(context context.Context, usersCollection *mongo.Collection, userID string) error {
+// every line is re-priced in the requested currency, the order keeps these prices even if rates change later
+
+func PriceCartItems(items []models.ProductUser, currencyCode string) ([]models.ProductUser, models.Money, error) {
+
+	pricedItems := make([]models.ProductUser, 0, len(items))
+	total := models.NewMoney(0, currencyCode)
+
+	for _, item := range items {
+
+		price, err := currency.PriceIn(item.Price, item.Prices, currencyCode)
+
+		if err != nil {
+			slog.Error("PriceCartItems failed", "error", err)
+			return nil, models.Money{}, err
+		}
+
+		item.Price = price
+		item.Prices = nil
+		pricedItems = append(pricedItems, item)
+
+		if total, err = total.Add(price); err != nil {
+			return nil, models.Money{}, err
+		}
+
+	}
+
+	return pricedItems, total, nil
+
+}
+
+func BuyItemFromCart(context context.Context, productsCollection *mongo.Collection, usersCollection *mongo.Collection, userID string, currencyCode string, addressChoice CheckoutAddresses) error {
 
 	// convert userID to ObjectID
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
 
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(context, "BuyItemFromCart failed", "error", err)
 		return ErrUserIDIsNotValid
 	}
 
-	// initialize order model
-	orderModel := models.Order{
-		Order_ID:       primitive.NewObjectID(),
-		Orderered_At:   time.Now(),
-		Order_Cart:     make([]models.ProductUser, 0),
-		Payment_Method: models.Payment{COD: true},
-	}
-
-	// find the documents of the user, and get the total of the items in the cart
-	filter := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: userObjectID}}}}
-	unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$usercart"}}}}
-	group := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, primitive.E{Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "usercart.price"}}}}}}
-
-	cursor, err := usersCollection.Aggregate(context, mongo.Pipeline{filter, unwind, group})
+	shippingAddress, billingAddress, err := ResolveCheckoutAddresses(context, usersCollection, userID, addressChoice)
 
 	if err != nil {
-		log.Panic(err)
 		return err
 	}
 
-	// extract total price from aggregation result
-	var totalAmount int32
-	var totalResults []bson.M
+	// the cart is checked against the current catalog, any change not yet acknowledged blocks the order
+	cartItems, unchanged, err := cartForCheckout(context, productsCollection, usersCollection, userObjectID)
 
-	if err = cursor.All(context, &totalResults); err != nil {
-		log.Println(err)
+	if err != nil {
 		return err
 	}
 
-	for _, result := range totalResults {
-		totalAmount = result["total"].(int32)
+	if len(cartItems) == 0 {
+		return ErrCartIsEmpty
 	}
 
-	orderModel.Price = int(totalAmount)
+	// the currency is locked in at checkout, every line and the total are stored in it
+	pricedItems, total, err := PriceCartItems(cartItems, currencyCode)
 
-	// push orderModel to user's orders array
-	filter = bson.D{primitive.E{Key: "_id", Value: userObjectID}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orderModel}}}}
-	if _, err := usersCollection.UpdateOne(context, filter, update); err != nil {
-		log.Println(err)
+	if err != nil {
 		return err
 	}
 
-	// retrieve user document to access usercart
-	var userModel models.User
-	if err := usersCollection.FindOne(context, filter).Decode(&userModel); err != nil {
-		log.Println(err)
+	if err = ReserveStock(context, productsCollection, pricedItems); err != nil {
 		return err
 	}
 
-	// add ordered items to each order's order_list array
-	update = bson.D{{Key: "$push", Value: bson.D{{Key: "orders.$[].order_list", Value: bson.D{{Key: "$each", Value: userModel.UserCart}}}}}}
-	if _, err := usersCollection.UpdateOne(context, filter, update); err != nil {
-		log.Println(err)
-		return err
+	// initialize order model
+	orderModel := models.Order{
+		Order_ID:         primitive.NewObjectID(),
+		Orderered_At:     time.Now(),
+		Order_Cart:       pricedItems,
+		Price:            total,
+		Payment_Method:   models.Payment{COD: true},
+		Fulfillment:      models.FulfillmentUnfulfilled,
+		Recovered_Cart:   wasRemindedOfCart(context, usersCollection, userObjectID),
+		Shipping_Address: &shippingAddress,
+		Billing_Address:  &billingAddress,
 	}
 
-	// Clear user's cart after successful purchase
+	// the order is pushed and the cart emptied in one write, only while the cart still holds exactly the ordered lines,
+	// so a line added meanwhile is neither lost nor ordered unseen, an empty cart is never reminded about
 	emptyCart := make([]models.ProductUser, 0)
-	emptyCartUpdate := bson.D{{Key: "$set", Value: bson.D{{Key: "usercart", Value: emptyCart}}}}
-	if _, err := usersCollection.UpdateOne(context, filter, emptyCartUpdate); err != nil {
-		log.Println(err)
+	update := bson.D{
+		{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orderModel}}},
+		{Key: "$set", Value: bson.D{{Key: "usercart", Value: emptyCart}}},
+		{Key: "$unset", Value: bson.D{{Key: "cart_warnings", Value: ""}, {Key: "cart_updated_at", Value: ""}, {Key: "cart_reminded_at", Value: ""}, {Key: "cart_reminders", Value: ""}}},
+	}
+
+	result, err := usersCollection.UpdateOne(context, unchanged, update)
+
+	if err != nil {
+		slog.ErrorContext(context, "BuyItemFromCart failed", "error", err)
+		ReleaseStock(context, productsCollection, pricedItems)
 		return ErrCantBuyCartItem
 	}
 
+	if result.MatchedCount == 0 {
+		ReleaseStock(context, productsCollection, pricedItems)
+		return ErrCartChanged
+	}
+
 	return nil
 
 }
 
-func InstantBuy(context context.Context, productsCollection *mongo.Collection, usersCollection *mongo.Collection, prouductID primitive.ObjectID, userID string) error {
+// orders placed after an abandoned cart reminder count as recovered in the abandonment metrics
+
+func wasRemindedOfCart(context context.Context, usersCollection *mongo.Collection, userID primitive.ObjectID) bool {
+
+	var user models.User
+
+	filter := bson.D{primitive.E{Key: "_id", Value: userID}}
+	projection := bson.D{primitive.E{Key: "cart_reminded_at", Value: 1}}
+
+	if err := usersCollection.FindOne(context, filter, options.FindOne().SetProjection(projection)).Decode(&user); err != nil {
+		slog.ErrorContext(context, "wasRemindedOfCart failed", "error", err)
+		return false
+	}
+
+	return user.Cart_Reminded_At != nil
+
+}
+
+func InstantBuy(context context.Context, productsCollection *mongo.Collection, usersCollection *mongo.Collection, prouductID primitive.ObjectID, variantID *primitive.ObjectID, userID string, currencyCode string, addressChoice CheckoutAddresses) error {
 
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
 
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(context, "InstantBuy failed", "error", err)
 		return ErrUserIDIsNotValid
 	}
 
-	orderModel := models.Order{
-		Order_ID:       primitive.NewObjectID(),
-		Orderered_At:   time.Now(),
-		Order_Cart:     make([]models.ProductUser, 0),
-		Payment_Method: models.Payment{COD: true},
+	shippingAddress, billingAddress, err := ResolveCheckoutAddresses(context, usersCollection, userID, addressChoice)
+
+	if err != nil {
+		return err
 	}
 
-	var productDetail models.ProductUser
+	product, err := FindProduct(context, productsCollection, prouductID)
 
-	filter := bson.D{{Key: "_id", Value: prouductID}}
-	if err = productsCollection.FindOne(context, filter).Decode(&productDetail); err != nil {
-		log.Println(err)
+	if err != nil {
 		return err
 	}
 
-	orderModel.Price = productDetail.Price
+	productDetail, err := CartLineForProduct(product, variantID)
 
-	// push orderModel to user's orders array
-	filter = bson.D{primitive.E{Key: "_id", Value: userObjectID}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orderModel}}}}
-	if _, err := usersCollection.UpdateOne(context, filter, update); err != nil {
-		log.Println(err)
+	if err != nil {
 		return err
 	}
 
-	// add ordered item to order's list
-	update = bson.D{{Key: "$push", Value: bson.D{{Key: "orders.$[].order_list", Value: productDetail}}}}
+	pricedItems, total, err := PriceCartItems([]models.ProductUser{productDetail}, currencyCode)
+
+	if err != nil {
+		return err
+	}
 
-	if _, err = usersCollection.UpdateOne(context, filter, update); err != nil {
-		log.Println(err)
+	if err = ReserveStock(context, productsCollection, pricedItems); err != nil {
+		return err
+	}
+
+	orderModel := models.Order{
+		Order_ID:         primitive.NewObjectID(),
+		Orderered_At:     time.Now(),
+		Order_Cart:       pricedItems,
+		Price:            total,
+		Payment_Method:   models.Payment{COD: true},
+		Fulfillment:      models.FulfillmentUnfulfilled,
+		Shipping_Address: &shippingAddress,
+		Billing_Address:  &billingAddress,
+	}
+
+	// push orderModel to user's orders array
+	filter := bson.D{primitive.E{Key: "_id", Value: userObjectID}}
+	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orderModel}}}}
+	if _, err := usersCollection.UpdateOne(context, filter, update); err != nil {
+		slog.ErrorContext(context, "InstantBuy failed", "error", err)
+		ReleaseStock(context, productsCollection, pricedItems)
 		return err
 	}
 
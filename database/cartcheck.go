@@ -0,0 +1,324 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrCartChanged = errors.New("the cart changed since items were added, review and acknowledge the changes before checking out")
+
+// returned by checkout with every change the customer has not acknowledged yet
+
+type CartChangedError struct {
+	Warnings []models.CartWarning
+}
+
+func (err *CartChangedError) Error() string {
+	return ErrCartChanged.Error()
+}
+
+func (err *CartChangedError) Unwrap() error {
+	return ErrCartChanged
+}
+
+func (err *CartChangedError) ErrorDetails() any {
+	return err.Warnings
+}
+
+func samePrices(left []models.Money, right []models.Money) bool {
+
+	if len(left) != len(right) {
+		return false
+	}
+
+	for index := range left {
+		if left[index] != right[index] {
+			return false
+		}
+	}
+
+	return true
+
+}
+
+// re-reads every product in the cart, lines get the current price and details, lines that can no longer
+// be bought are dropped and surplus copies beyond the available stock are removed, each change becomes a warning
+
+func ValidateCart(context context.Context, productsCollection *mongo.Collection, items []models.ProductUser) ([]models.ProductUser, []models.CartWarning, error) {
+
+	warnings := make([]models.CartWarning, 0)
+	validated := make([]models.ProductUser, 0, len(items))
+
+	if len(items) == 0 {
+		return validated, warnings, nil
+	}
+
+	productIDs := make([]primitive.ObjectID, 0, len(items))
+
+	for _, item := range items {
+		productIDs = append(productIDs, item.Product_ID)
+	}
+
+	cursor, err := productsCollection.Find(context, bson.D{primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$in", Value: productIDs}}}})
+
+	if err != nil {
+		slog.ErrorContext(context, "ValidateCart failed", "error", err)
+		return nil, nil, ErrCantFindProduct
+	}
+
+	var products []models.Product
+
+	if err = cursor.All(context, &products); err != nil {
+		slog.ErrorContext(context, "ValidateCart failed", "error", err)
+		return nil, nil, ErrCantDecodeProducts
+	}
+
+	productsByID := make(map[primitive.ObjectID]models.Product, len(products))
+
+	for _, product := range products {
+		productsByID[product.Product_ID] = product
+	}
+
+	// the cart holds one line per unit, so a SKU in the cart N times needs a stock of N
+	inCart := make(map[string]int)
+	warned := make(map[string]bool)
+
+	for _, item := range items {
+
+		key := item.Product_ID.Hex()
+
+		if item.Variant_ID != nil {
+			key += "/" + item.Variant_ID.Hex()
+		}
+
+		warning := models.CartWarning{Product_ID: item.Product_ID, Variant_ID: item.Variant_ID, Product_Name: item.Product_Name}
+
+		product, ok := productsByID[item.Product_ID]
+
+		if !ok {
+			if !warned[key] {
+				warning.Code = models.CartProductRemoved
+				warning.Message = "product is no longer available and was removed from the cart"
+				warnings = append(warnings, warning)
+				warned[key] = true
+			}
+			continue
+		}
+
+		current, err := CartLineForProduct(product, item.Variant_ID)
+
+		switch {
+		case errors.Is(err, ErrOutOfStock):
+			if !warned[key] {
+				warning.Code = models.CartOutOfStock
+				warning.Message = "product is out of stock and was removed from the cart"
+				warnings = append(warnings, warning)
+				warned[key] = true
+			}
+			continue
+		case err != nil:
+			if !warned[key] {
+				warning.Code = models.CartVariantRemoved
+				warning.Message = "the selected option is no longer available and was removed from the cart"
+				warnings = append(warnings, warning)
+				warned[key] = true
+			}
+			continue
+		}
+
+		if current.Variant_ID != nil {
+
+			variant, _ := product.FindVariant(*current.Variant_ID)
+
+			if inCart[key] >= variant.Stock {
+				if !warned[key] {
+					stock := variant.Stock
+					warning.Code = models.CartInsufficientStock
+					warning.Message = fmt.Sprintf("only %d left in stock, the quantity was reduced", stock)
+					warning.Available_Stock = &stock
+					warnings = append(warnings, warning)
+					warned[key] = true
+				}
+				continue
+			}
+
+		}
+
+		inCart[key]++
+
+		if (current.Price != item.Price || !samePrices(current.Prices, item.Prices)) && !warned[key] {
+			oldPrice, newPrice := item.Price, current.Price
+			warning.Code = models.CartPriceChanged
+			warning.Message = fmt.Sprintf("price changed from %s to %s", oldPrice.String(), newPrice.String())
+			warning.Old_Price = &oldPrice
+			warning.New_Price = &newPrice
+			warnings = append(warnings, warning)
+			warned[key] = true
+		}
+
+		validated = append(validated, current)
+
+	}
+
+	return validated, warnings, nil
+
+}
+
+// validates the stored cart, saves the corrected lines and adds new warnings to the ones still unacknowledged
+
+func RefreshCart(context context.Context, productsCollection *mongo.Collection, usersCollection *mongo.Collection, userID string) ([]models.ProductUser, []models.CartWarning, error) {
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		slog.ErrorContext(context, "RefreshCart failed", "error", err)
+		return nil, nil, ErrUserIDIsNotValid
+	}
+
+	for attempt := 0; attempt < refreshAttempts; attempt++ {
+
+		items, warnings, _, saved, err := refreshCartOnce(context, productsCollection, usersCollection, userObjectID)
+
+		if err != nil || saved {
+			return items, warnings, err
+		}
+
+	}
+
+	slog.WarnContext(context, "RefreshCart gave up, the cart kept changing", "attempts", refreshAttempts)
+	return nil, nil, ErrCantUpdateUser
+
+}
+
+// a cart changed by another request between reading and writing it is read again, eg : an item added while the cart is viewed
+const refreshAttempts = 3
+
+// saved is false when the cart changed in the meantime and nothing was written,
+// unchanged matches the user only while the cart is still stored as it was read
+
+func refreshCartOnce(context context.Context, productsCollection *mongo.Collection, usersCollection *mongo.Collection, userObjectID primitive.ObjectID) (items []models.ProductUser, pending []models.CartWarning, unchanged bson.D, saved bool, err error) {
+
+	var raw bson.Raw
+
+	filter := bson.D{primitive.E{Key: "_id", Value: userObjectID}}
+
+	if raw, err = usersCollection.FindOne(context, filter).Raw(); err != nil {
+		slog.ErrorContext(context, "RefreshCart failed", "error", err)
+		return nil, nil, nil, false, ErrCantGetItem
+	}
+
+	var user models.User
+
+	if err = bson.Unmarshal(raw, &user); err != nil {
+		slog.ErrorContext(context, "RefreshCart failed", "error", err)
+		return nil, nil, nil, false, ErrCantGetItem
+	}
+
+	// compared as stored so re-encoding cannot differ
+	unchanged = append(filter,
+		primitive.E{Key: "usercart", Value: storedValue(raw, "usercart")},
+		primitive.E{Key: "cart_warnings", Value: storedValue(raw, "cart_warnings")},
+	)
+
+	items, warnings, err := ValidateCart(context, productsCollection, user.UserCart)
+
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+
+	pending = append(make([]models.CartWarning, 0, len(user.Cart_Warnings)+len(warnings)), user.Cart_Warnings...)
+
+	if len(warnings) == 0 {
+		return items, pending, unchanged, true, nil
+	}
+
+	pending = append(pending, warnings...)
+
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "usercart", Value: items},
+		primitive.E{Key: "cart_warnings", Value: pending},
+	}}}
+
+	// only written while the cart is still the one that was checked
+	result, err := usersCollection.UpdateOne(context, unchanged, update)
+
+	if err != nil {
+		slog.ErrorContext(context, "RefreshCart failed", "error", err)
+		return nil, nil, nil, false, ErrCantUpdateUser
+	}
+
+	// the cart was just rewritten, the filter no longer matches it
+	return items, pending, nil, result.MatchedCount == 1, nil
+
+}
+
+// the cart a checkout orders, refreshed like RefreshCart, with the filter that matches the user only while the cart
+// is still exactly these lines, so the order and the emptied cart are written together or not at all
+
+func cartForCheckout(context context.Context, productsCollection *mongo.Collection, usersCollection *mongo.Collection, userObjectID primitive.ObjectID) ([]models.ProductUser, bson.D, error) {
+
+	for attempt := 0; attempt < refreshAttempts; attempt++ {
+
+		items, pending, unchanged, saved, err := refreshCartOnce(context, productsCollection, usersCollection, userObjectID)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// any change not yet acknowledged blocks the order
+		if len(pending) > 0 {
+			return nil, nil, &CartChangedError{Warnings: pending}
+		}
+
+		if saved {
+			return items, unchanged, nil
+		}
+
+	}
+
+	slog.WarnContext(context, "cartForCheckout gave up, the cart kept changing", "attempts", refreshAttempts)
+	return nil, nil, ErrCartChanged
+
+}
+
+// a missing field is matched by null, which also matches documents that still lack it
+
+func storedValue(document bson.Raw, key string) interface{} {
+
+	value, err := document.LookupErr(key)
+
+	if err != nil {
+		return nil
+	}
+
+	return value
+
+}
+
+func AcknowledgeCartWarnings(context context.Context, usersCollection *mongo.Collection, userID string) error {
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		slog.ErrorContext(context, "AcknowledgeCartWarnings failed", "error", err)
+		return ErrUserIDIsNotValid
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: userObjectID}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "cart_warnings", Value: make([]models.CartWarning, 0)}}}}
+
+	if _, err = usersCollection.UpdateOne(context, filter, update); err != nil {
+		slog.ErrorContext(context, "AcknowledgeCartWarnings failed", "error", err)
+		return ErrCantUpdateUser
+	}
+
+	return nil
+
+}
@@ -0,0 +1,452 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindCategory      = errors.New("unable to find the specified category")
+	ErrCategorySlugTaken     = errors.New("category slug is already in use")
+	ErrCategorySlugNotValid  = errors.New("category slug may only contain lowercase letters, digits and single dashes")
+	ErrCategoryCycle         = errors.New("a category cannot be moved below itself or one of its descendants")
+	ErrCategoryHasChildren   = errors.New("category still has child categories")
+	ErrCantUpdateCategory    = errors.New("unable to update category information")
+	ErrCantDecodeCategories  = errors.New("unable to decode category information")
+	ErrCantUpdateProductInfo = errors.New("unable to update product information")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+var slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// eg : "Men's Shoes & Boots" -> "men-s-shoes-boots"
+
+func Slugify(name string) string {
+	return strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// slug uniqueness is enforced by the index, the count checks before writes only give a nicer error
+
+func EnsureCategoryIndexes(context context.Context, categoriesCollection *mongo.Collection) error {
+
+	_, err := categoriesCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		{Keys: bson.D{primitive.E{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{primitive.E{Key: "ancestors", Value: 1}}},
+		{Keys: bson.D{primitive.E{Key: "parent_id", Value: 1}}},
+	})
+
+	if err != nil {
+		slog.ErrorContext(context, "EnsureCategoryIndexes failed", "error", err)
+	}
+
+	return err
+
+}
+
+func FindCategory(context context.Context, categoriesCollection *mongo.Collection, filter bson.D) (models.Category, error) {
+
+	var category models.Category
+
+	if err := categoriesCollection.FindOne(context, filter).Decode(&category); err != nil {
+		slog.ErrorContext(context, "FindCategory failed", "error", err)
+		return models.Category{}, ErrCantFindCategory
+	}
+
+	return category, nil
+
+}
+
+func validateSlug(context context.Context, categoriesCollection *mongo.Collection, slug string, categoryID primitive.ObjectID) error {
+
+	if !slugPattern.MatchString(slug) {
+		return ErrCategorySlugNotValid
+	}
+
+	filter := bson.D{primitive.E{Key: "slug", Value: slug}, primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$ne", Value: categoryID}}}}
+	count, err := categoriesCollection.CountDocuments(context, filter)
+
+	if err != nil {
+		slog.ErrorContext(context, "validateSlug failed", "error", err)
+		return ErrCantDecodeCategories
+	}
+
+	if count > 0 {
+		return ErrCategorySlugTaken
+	}
+
+	return nil
+
+}
+
+// ancestors holds the path from the root down to the parent, so descendants are found with a single indexed query
+
+func ancestorsFor(context context.Context, categoriesCollection *mongo.Collection, parentID *primitive.ObjectID) ([]primitive.ObjectID, error) {
+
+	if parentID == nil {
+		return make([]primitive.ObjectID, 0), nil
+	}
+
+	parent, err := FindCategory(context, categoriesCollection, bson.D{primitive.E{Key: "_id", Value: *parentID}})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append(make([]primitive.ObjectID, 0, len(parent.Ancestors)+1), parent.Ancestors...), parent.Category_ID), nil
+
+}
+
+func CreateCategory(context context.Context, categoriesCollection *mongo.Collection, category models.Category) (models.Category, error) {
+
+	category.Category_ID = primitive.NewObjectID()
+
+	if category.Slug == "" {
+		category.Slug = Slugify(*category.Name)
+	}
+
+	if err := validateSlug(context, categoriesCollection, category.Slug, category.Category_ID); err != nil {
+		return models.Category{}, err
+	}
+
+	ancestors, err := ancestorsFor(context, categoriesCollection, category.Parent_ID)
+
+	if err != nil {
+		return models.Category{}, err
+	}
+
+	category.Ancestors = ancestors
+	category.Created_At = time.Now()
+	category.Updated_At = category.Created_At
+
+	if _, err = categoriesCollection.InsertOne(context, category); err != nil {
+		slog.ErrorContext(context, "CreateCategory failed", "error", err)
+		if mongo.IsDuplicateKeyError(err) {
+			return models.Category{}, ErrCategorySlugTaken
+		}
+		return models.Category{}, ErrCantUpdateCategory
+	}
+
+	return category, nil
+
+}
+
+// moving a category rewrites the ancestors of its whole subtree, the prefix up to and including the moved category changes
+// a nil parent in the changes keeps the current one, moveToRoot turns the category into a top level one
+
+func UpdateCategory(context context.Context, categoriesCollection *mongo.Collection, categoryID primitive.ObjectID, changes models.Category, moveToRoot bool) (models.Category, error) {
+
+	category, err := FindCategory(context, categoriesCollection, bson.D{primitive.E{Key: "_id", Value: categoryID}})
+
+	if err != nil {
+		return models.Category{}, err
+	}
+
+	if changes.Name != nil {
+		category.Name = changes.Name
+	}
+
+	if changes.Description != nil {
+		category.Description = changes.Description
+	}
+
+	if changes.Slug != "" && changes.Slug != category.Slug {
+		if err = validateSlug(context, categoriesCollection, changes.Slug, categoryID); err != nil {
+			return models.Category{}, err
+		}
+		category.Slug = changes.Slug
+	}
+
+	oldAncestors := category.Ancestors
+	moved := changes.Parent_ID != nil && (category.Parent_ID == nil || *category.Parent_ID != *changes.Parent_ID)
+
+	if moveToRoot {
+		changes.Parent_ID = nil
+		moved = category.Parent_ID != nil
+	}
+
+	if moved {
+
+		ancestors, err := ancestorsFor(context, categoriesCollection, changes.Parent_ID)
+
+		if err != nil {
+			return models.Category{}, err
+		}
+
+		for _, ancestorID := range ancestors {
+			if ancestorID == categoryID {
+				return models.Category{}, ErrCategoryCycle
+			}
+		}
+
+		if changes.Parent_ID != nil && *changes.Parent_ID == categoryID {
+			return models.Category{}, ErrCategoryCycle
+		}
+
+		category.Parent_ID = changes.Parent_ID
+		category.Ancestors = ancestors
+
+	}
+
+	category.Updated_At = time.Now()
+
+	filter := bson.D{primitive.E{Key: "_id", Value: categoryID}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "name", Value: category.Name},
+		primitive.E{Key: "slug", Value: category.Slug},
+		primitive.E{Key: "description", Value: category.Description},
+		primitive.E{Key: "parent_id", Value: category.Parent_ID},
+		primitive.E{Key: "ancestors", Value: category.Ancestors},
+		primitive.E{Key: "updated_at", Value: category.Updated_At},
+	}}}
+
+	if _, err = categoriesCollection.UpdateOne(context, filter, update); err != nil {
+		slog.ErrorContext(context, "UpdateCategory failed", "error", err)
+		if mongo.IsDuplicateKeyError(err) {
+			return models.Category{}, ErrCategorySlugTaken
+		}
+		return models.Category{}, ErrCantUpdateCategory
+	}
+
+	if !moved {
+		return category, nil
+	}
+
+	cursor, err := categoriesCollection.Find(context, bson.D{primitive.E{Key: "ancestors", Value: categoryID}})
+
+	if err != nil {
+		slog.ErrorContext(context, "UpdateCategory failed", "error", err)
+		return models.Category{}, ErrCantUpdateCategory
+	}
+
+	var descendants []models.Category
+
+	if err = cursor.All(context, &descendants); err != nil {
+		slog.ErrorContext(context, "UpdateCategory failed", "error", err)
+		return models.Category{}, ErrCantDecodeCategories
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(descendants))
+	prefix := append(append(make([]primitive.ObjectID, 0, len(category.Ancestors)+1), category.Ancestors...), categoryID)
+
+	for _, descendant := range descendants {
+
+		// everything below the moved category keeps its relative path
+		suffix := descendant.Ancestors[len(oldAncestors)+1:]
+		ancestors := append(append(make([]primitive.ObjectID, 0, len(prefix)+len(suffix)), prefix...), suffix...)
+
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{primitive.E{Key: "_id", Value: descendant.Category_ID}}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "ancestors", Value: ancestors}}}}))
+
+	}
+
+	if len(writes) > 0 {
+		if _, err = categoriesCollection.BulkWrite(context, writes); err != nil {
+			slog.ErrorContext(context, "UpdateCategory failed", "error", err)
+			return models.Category{}, ErrCantUpdateCategory
+		}
+	}
+
+	return category, nil
+
+}
+
+// only leaf categories can be deleted, products keep their other categories
+
+func DeleteCategory(context context.Context, categoriesCollection *mongo.Collection, productsCollection *mongo.Collection, categoryID primitive.ObjectID) error {
+
+	count, err := categoriesCollection.CountDocuments(context, bson.D{primitive.E{Key: "parent_id", Value: categoryID}})
+
+	if err != nil {
+		slog.ErrorContext(context, "DeleteCategory failed", "error", err)
+		return ErrCantDecodeCategories
+	}
+
+	if count > 0 {
+		return ErrCategoryHasChildren
+	}
+
+	result, err := categoriesCollection.DeleteOne(context, bson.D{primitive.E{Key: "_id", Value: categoryID}})
+
+	if err != nil {
+		slog.ErrorContext(context, "DeleteCategory failed", "error", err)
+		return ErrCantUpdateCategory
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrCantFindCategory
+	}
+
+	filter := bson.D{primitive.E{Key: "category_ids", Value: categoryID}}
+	update := bson.D{{Key: "$pull", Value: bson.D{primitive.E{Key: "category_ids", Value: categoryID}}}}
+
+	if _, err = productsCollection.UpdateMany(context, filter, update); err != nil {
+		slog.ErrorContext(context, "DeleteCategory failed", "error", err)
+		return ErrCantUpdateProductInfo
+	}
+
+	return nil
+
+}
+
+func GetCategoryTree(context context.Context, categoriesCollection *mongo.Collection) ([]*models.CategoryNode, error) {
+
+	cursor, err := categoriesCollection.Find(context, bson.D{})
+
+	if err != nil {
+		slog.ErrorContext(context, "GetCategoryTree failed", "error", err)
+		return nil, ErrCantDecodeCategories
+	}
+
+	var categories []models.Category
+
+	if err = cursor.All(context, &categories); err != nil {
+		slog.ErrorContext(context, "GetCategoryTree failed", "error", err)
+		return nil, ErrCantDecodeCategories
+	}
+
+	nodes := make(map[primitive.ObjectID]*models.CategoryNode, len(categories))
+
+	for _, category := range categories {
+		nodes[category.Category_ID] = &models.CategoryNode{Category: category, Children: make([]*models.CategoryNode, 0)}
+	}
+
+	roots := make([]*models.CategoryNode, 0)
+
+	for _, category := range categories {
+
+		node := nodes[category.Category_ID]
+
+		if category.Parent_ID == nil || nodes[*category.Parent_ID] == nil {
+			roots = append(roots, node)
+			continue
+		}
+
+		parent := nodes[*category.Parent_ID]
+		parent.Children = append(parent.Children, node)
+
+	}
+
+	sortCategoryNodes(roots)
+
+	return roots, nil
+
+}
+
+func sortCategoryNodes(nodes []*models.CategoryNode) {
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return *nodes[i].Name < *nodes[j].Name
+	})
+
+	for _, node := range nodes {
+		sortCategoryNodes(node.Children)
+	}
+
+}
+
+// returns the category and the ids of all categories below it, products in any of them belong to the category
+
+func CategoryWithDescendants(context context.Context, categoriesCollection *mongo.Collection, slug string) (models.Category, []primitive.ObjectID, error) {
+
+	category, err := FindCategory(context, categoriesCollection, bson.D{primitive.E{Key: "slug", Value: slug}})
+
+	if err != nil {
+		return models.Category{}, nil, err
+	}
+
+	filter := bson.D{primitive.E{Key: "ancestors", Value: category.Category_ID}}
+	cursor, err := categoriesCollection.Find(context, filter, options.Find().SetProjection(bson.D{primitive.E{Key: "_id", Value: 1}}))
+
+	if err != nil {
+		slog.ErrorContext(context, "CategoryWithDescendants failed", "error", err)
+		return models.Category{}, nil, ErrCantDecodeCategories
+	}
+
+	var descendants []models.Category
+
+	if err = cursor.All(context, &descendants); err != nil {
+		slog.ErrorContext(context, "CategoryWithDescendants failed", "error", err)
+		return models.Category{}, nil, ErrCantDecodeCategories
+	}
+
+	categoryIDs := []primitive.ObjectID{category.Category_ID}
+
+	for _, descendant := range descendants {
+		categoryIDs = append(categoryIDs, descendant.Category_ID)
+	}
+
+	return category, categoryIDs, nil
+
+}
+
+func ListCategoryProducts(context context.Context, categoriesCollection *mongo.Collection, productsCollection *mongo.Collection, slug string, query ProductListQuery) (models.Category, ProductPage, error) {
+
+	category, categoryIDs, err := CategoryWithDescendants(context, categoriesCollection, slug)
+
+	if err != nil {
+		return models.Category{}, ProductPage{}, err
+	}
+
+	// paged, sorted and filtered like every other product listing
+	filter := bson.D{primitive.E{Key: "category_ids", Value: bson.D{primitive.E{Key: "$in", Value: categoryIDs}}}}
+	page, err := ListProducts(context, productsCollection, categoriesCollection, filter, query)
+
+	if err != nil {
+		return models.Category{}, ProductPage{}, err
+	}
+
+	return category, page, nil
+
+}
+
+func SetProductCategories(context context.Context, categoriesCollection *mongo.Collection, productsCollection *mongo.Collection, productID primitive.ObjectID, categoryIDs []primitive.ObjectID) error {
+
+	count, err := categoriesCollection.CountDocuments(context, bson.D{primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$in", Value: categoryIDs}}}})
+
+	if err != nil {
+		slog.ErrorContext(context, "SetProductCategories failed", "error", err)
+		return ErrCantDecodeCategories
+	}
+
+	unique := make(map[primitive.ObjectID]bool, len(categoryIDs))
+	deduplicated := make([]primitive.ObjectID, 0, len(categoryIDs))
+
+	for _, categoryID := range categoryIDs {
+		if !unique[categoryID] {
+			unique[categoryID] = true
+			deduplicated = append(deduplicated, categoryID)
+		}
+	}
+
+	if int(count) != len(deduplicated) {
+		return ErrCantFindCategory
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: productID}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "category_ids", Value: deduplicated}}}}
+	result, err := productsCollection.UpdateOne(context, filter, update)
+
+	if err != nil {
+		slog.ErrorContext(context, "SetProductCategories failed", "error", err)
+		return ErrCantUpdateProductInfo
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrCantFindProduct
+	}
+
+	return nil
+
+}
@@ -0,0 +1,242 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindGuestCart   = errors.New("unable to find the guest cart, it may have expired")
+	ErrCantUpdateGuestCart = errors.New("unable to update guest cart")
+	ErrGuestCartFull       = errors.New("guest cart is full")
+)
+
+// every change pushes the expiry out again, abandoned guest carts are removed by the TTL index
+const (
+	GuestCartLifetime = 30 * 24 * time.Hour
+	MaxGuestCartItems = 100
+)
+
+func EnsureGuestCartIndexes(context context.Context, guestCartsCollection *mongo.Collection) error {
+
+	_, err := guestCartsCollection.Indexes().CreateOne(context, mongo.IndexModel{
+		Keys:    bson.D{primitive.E{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	if err != nil {
+		slog.ErrorContext(context, "EnsureGuestCartIndexes failed", "error", err)
+	}
+
+	return err
+
+}
+
+func CreateGuestCart(context context.Context, guestCartsCollection *mongo.Collection) (models.GuestCart, error) {
+
+	now := time.Now()
+
+	cart := models.GuestCart{
+		Cart_ID:    primitive.NewObjectID(),
+		Items:      make([]models.ProductUser, 0),
+		Created_At: now,
+		Updated_At: now,
+		Expires_At: now.Add(GuestCartLifetime),
+	}
+
+	if _, err := guestCartsCollection.InsertOne(context, cart); err != nil {
+		slog.ErrorContext(context, "CreateGuestCart failed", "error", err)
+		return models.GuestCart{}, ErrCantUpdateGuestCart
+	}
+
+	return cart, nil
+
+}
+
+func FindGuestCart(context context.Context, guestCartsCollection *mongo.Collection, cartID primitive.ObjectID) (models.GuestCart, error) {
+
+	var cart models.GuestCart
+
+	if err := guestCartsCollection.FindOne(context, bson.D{primitive.E{Key: "_id", Value: cartID}}).Decode(&cart); err != nil {
+		slog.ErrorContext(context, "FindGuestCart failed", "error", err)
+		return models.GuestCart{}, ErrCantFindGuestCart
+	}
+
+	return cart, nil
+
+}
+
+// same rules as the cart of a signed in user, one line per unit
+
+func AddProductToGuestCart(context context.Context, productsCollection *mongo.Collection, guestCartsCollection *mongo.Collection, cartID primitive.ObjectID, productID primitive.ObjectID, variantID *primitive.ObjectID) (models.GuestCart, error) {
+
+	product, err := FindProduct(context, productsCollection, productID)
+
+	if err != nil {
+		return models.GuestCart{}, err
+	}
+
+	line, err := CartLineForProduct(product, variantID)
+
+	if err != nil {
+		return models.GuestCart{}, err
+	}
+
+	now := time.Now()
+
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: cartID},
+		primitive.E{Key: "items." + strconv.Itoa(MaxGuestCartItems-1), Value: bson.D{primitive.E{Key: "$exists", Value: false}}},
+	}
+	update := bson.D{
+		{Key: "$push", Value: bson.D{primitive.E{Key: "items", Value: line}}},
+		{Key: "$set", Value: bson.D{primitive.E{Key: "updated_at", Value: now}, primitive.E{Key: "expires_at", Value: now.Add(GuestCartLifetime)}}},
+	}
+
+	var cart models.GuestCart
+
+	err = guestCartsCollection.FindOneAndUpdate(context, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&cart)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err = FindGuestCart(context, guestCartsCollection, cartID); err != nil {
+			return models.GuestCart{}, err
+		}
+		return models.GuestCart{}, ErrGuestCartFull
+	}
+
+	if err != nil {
+		slog.ErrorContext(context, "AddProductToGuestCart failed", "error", err)
+		return models.GuestCart{}, ErrCantUpdateGuestCart
+	}
+
+	return cart, nil
+
+}
+
+func RemoveGuestCartItem(context context.Context, guestCartsCollection *mongo.Collection, cartID primitive.ObjectID, productID primitive.ObjectID, variantID *primitive.ObjectID) (models.GuestCart, error) {
+
+	line := bson.D{primitive.E{Key: "_id", Value: productID}}
+
+	if variantID != nil {
+		line = append(line, primitive.E{Key: "variant_id", Value: *variantID})
+	}
+
+	now := time.Now()
+
+	filter := bson.D{primitive.E{Key: "_id", Value: cartID}}
+	update := bson.D{
+		{Key: "$pull", Value: bson.D{primitive.E{Key: "items", Value: line}}},
+		{Key: "$set", Value: bson.D{primitive.E{Key: "updated_at", Value: now}, primitive.E{Key: "expires_at", Value: now.Add(GuestCartLifetime)}}},
+	}
+
+	var cart models.GuestCart
+
+	if err := guestCartsCollection.FindOneAndUpdate(context, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&cart); err != nil {
+		slog.ErrorContext(context, "RemoveGuestCartItem failed", "error", err)
+		return models.GuestCart{}, ErrCantFindGuestCart
+	}
+
+	return cart, nil
+
+}
+
+// moves the guest cart into the user's cart and deletes it
+// a product and variant present in both carts keeps the larger of the two quantities instead of adding them up,
+// so a visitor who added the same item before and after signing in does not end up buying it twice,
+// that also makes the merge safe to repeat, the guest cart is only deleted once its items are in the user's cart
+// and is left for a later attempt whenever a step fails
+
+func MergeGuestCart(context context.Context, usersCollection *mongo.Collection, guestCartsCollection *mongo.Collection, cartID primitive.ObjectID, userID string) ([]models.ProductUser, error) {
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		slog.ErrorContext(context, "MergeGuestCart failed", "error", err)
+		return nil, ErrUserIDIsNotValid
+	}
+
+	cartFilter := bson.D{primitive.E{Key: "_id", Value: cartID}}
+	rawCart, err := guestCartsCollection.FindOne(context, cartFilter).Raw()
+
+	if err != nil {
+		slog.ErrorContext(context, "MergeGuestCart failed", "error", err)
+		return nil, ErrCantFindGuestCart
+	}
+
+	var guestCart models.GuestCart
+
+	if err = bson.Unmarshal(rawCart, &guestCart); err != nil {
+		slog.ErrorContext(context, "MergeGuestCart failed", "error", err)
+		return nil, ErrCantFindGuestCart
+	}
+
+	var user models.User
+
+	filter := bson.D{primitive.E{Key: "_id", Value: userObjectID}}
+
+	if err = usersCollection.FindOne(context, filter).Decode(&user); err != nil {
+		slog.ErrorContext(context, "MergeGuestCart failed", "error", err)
+		return nil, ErrCantGetItem
+	}
+
+	lineKey := func(line models.ProductUser) string {
+		if line.Variant_ID == nil {
+			return line.Product_ID.Hex()
+		}
+		return line.Product_ID.Hex() + "/" + line.Variant_ID.Hex()
+	}
+
+	inUserCart := make(map[string]int)
+
+	for _, line := range user.UserCart {
+		inUserCart[lineKey(line)]++
+	}
+
+	merged := make([]models.ProductUser, 0, len(guestCart.Items))
+	inGuestCart := make(map[string]int)
+
+	for _, line := range guestCart.Items {
+
+		key := lineKey(line)
+		inGuestCart[key]++
+
+		if inGuestCart[key] > inUserCart[key] {
+			merged = append(merged, line)
+		}
+
+	}
+
+	if len(merged) > 0 {
+
+		update := bson.D{
+			{Key: "$push", Value: bson.D{primitive.E{Key: "usercart", Value: bson.D{primitive.E{Key: "$each", Value: merged}}}}},
+			{Key: "$set", Value: cartModified(time.Now())},
+		}
+
+		if _, err = usersCollection.UpdateOne(context, filter, update); err != nil {
+			slog.ErrorContext(context, "MergeGuestCart failed", "error", err)
+			return nil, ErrCantUpdateUser
+		}
+
+	}
+
+	// only the items that were merged, a line the visitor added meanwhile keeps the cart for the next sign in
+	cartFilter = append(cartFilter, primitive.E{Key: "items", Value: storedValue(rawCart, "items")})
+
+	if _, err = guestCartsCollection.DeleteOne(context, cartFilter); err != nil {
+		// the items are merged already, merging the same cart again adds nothing
+		slog.WarnContext(context, "MergeGuestCart could not delete the guest cart", "error", err)
+	}
+
+	return append(user.UserCart, merged...), nil
+
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrCantFindImage    = errors.New("unable to find the specified product image")
+	ErrInvalidImageList = errors.New("image order must list every image of the product exactly once")
+)
+
+// positions are rewritten as 0..n-1 and the product's primary image follows the first one
+
+func saveProductImages(context context.Context, productsCollection *mongo.Collection, productID primitive.ObjectID, images []models.ProductImage) error {
+
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].Position < images[j].Position
+	})
+
+	for index := range images {
+		images[index].Position = index
+	}
+
+	var primary *string
+
+	if len(images) > 0 {
+		primary = &images[0].Medium_URL
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: productID}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "images", Value: images},
+		primitive.E{Key: "image", Value: primary},
+	}}}
+
+	if _, err := productsCollection.UpdateOne(context, filter, update); err != nil {
+		slog.ErrorContext(context, "saveProductImages failed", "error", err)
+		return ErrCantUpdateProductInfo
+	}
+
+	return nil
+
+}
+
+func AddProductImage(context context.Context, productsCollection *mongo.Collection, productID primitive.ObjectID, image models.ProductImage) ([]models.ProductImage, error) {
+
+	product, err := FindProduct(context, productsCollection, productID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	image.Position = len(product.Images)
+	images := append(product.Images, image)
+
+	if err = saveProductImages(context, productsCollection, productID, images); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+
+}
+
+// returns the removed image so its stored files can be deleted
+
+func RemoveProductImage(context context.Context, productsCollection *mongo.Collection, productID primitive.ObjectID, imageID primitive.ObjectID) (models.ProductImage, error) {
+
+	product, err := FindProduct(context, productsCollection, productID)
+
+	if err != nil {
+		return models.ProductImage{}, err
+	}
+
+	images := make([]models.ProductImage, 0, len(product.Images))
+	var removed *models.ProductImage
+
+	for index, image := range product.Images {
+
+		if image.Image_ID == imageID {
+			removed = &product.Images[index]
+			continue
+		}
+
+		images = append(images, image)
+
+	}
+
+	if removed == nil {
+		return models.ProductImage{}, ErrCantFindImage
+	}
+
+	if err = saveProductImages(context, productsCollection, productID, images); err != nil {
+		return models.ProductImage{}, err
+	}
+
+	return *removed, nil
+
+}
+
+func ReorderProductImages(context context.Context, productsCollection *mongo.Collection, productID primitive.ObjectID, imageIDs []primitive.ObjectID) ([]models.ProductImage, error) {
+
+	product, err := FindProduct(context, productsCollection, productID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(imageIDs) != len(product.Images) {
+		return nil, ErrInvalidImageList
+	}
+
+	positions := make(map[primitive.ObjectID]int, len(imageIDs))
+
+	for position, imageID := range imageIDs {
+
+		if _, duplicate := positions[imageID]; duplicate {
+			return nil, ErrInvalidImageList
+		}
+
+		positions[imageID] = position
+
+	}
+
+	for index, image := range product.Images {
+
+		position, ok := positions[image.Image_ID]
+
+		if !ok {
+			return nil, ErrInvalidImageList
+		}
+
+		product.Images[index].Position = position
+
+	}
+
+	if err = saveProductImages(context, productsCollection, productID, product.Images); err != nil {
+		return nil, err
+	}
+
+	return product.Images, nil
+
+}
@@ -0,0 +1,359 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidCursor    = errors.New("pagination cursor is not valid")
+	ErrInvalidSort      = errors.New("sort order is not supported")
+	ErrInvalidAttribute = errors.New("attribute filter name is not valid")
+)
+
+// attribute names end up in a field path, so operators and dots are never allowed
+var attributeNamePattern = regexp.MustCompile(`^[A-Za-z0-9_ -]{1,30}$`)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// every supported sort maps to a field and direction, _id is always the tie breaker so pages never overlap
+
+type productSort struct {
+	field     string
+	direction int
+}
+
+var productSorts = map[string]productSort{
+	"price_asc":   {field: "price.amount", direction: 1},
+	"price_desc":  {field: "price.amount", direction: -1},
+	"rating_desc": {field: "rating", direction: -1},
+	"rating_asc":  {field: "rating", direction: 1},
+	"name_asc":    {field: "product_name", direction: 1},
+	"name_desc":   {field: "product_name", direction: -1},
+	// object ids start with their creation time, so the id doubles as the newest sort
+	"newest": {field: "_id", direction: -1},
+}
+
+func IsValidProductSort(sort string) bool {
+
+	_, ok := productSorts[sort]
+	return ok
+
+}
+
+// every sort name IsValidProductSort accepts, in a stable order, eg : the enum of the API documentation
+
+func ProductSortNames() []string {
+
+	names := make([]string, 0, len(productSorts))
+
+	for name := range productSorts {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+
+}
+
+// the full sort document for a sort name, including the _id tie breaker
+
+func ProductSortOrder(sort string) (bson.D, bool) {
+
+	order, ok := productSorts[sort]
+
+	if !ok {
+		return nil, false
+	}
+
+	if order.field == "_id" {
+		return bson.D{primitive.E{Key: "_id", Value: order.direction}}, true
+	}
+
+	return bson.D{primitive.E{Key: order.field, Value: order.direction}, primitive.E{Key: "_id", Value: order.direction}}, true
+
+}
+
+// price bounds are in minor units of the base currency, the same units the product price is stored in
+
+type ProductListQuery struct {
+	Sort       string
+	Cursor     string
+	Limit      int
+	Min_Price  *int64
+	Max_Price  *int64
+	Min_Rating *int
+	Category   string
+	In_Stock   bool
+	Brands     []string
+	// variant option filters, eg : {"size": ["M", "L"], "colour": ["red"]}
+	Attributes map[string][]string
+}
+
+type ProductPage struct {
+	Items       []models.Product `json:"items"`
+	Next_Cursor string           `json:"next_cursor"`
+	Total       int64            `json:"total"`
+	Limit       int              `json:"limit"`
+}
+
+// the cursor is the sort value and id of the last product on the page, encoded as a bson document
+// eg : {"v": 129900, "id": ObjectId("...")} -> base64url
+
+type pageCursor struct {
+	Value interface{}        `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+func encodeCursor(value interface{}, id primitive.ObjectID) string {
+
+	data, err := bson.Marshal(pageCursor{Value: value, ID: id})
+
+	if err != nil {
+		slog.Error("encodeCursor failed", "error", err)
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+
+}
+
+func decodeCursor(cursor string) (pageCursor, error) {
+
+	var decoded pageCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+
+	if err != nil {
+		return pageCursor{}, ErrInvalidCursor
+	}
+
+	if err = bson.Unmarshal(data, &decoded); err != nil {
+		return pageCursor{}, ErrInvalidCursor
+	}
+
+	return decoded, nil
+
+}
+
+// builds the filter shared by the results and the total count, the cursor condition is added separately
+
+func ProductListFilter(context context.Context, categoriesCollection *mongo.Collection, baseFilter bson.D, query ProductListQuery) (bson.D, error) {
+
+	filter := append(bson.D{}, baseFilter...)
+
+	price := bson.D{}
+
+	if query.Min_Price != nil {
+		price = append(price, primitive.E{Key: "$gte", Value: *query.Min_Price})
+	}
+
+	if query.Max_Price != nil {
+		price = append(price, primitive.E{Key: "$lte", Value: *query.Max_Price})
+	}
+
+	if len(price) > 0 {
+		filter = append(filter, primitive.E{Key: "price.amount", Value: price})
+	}
+
+	if query.Min_Rating != nil {
+		filter = append(filter, primitive.E{Key: "rating", Value: bson.D{primitive.E{Key: "$gte", Value: *query.Min_Rating}}})
+	}
+
+	if query.Category != "" {
+
+		_, categoryIDs, err := CategoryWithDescendants(context, categoriesCollection, query.Category)
+
+		if err != nil {
+			return nil, err
+		}
+
+		filter = append(filter, primitive.E{Key: "category_ids", Value: bson.D{primitive.E{Key: "$in", Value: categoryIDs}}})
+
+	}
+
+	if len(query.Brands) > 0 {
+		filter = append(filter, primitive.E{Key: "brand", Value: bson.D{primitive.E{Key: "$in", Value: query.Brands}}})
+	}
+
+	// all attributes have to match on the same variant, a red S and a blue M is not a red M
+	if len(query.Attributes) > 0 {
+
+		variantConditions := bson.D{}
+
+		for name, values := range query.Attributes {
+			if !attributeNamePattern.MatchString(name) {
+				return nil, ErrInvalidAttribute
+			}
+			variantConditions = append(variantConditions, primitive.E{Key: "options." + name, Value: bson.D{primitive.E{Key: "$in", Value: values}}})
+		}
+
+		filter = append(filter, primitive.E{Key: "variants", Value: bson.D{primitive.E{Key: "$elemMatch", Value: variantConditions}}})
+
+	}
+
+	// products without variants are not stock tracked, so they always count as available
+	if query.In_Stock {
+		filter = append(filter, primitive.E{Key: "$or", Value: bson.A{
+			bson.D{primitive.E{Key: "variants.0", Value: bson.D{primitive.E{Key: "$exists", Value: false}}}},
+			bson.D{primitive.E{Key: "variants.stock", Value: bson.D{primitive.E{Key: "$gt", Value: 0}}}},
+		}})
+	}
+
+	return filter, nil
+
+}
+
+func ListProducts(context context.Context, productsCollection *mongo.Collection, categoriesCollection *mongo.Collection, baseFilter bson.D, query ProductListQuery) (ProductPage, error) {
+
+	if query.Sort == "" {
+		query.Sort = "newest"
+	}
+
+	sort, ok := productSorts[query.Sort]
+
+	if !ok {
+		return ProductPage{}, ErrInvalidSort
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = DefaultPageSize
+	}
+
+	if query.Limit > MaxPageSize {
+		query.Limit = MaxPageSize
+	}
+
+	filter, err := ProductListFilter(context, categoriesCollection, baseFilter, query)
+
+	if err != nil {
+		return ProductPage{}, err
+	}
+
+	total, err := productsCollection.CountDocuments(context, filter)
+
+	if err != nil {
+		slog.ErrorContext(context, "ListProducts failed", "error", err)
+		return ProductPage{}, ErrCantFindProduct
+	}
+
+	pageFilter := filter
+
+	if query.Cursor != "" {
+
+		after, err := decodeCursor(query.Cursor)
+
+		if err != nil {
+			return ProductPage{}, err
+		}
+
+		comparison := "$gt"
+
+		if sort.direction < 0 {
+			comparison = "$lt"
+		}
+
+		cursorCondition := bson.D{primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: comparison, Value: after.ID}}}}
+
+		if sort.field != "_id" {
+			cursorCondition = bson.D{primitive.E{Key: "$or", Value: afterSortValue(sort, comparison, after)}}
+		}
+
+		// wrapped in $and so it cannot clash with an $or already used by the filters
+		pageFilter = bson.D{primitive.E{Key: "$and", Value: bson.A{filter, cursorCondition}}}
+
+	}
+
+	sortOrder, _ := ProductSortOrder(query.Sort)
+
+	// one extra product tells whether there is a next page
+	findOptions := options.Find().SetSort(sortOrder).SetLimit(int64(query.Limit + 1))
+	cursor, err := productsCollection.Find(context, pageFilter, findOptions)
+
+	if err != nil {
+		slog.ErrorContext(context, "ListProducts failed", "error", err)
+		return ProductPage{}, ErrCantFindProduct
+	}
+
+	defer cursor.Close(context)
+
+	items := make([]models.Product, 0, query.Limit+1)
+	rawItems := make([]bson.Raw, 0, query.Limit+1)
+
+	for cursor.Next(context) {
+
+		var product models.Product
+
+		if err = cursor.Decode(&product); err != nil {
+			slog.ErrorContext(context, "ListProducts failed", "error", err)
+			return ProductPage{}, ErrCantDecodeProducts
+		}
+
+		items = append(items, product)
+		rawItems = append(rawItems, append(bson.Raw{}, cursor.Current...))
+
+	}
+
+	if err = cursor.Err(); err != nil {
+		slog.ErrorContext(context, "ListProducts failed", "error", err)
+		return ProductPage{}, ErrCantDecodeProducts
+	}
+
+	page := ProductPage{Items: items, Total: total, Limit: query.Limit}
+
+	if len(items) > query.Limit {
+
+		page.Items = items[:query.Limit]
+		last := rawItems[query.Limit-1]
+
+		// the raw sort value keeps its stored bson type, so the next page compares like with like
+		var lastValue interface{}
+
+		if value, err := last.LookupErr(strings.Split(sort.field, ".")...); err == nil {
+			lastValue = value
+		}
+
+		page.Next_Cursor = encodeCursor(lastValue, page.Items[query.Limit-1].Product_ID)
+
+	}
+
+	return page, nil
+
+}
+
+// the products that sort after the cursor, products without the field, eg : no rating yet, sort before every value
+// and $gt or $lt never match them, so they get branches of their own
+
+func afterSortValue(sort productSort, comparison string, after pageCursor) bson.A {
+
+	sameValueAfterID := bson.D{primitive.E{Key: sort.field, Value: after.Value}, primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: comparison, Value: after.ID}}}}
+	withoutValue := bson.D{primitive.E{Key: sort.field, Value: nil}}
+
+	switch {
+	case after.Value == nil && sort.direction > 0:
+		// the rest of the products without the field, then every product with one
+		return bson.A{sameValueAfterID, bson.D{primitive.E{Key: sort.field, Value: bson.D{primitive.E{Key: "$ne", Value: nil}}}}}
+	case after.Value == nil:
+		return bson.A{sameValueAfterID}
+	case sort.direction > 0:
+		return bson.A{bson.D{primitive.E{Key: sort.field, Value: bson.D{primitive.E{Key: comparison, Value: after.Value}}}}, sameValueAfterID}
+	default:
+		// descending, the products without the field come last
+		return bson.A{bson.D{primitive.E{Key: sort.field, Value: bson.D{primitive.E{Key: comparison, Value: after.Value}}}}, sameValueAfterID, withoutValue}
+	}
+
+}
@@ -0,0 +1,293 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrVariantRequired  = errors.New("product has variants, a variant must be selected")
+	ErrCantFindVariant  = errors.New("unable to find the specified variant")
+	ErrOutOfStock       = errors.New("selected variant is out of stock")
+	ErrInvalidVariants  = errors.New("variants do not match the product options")
+	ErrSKUTaken         = errors.New("SKU is already used by another variant")
+	ErrCantReserveStock = errors.New("unable to reserve stock for the order")
+)
+
+// shared with the importer, so SKUs are held to the same rule however they reach the catalog
+var SKUPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func EnsureProductIndexes(context context.Context, productsCollection *mongo.Collection) error {
+
+	// partial so that products without variants do not collide on a missing sku
+	_, err := productsCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		{
+			Keys:    bson.D{primitive.E{Key: "variants.sku", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.D{primitive.E{Key: "variants.sku", Value: bson.D{primitive.E{Key: "$exists", Value: true}}}}),
+		},
+		{
+			Keys:    bson.D{primitive.E{Key: "sku", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.D{primitive.E{Key: "sku", Value: bson.D{primitive.E{Key: "$type", Value: "string"}}}}),
+		},
+		{Keys: bson.D{primitive.E{Key: "category_ids", Value: 1}}},
+	})
+
+	if err != nil {
+		slog.ErrorContext(context, "EnsureProductIndexes failed", "error", err)
+	}
+
+	return err
+
+}
+
+func FindProduct(context context.Context, productsCollection *mongo.Collection, productID primitive.ObjectID) (models.Product, error) {
+
+	var product models.Product
+
+	if err := productsCollection.FindOne(context, bson.D{primitive.E{Key: "_id", Value: productID}}).Decode(&product); err != nil {
+		slog.ErrorContext(context, "FindProduct failed", "error", err)
+		return models.Product{}, ErrCantFindProduct
+	}
+
+	return product, nil
+
+}
+
+// eg : options [size: S M] [colour: red] -> every variant sets exactly "size" and "colour" to one of those values,
+// and no two variants share the same combination
+
+func ValidateVariants(productOptions []models.ProductOption, variants []models.Variant) error {
+
+	allowed := make(map[string]map[string]bool, len(productOptions))
+
+	for _, option := range productOptions {
+
+		if _, duplicate := allowed[option.Name]; duplicate {
+			return ErrInvalidVariants
+		}
+
+		allowed[option.Name] = make(map[string]bool, len(option.Values))
+
+		for _, value := range option.Values {
+			allowed[option.Name][value] = true
+		}
+
+	}
+
+	skus := make(map[string]bool, len(variants))
+	combinations := make(map[string]bool, len(variants))
+
+	for _, variant := range variants {
+
+		if !SKUPattern.MatchString(variant.SKU) || skus[variant.SKU] {
+			return ErrInvalidVariants
+		}
+
+		skus[variant.SKU] = true
+
+		if len(variant.Options) != len(allowed) {
+			return ErrInvalidVariants
+		}
+
+		keys := make([]string, 0, len(variant.Options))
+
+		for name, value := range variant.Options {
+			if !allowed[name][value] {
+				return ErrInvalidVariants
+			}
+			keys = append(keys, name+"="+value)
+		}
+
+		sort.Strings(keys)
+		combination := strings.Join(keys, "&")
+
+		if combinations[combination] {
+			return ErrInvalidVariants
+		}
+
+		combinations[combination] = true
+
+		if variant.Price.Currency != "" && !models.IsSupportedCurrency(variant.Price.Currency) {
+			return ErrInvalidVariants
+		}
+
+	}
+
+	return nil
+
+}
+
+// replaces the option definitions and the full variant list, variants keep their id when the SKU is unchanged
+// so carts holding them stay valid
+
+func SetProductVariants(context context.Context, productsCollection *mongo.Collection, productID primitive.ObjectID, productOptions []models.ProductOption, variants []models.Variant) (models.Product, error) {
+
+	product, err := FindProduct(context, productsCollection, productID)
+
+	if err != nil {
+		return models.Product{}, err
+	}
+
+	if err = ValidateVariants(productOptions, variants); err != nil {
+		return models.Product{}, err
+	}
+
+	existing := make(map[string]primitive.ObjectID, len(product.Variants))
+
+	for _, variant := range product.Variants {
+		existing[variant.SKU] = variant.Variant_ID
+	}
+
+	for index := range variants {
+
+		if variantID, ok := existing[variants[index].SKU]; ok {
+			variants[index].Variant_ID = variantID
+		} else {
+			variants[index].Variant_ID = primitive.NewObjectID()
+		}
+
+		if variants[index].Images == nil {
+			variants[index].Images = make([]string, 0)
+		}
+
+	}
+
+	product.Options = productOptions
+	product.Variants = variants
+
+	filter := bson.D{primitive.E{Key: "_id", Value: productID}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "options", Value: product.Options},
+		primitive.E{Key: "variants", Value: product.Variants},
+	}}}
+
+	if _, err = productsCollection.UpdateOne(context, filter, update); err != nil {
+		slog.ErrorContext(context, "SetProductVariants failed", "error", err)
+		if mongo.IsDuplicateKeyError(err) {
+			return models.Product{}, ErrSKUTaken
+		}
+		return models.Product{}, ErrCantUpdateProductInfo
+	}
+
+	return product, nil
+
+}
+
+// builds the cart line for a product, picking the variant's SKU, options and price when the product has variants
+
+func CartLineForProduct(product models.Product, variantID *primitive.ObjectID) (models.ProductUser, error) {
+
+	line := models.ProductUser{
+		Product_ID:   product.Product_ID,
+		Product_Name: product.Product_Name,
+		Price:        product.Price,
+		Prices:       product.Prices,
+		Image:        product.Image,
+		Rating:       product.Rating,
+	}
+
+	if len(product.Variants) == 0 {
+		return line, nil
+	}
+
+	if variantID == nil {
+		return models.ProductUser{}, ErrVariantRequired
+	}
+
+	variant, ok := product.FindVariant(*variantID)
+
+	if !ok {
+		return models.ProductUser{}, ErrCantFindVariant
+	}
+
+	if variant.Stock <= 0 {
+		return models.ProductUser{}, ErrOutOfStock
+	}
+
+	line.Variant_ID = &variant.Variant_ID
+	line.SKU = variant.SKU
+	line.Options = variant.Options
+
+	if variant.Price.Currency != "" {
+		line.Price = variant.Price
+		line.Prices = variant.Prices
+	}
+
+	if len(variant.Images) > 0 {
+		image := variant.Images[0]
+		line.Image = &image
+	}
+
+	return line, nil
+
+}
+
+// decrements variant stock for every line of an order, lines without a variant are not stock tracked
+// if one line cannot be reserved the ones already taken are given back
+
+func ReserveStock(context context.Context, productsCollection *mongo.Collection, lines []models.ProductUser) error {
+
+	reserved := make([]models.ProductUser, 0, len(lines))
+
+	for _, line := range lines {
+
+		if line.Variant_ID == nil {
+			continue
+		}
+
+		filter := bson.D{
+			primitive.E{Key: "_id", Value: line.Product_ID},
+			primitive.E{Key: "variants", Value: bson.D{primitive.E{Key: "$elemMatch", Value: bson.D{
+				primitive.E{Key: "_id", Value: *line.Variant_ID},
+				primitive.E{Key: "stock", Value: bson.D{primitive.E{Key: "$gte", Value: 1}}},
+			}}}},
+		}
+		update := bson.D{{Key: "$inc", Value: bson.D{primitive.E{Key: "variants.$.stock", Value: -1}}}}
+
+		result, err := productsCollection.UpdateOne(context, filter, update)
+
+		if err != nil || result.ModifiedCount == 0 {
+			slog.ErrorContext(context, "ReserveStock failed", "error", err)
+			ReleaseStock(context, productsCollection, reserved)
+			if err != nil {
+				return ErrCantReserveStock
+			}
+			return ErrOutOfStock
+		}
+
+		reserved = append(reserved, line)
+
+	}
+
+	return nil
+
+}
+
+func ReleaseStock(context context.Context, productsCollection *mongo.Collection, lines []models.ProductUser) {
+
+	for _, line := range lines {
+
+		if line.Variant_ID == nil {
+			continue
+		}
+
+		filter := bson.D{primitive.E{Key: "_id", Value: line.Product_ID}, primitive.E{Key: "variants._id", Value: *line.Variant_ID}}
+		update := bson.D{{Key: "$inc", Value: bson.D{primitive.E{Key: "variants.$.stock", Value: 1}}}}
+
+		if _, err := productsCollection.UpdateOne(context, filter, update); err != nil {
+			slog.ErrorContext(context, "ReleaseStock failed", "error", err)
+		}
+
+	}
+
+}
@@ -0,0 +1,350 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindReview      = errors.New("unable to find the specified review")
+	ErrReviewNotAllowed    = errors.New("only customers with a delivered order of this product can review it")
+	ErrAlreadyReviewed     = errors.New("product has already been reviewed by this user")
+	ErrNotReviewAuthor     = errors.New("review belongs to another user")
+	ErrCantVoteReview      = errors.New("review was already voted on or is your own")
+	ErrInvalidReviewStatus = errors.New("review status is not valid")
+	ErrCantUpdateReview    = errors.New("unable to update review")
+)
+
+func EnsureReviewIndexes(context context.Context, reviewsCollection *mongo.Collection) error {
+
+	_, err := reviewsCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		{Keys: bson.D{primitive.E{Key: "product_id", Value: 1}, primitive.E{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{primitive.E{Key: "product_id", Value: 1}, primitive.E{Key: "status", Value: 1}, primitive.E{Key: "created_at", Value: -1}}},
+	})
+
+	if err != nil {
+		slog.ErrorContext(context, "EnsureReviewIndexes failed", "error", err)
+	}
+
+	return err
+
+}
+
+// a delivered shipment is the proof of purchase, the order alone may still be on its way
+
+func HasReceivedProduct(context context.Context, shipmentsCollection *mongo.Collection, userID string, productID primitive.ObjectID) (bool, error) {
+
+	filter := bson.D{
+		primitive.E{Key: "user_id", Value: userID},
+		primitive.E{Key: "status", Value: models.ShipmentDelivered},
+		primitive.E{Key: "items.product_id", Value: productID},
+	}
+
+	count, err := shipmentsCollection.CountDocuments(context, filter, options.Count().SetLimit(1))
+
+	if err != nil {
+		slog.ErrorContext(context, "HasReceivedProduct failed", "error", err)
+		return false, ErrCantFindShipment
+	}
+
+	return count > 0, nil
+
+}
+
+// applies a change of the published stars to the product, the average is recomputed from the running totals
+// in the same update so concurrent reviews never overwrite each other
+
+func adjustProductRating(context context.Context, productsCollection *mongo.Collection, productID primitive.ObjectID, starsDelta int, countDelta int) error {
+
+	if starsDelta == 0 && countDelta == 0 {
+		return nil
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: productID}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			primitive.E{Key: "rating_total", Value: bson.D{primitive.E{Key: "$add", Value: bson.A{bson.D{primitive.E{Key: "$ifNull", Value: bson.A{"$rating_total", 0}}}, starsDelta}}}},
+			primitive.E{Key: "review_count", Value: bson.D{primitive.E{Key: "$add", Value: bson.A{bson.D{primitive.E{Key: "$ifNull", Value: bson.A{"$review_count", 0}}}, countDelta}}}},
+		}}},
+		{{Key: "$set", Value: bson.D{
+			primitive.E{Key: "rating", Value: bson.D{primitive.E{Key: "$cond", Value: bson.A{
+				bson.D{primitive.E{Key: "$gt", Value: bson.A{"$review_count", 0}}},
+				bson.D{primitive.E{Key: "$round", Value: bson.A{bson.D{primitive.E{Key: "$divide", Value: bson.A{"$rating_total", "$review_count"}}}, 1}}},
+				nil,
+			}}}},
+		}}},
+	}
+
+	if _, err := productsCollection.UpdateOne(context, filter, update); err != nil {
+		slog.ErrorContext(context, "adjustProductRating failed", "error", err)
+		return ErrCantUpdateProductInfo
+	}
+
+	return nil
+
+}
+
+func FindReview(context context.Context, reviewsCollection *mongo.Collection, reviewID primitive.ObjectID) (models.Review, error) {
+
+	var review models.Review
+
+	if err := reviewsCollection.FindOne(context, bson.D{primitive.E{Key: "_id", Value: reviewID}}).Decode(&review); err != nil {
+		slog.ErrorContext(context, "FindReview failed", "error", err)
+		return models.Review{}, ErrCantFindReview
+	}
+
+	return review, nil
+
+}
+
+func CreateReview(context context.Context, productsCollection *mongo.Collection, reviewsCollection *mongo.Collection, shipmentsCollection *mongo.Collection, review models.Review) (models.Review, error) {
+
+	if _, err := FindProduct(context, productsCollection, review.Product_ID); err != nil {
+		return models.Review{}, err
+	}
+
+	received, err := HasReceivedProduct(context, shipmentsCollection, review.User_ID, review.Product_ID)
+
+	if err != nil {
+		return models.Review{}, err
+	}
+
+	if !received {
+		return models.Review{}, ErrReviewNotAllowed
+	}
+
+	review.Review_ID = primitive.NewObjectID()
+	review.Status = models.ReviewPublished
+	review.Helpful_Votes = 0
+	review.Voter_IDs = make([]string, 0)
+	review.Created_At = time.Now()
+	review.Updated_At = review.Created_At
+
+	if _, err = reviewsCollection.InsertOne(context, review); err != nil {
+		slog.ErrorContext(context, "CreateReview failed", "error", err)
+		if mongo.IsDuplicateKeyError(err) {
+			return models.Review{}, ErrAlreadyReviewed
+		}
+		return models.Review{}, ErrCantUpdateReview
+	}
+
+	if err = adjustProductRating(context, productsCollection, review.Product_ID, review.Stars, 1); err != nil {
+		return models.Review{}, err
+	}
+
+	return review, nil
+
+}
+
+// a review missed by a write filtered on its author either does not exist or belongs to someone else
+
+func reviewOwnerError(context context.Context, reviewsCollection *mongo.Collection, reviewID primitive.ObjectID) error {
+
+	if _, err := FindReview(context, reviewsCollection, reviewID); err != nil {
+		return err
+	}
+
+	return ErrNotReviewAuthor
+
+}
+
+// the stars and status the rating is adjusted from are the ones this write replaced, read in the same operation,
+// so a moderation running at the same time can never be counted twice
+
+func UpdateReview(context context.Context, productsCollection *mongo.Collection, reviewsCollection *mongo.Collection, reviewID primitive.ObjectID, userID string, changes models.Review) (models.Review, error) {
+
+	now := time.Now()
+
+	filter := bson.D{primitive.E{Key: "_id", Value: reviewID}, primitive.E{Key: "user_id", Value: userID}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "stars", Value: changes.Stars},
+		primitive.E{Key: "title", Value: changes.Title},
+		primitive.E{Key: "body", Value: changes.Body},
+		primitive.E{Key: "updated_at", Value: now},
+	}}}
+
+	var previous models.Review
+
+	err := reviewsCollection.FindOneAndUpdate(context, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&previous)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.Review{}, reviewOwnerError(context, reviewsCollection, reviewID)
+	}
+
+	if err != nil {
+		slog.ErrorContext(context, "UpdateReview failed", "error", err)
+		return models.Review{}, ErrCantUpdateReview
+	}
+
+	if previous.Status == models.ReviewPublished {
+		if err = adjustProductRating(context, productsCollection, previous.Product_ID, changes.Stars-previous.Stars, 0); err != nil {
+			return models.Review{}, err
+		}
+	}
+
+	review := previous
+	review.Stars = changes.Stars
+	review.Title = changes.Title
+	review.Body = changes.Body
+	review.Updated_At = now
+
+	return review, nil
+
+}
+
+func DeleteReview(context context.Context, productsCollection *mongo.Collection, reviewsCollection *mongo.Collection, reviewID primitive.ObjectID, userID string) error {
+
+	var review models.Review
+
+	filter := bson.D{primitive.E{Key: "_id", Value: reviewID}, primitive.E{Key: "user_id", Value: userID}}
+	err := reviewsCollection.FindOneAndDelete(context, filter).Decode(&review)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return reviewOwnerError(context, reviewsCollection, reviewID)
+	}
+
+	if err != nil {
+		slog.ErrorContext(context, "DeleteReview failed", "error", err)
+		return ErrCantUpdateReview
+	}
+
+	// the deleted document tells whether it still counted towards the rating
+	if review.Status == models.ReviewPublished {
+		return adjustProductRating(context, productsCollection, review.Product_ID, -review.Stars, -1)
+	}
+
+	return nil
+
+}
+
+// a user can vote once per review and never on their own
+
+func VoteReviewHelpful(context context.Context, reviewsCollection *mongo.Collection, reviewID primitive.ObjectID, userID string) (models.Review, error) {
+
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: reviewID},
+		primitive.E{Key: "status", Value: models.ReviewPublished},
+		primitive.E{Key: "user_id", Value: bson.D{primitive.E{Key: "$ne", Value: userID}}},
+		primitive.E{Key: "voter_ids", Value: bson.D{primitive.E{Key: "$ne", Value: userID}}},
+	}
+	update := bson.D{
+		{Key: "$push", Value: bson.D{primitive.E{Key: "voter_ids", Value: userID}}},
+		{Key: "$inc", Value: bson.D{primitive.E{Key: "helpful_votes", Value: 1}}},
+	}
+
+	var review models.Review
+
+	err := reviewsCollection.FindOneAndUpdate(context, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&review)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, findErr := FindReview(context, reviewsCollection, reviewID); findErr != nil {
+			return models.Review{}, findErr
+		}
+		return models.Review{}, ErrCantVoteReview
+	}
+
+	if err != nil {
+		slog.ErrorContext(context, "VoteReviewHelpful failed", "error", err)
+		return models.Review{}, ErrCantUpdateReview
+	}
+
+	return review, nil
+
+}
+
+// rejected reviews stay stored for the author but stop counting towards the product rating
+
+func ModerateReview(context context.Context, productsCollection *mongo.Collection, reviewsCollection *mongo.Collection, reviewID primitive.ObjectID, status string, note string) (models.Review, error) {
+
+	if status != models.ReviewPublished && status != models.ReviewRejected {
+		return models.Review{}, ErrInvalidReviewStatus
+	}
+
+	now := time.Now()
+
+	filter := bson.D{primitive.E{Key: "_id", Value: reviewID}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "status", Value: status},
+		primitive.E{Key: "moderation_note", Value: note},
+		primitive.E{Key: "updated_at", Value: now},
+	}}}
+
+	// like UpdateReview, the rating follows the status and stars this write replaced
+	var previous models.Review
+
+	err := reviewsCollection.FindOneAndUpdate(context, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&previous)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.Review{}, ErrCantFindReview
+	}
+
+	if err != nil {
+		slog.ErrorContext(context, "ModerateReview failed", "error", err)
+		return models.Review{}, ErrCantUpdateReview
+	}
+
+	switch {
+	case previous.Status == models.ReviewPublished && status == models.ReviewRejected:
+		err = adjustProductRating(context, productsCollection, previous.Product_ID, -previous.Stars, -1)
+	case previous.Status == models.ReviewRejected && status == models.ReviewPublished:
+		err = adjustProductRating(context, productsCollection, previous.Product_ID, previous.Stars, 1)
+	}
+
+	if err != nil {
+		return models.Review{}, err
+	}
+
+	review := previous
+	review.Status = status
+	review.Moderation_Note = note
+	review.Updated_At = now
+
+	return review, nil
+
+}
+
+// "helpful" orders by votes, anything else returns the newest reviews first
+
+func ListProductReviews(context context.Context, reviewsCollection *mongo.Collection, productID primitive.ObjectID, sortBy string, offset int, limit int) ([]models.Review, int64, error) {
+
+	filter := bson.D{primitive.E{Key: "product_id", Value: productID}, primitive.E{Key: "status", Value: models.ReviewPublished}}
+
+	total, err := reviewsCollection.CountDocuments(context, filter)
+
+	if err != nil {
+		slog.ErrorContext(context, "ListProductReviews failed", "error", err)
+		return nil, 0, ErrCantFindReview
+	}
+
+	sortOrder := bson.D{primitive.E{Key: "created_at", Value: -1}, primitive.E{Key: "_id", Value: -1}}
+
+	if sortBy == "helpful" {
+		sortOrder = bson.D{primitive.E{Key: "helpful_votes", Value: -1}, primitive.E{Key: "_id", Value: -1}}
+	}
+
+	findOptions := options.Find().SetSort(sortOrder).SetSkip(int64(offset)).SetLimit(int64(limit))
+	cursor, err := reviewsCollection.Find(context, filter, findOptions)
+
+	if err != nil {
+		slog.ErrorContext(context, "ListProductReviews failed", "error", err)
+		return nil, 0, ErrCantFindReview
+	}
+
+	reviews := make([]models.Review, 0, limit)
+
+	if err = cursor.All(context, &reviews); err != nil {
+		slog.ErrorContext(context, "ListProductReviews failed", "error", err)
+		return nil, 0, ErrCantFindReview
+	}
+
+	return reviews, total, nil
+
+}
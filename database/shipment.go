@@ -0,0 +1,510 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/carriers"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindOrder           = errors.New("unable to find the specified order")
+	ErrCantFindShipment        = errors.New("unable to find the specified shipment")
+	ErrInvalidShipmentItems    = errors.New("shipment items do not match the unshipped items of the order")
+	ErrShipmentAlreadyShipped  = errors.New("shipment has already been handed to the carrier")
+	ErrCantCreateShipment      = errors.New("unable to create shipment")
+	ErrCantUpdateShipment      = errors.New("unable to update shipment")
+	ErrCantBookCarrierShipment = errors.New("unable to book the shipment with the carrier")
+	ErrInvalidTrackingStatus   = errors.New("tracking event has an unknown shipment status")
+)
+
+var shipmentStatuses = map[string]bool{
+	models.ShipmentPacked:         true,
+	models.ShipmentShipped:        true,
+	models.ShipmentInTransit:      true,
+	models.ShipmentOutForDelivery: true,
+	models.ShipmentDelivered:      true,
+	models.ShipmentException:      true,
+}
+
+// orders are embedded in the user document, so the order is found through the owning user
+// only the matching element of the orders array is projected
+
+func FindOrder(context context.Context, usersCollection *mongo.Collection, orderID primitive.ObjectID) (models.Order, string, error) {
+
+	var userModel models.User
+
+	filter := bson.D{primitive.E{Key: "orders._id", Value: orderID}}
+	projection := bson.D{primitive.E{Key: "user_id", Value: 1}, primitive.E{Key: "orders.$", Value: 1}}
+
+	err := usersCollection.FindOne(context, filter, options.FindOne().SetProjection(projection)).Decode(&userModel)
+
+	if err != nil || len(userModel.Order_Status) == 0 {
+		slog.ErrorContext(context, "FindOrder failed", "error", err)
+		return models.Order{}, "", ErrCantFindOrder
+	}
+
+	return userModel.Order_Status[0], userModel.User_ID, nil
+
+}
+
+func GetOrderShipments(context context.Context, shipmentsCollection *mongo.Collection, orderID primitive.ObjectID) ([]models.Shipment, error) {
+
+	filter := bson.D{primitive.E{Key: "order_id", Value: orderID}}
+	cursor, err := shipmentsCollection.Find(context, filter, options.Find().SetSort(bson.D{primitive.E{Key: "created_at", Value: 1}}))
+
+	if err != nil {
+		slog.ErrorContext(context, "GetOrderShipments failed", "error", err)
+		return nil, ErrCantFindShipment
+	}
+
+	defer cursor.Close(context)
+
+	shipments := make([]models.Shipment, 0)
+
+	if err = cursor.All(context, &shipments); err != nil {
+		slog.ErrorContext(context, "GetOrderShipments failed", "error", err)
+		return nil, ErrCantFindShipment
+	}
+
+	return shipments, nil
+
+}
+
+// every entry of order_list is a single unit, so the ordered quantity is the number of entries per product
+
+func unshippedQuantities(order models.Order, shipments []models.Shipment) map[primitive.ObjectID]int {
+
+	remaining := make(map[primitive.ObjectID]int)
+
+	for _, item := range order.Order_Cart {
+		remaining[item.Product_ID]++
+	}
+
+	for _, shipment := range shipments {
+		for _, item := range shipment.Items {
+			remaining[item.Product_ID] -= item.Quantity
+		}
+	}
+
+	return remaining
+
+}
+
+// orders placed before the packed counters existed get them from their shipments, once, no shipment can be
+// created for the order until then, so the shipments cannot change while they are counted
+
+func ensurePackedQuantities(context context.Context, usersCollection *mongo.Collection, shipmentsCollection *mongo.Collection, order models.Order) (models.Order, error) {
+
+	if order.Packed_Quantities != nil {
+		return order, nil
+	}
+
+	shipments, err := GetOrderShipments(context, shipmentsCollection, order.Order_ID)
+
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	packed := make(map[string]int)
+
+	for _, shipment := range shipments {
+		for _, item := range shipment.Items {
+			packed[item.Product_ID.Hex()] += item.Quantity
+		}
+	}
+
+	// a concurrent request may have counted them first, both counted the same shipments
+	filter := bson.D{primitive.E{Key: "orders", Value: bson.D{primitive.E{Key: "$elemMatch", Value: bson.D{
+		primitive.E{Key: "_id", Value: order.Order_ID},
+		primitive.E{Key: "packed_quantities", Value: nil},
+	}}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "orders.$.packed_quantities", Value: packed}}}}
+
+	if _, err = usersCollection.UpdateOne(context, filter, update); err != nil {
+		slog.ErrorContext(context, "ensurePackedQuantities failed", "error", err)
+		return models.Order{}, ErrCantUpdateUser
+	}
+
+	order.Packed_Quantities = packed
+	return order, nil
+
+}
+
+// an order can be split into several shipments, each one may only contain items not already packed in another
+// the items are claimed on the order's packed counters before the shipment is stored, so two shipments created
+// at the same time cannot both pack the same units
+
+func CreateShipment(context context.Context, usersCollection *mongo.Collection, shipmentsCollection *mongo.Collection, orderID primitive.ObjectID, carrierName string, items []models.ShipmentItem) (models.Shipment, error) {
+
+	if _, err := carriers.Get(carrierName); err != nil {
+		return models.Shipment{}, err
+	}
+
+	order, userID, err := FindOrder(context, usersCollection, orderID)
+
+	if err != nil {
+		return models.Shipment{}, err
+	}
+
+	if order, err = ensurePackedQuantities(context, usersCollection, shipmentsCollection, order); err != nil {
+		return models.Shipment{}, err
+	}
+
+	if len(items) == 0 {
+		return models.Shipment{}, ErrInvalidShipmentItems
+	}
+
+	ordered := make(map[string]int)
+
+	for _, item := range order.Order_Cart {
+		ordered[item.Product_ID.Hex()]++
+	}
+
+	requested := make(map[string]int)
+
+	for _, item := range items {
+
+		key := item.Product_ID.Hex()
+
+		if item.Quantity <= 0 {
+			return models.Shipment{}, ErrInvalidShipmentItems
+		}
+
+		requested[key] += item.Quantity
+
+		if order.Packed_Quantities[key]+requested[key] > ordered[key] {
+			return models.Shipment{}, ErrInvalidShipmentItems
+		}
+
+	}
+
+	// every product must still have the units left when the counters are raised, a missing counter is 0
+	match := bson.D{primitive.E{Key: "_id", Value: orderID}, primitive.E{Key: "packed_quantities", Value: bson.D{primitive.E{Key: "$ne", Value: nil}}}}
+	claim := bson.D{}
+	release := bson.D{}
+
+	for key, quantity := range requested {
+		match = append(match, primitive.E{Key: "packed_quantities." + key, Value: bson.D{primitive.E{Key: "$not", Value: bson.D{primitive.E{Key: "$gt", Value: ordered[key] - quantity}}}}})
+		claim = append(claim, primitive.E{Key: "orders.$.packed_quantities." + key, Value: quantity})
+		release = append(release, primitive.E{Key: "orders.$.packed_quantities." + key, Value: -quantity})
+	}
+
+	filter := bson.D{primitive.E{Key: "orders", Value: bson.D{primitive.E{Key: "$elemMatch", Value: match}}}}
+
+	result, err := usersCollection.UpdateOne(context, filter, bson.D{{Key: "$inc", Value: claim}})
+
+	if err != nil {
+		slog.ErrorContext(context, "CreateShipment failed", "error", err)
+		return models.Shipment{}, ErrCantCreateShipment
+	}
+
+	// another shipment packed the units in the meantime
+	if result.MatchedCount == 0 {
+		return models.Shipment{}, ErrInvalidShipmentItems
+	}
+
+	now := time.Now()
+
+	shipment := models.Shipment{
+		Shipment_ID: primitive.NewObjectID(),
+		Order_ID:    orderID,
+		User_ID:     userID,
+		Carrier:     carrierName,
+		Items:       items,
+		Status:      models.ShipmentPacked,
+		Events:      []models.TrackingEvent{{Status: models.ShipmentPacked, Description: "Shipment packed", Occurred_At: now}},
+		Created_At:  now,
+		Updated_At:  now,
+	}
+
+	if _, err = shipmentsCollection.InsertOne(context, shipment); err != nil {
+
+		slog.ErrorContext(context, "CreateShipment failed", "error", err)
+
+		releaseFilter := bson.D{primitive.E{Key: "orders._id", Value: orderID}}
+
+		if _, releaseErr := usersCollection.UpdateOne(context, releaseFilter, bson.D{{Key: "$inc", Value: release}}); releaseErr != nil {
+			slog.ErrorContext(context, "CreateShipment could not release the packed items", "error", releaseErr, "order_id", orderID.Hex())
+		}
+
+		return models.Shipment{}, ErrCantCreateShipment
+
+	}
+
+	return shipment, nil
+
+}
+
+// hands a packed shipment to its carrier, a tracking number given by hand skips booking with the carrier
+
+func ShipShipment(context context.Context, usersCollection *mongo.Collection, shipmentsCollection *mongo.Collection, shipmentID primitive.ObjectID, trackingNumber string) (models.Shipment, error) {
+
+	var shipment models.Shipment
+
+	filter := bson.D{primitive.E{Key: "_id", Value: shipmentID}}
+
+	if err := shipmentsCollection.FindOne(context, filter).Decode(&shipment); err != nil {
+		slog.ErrorContext(context, "ShipShipment failed", "error", err)
+		return models.Shipment{}, ErrCantFindShipment
+	}
+
+	if shipment.Status != models.ShipmentPacked {
+		return models.Shipment{}, ErrShipmentAlreadyShipped
+	}
+
+	if trackingNumber == "" {
+
+		carrier, err := carriers.Get(shipment.Carrier)
+
+		if err != nil {
+			return models.Shipment{}, err
+		}
+
+		trackingNumber, err = carrier.CreateShipment(context, shipment)
+
+		if err != nil {
+			slog.ErrorContext(context, "ShipShipment failed", "error", err)
+			return models.Shipment{}, ErrCantBookCarrierShipment
+		}
+
+	}
+
+	now := time.Now()
+	shipment.Tracking_Number = trackingNumber
+	shipment.Status = models.ShipmentShipped
+	shipment.Shipped_At = &now
+	shipment.Updated_At = now
+	shipment.Events = append(shipment.Events, models.TrackingEvent{Status: models.ShipmentShipped, Description: "Handed to " + shipment.Carrier, Occurred_At: now})
+
+	// the status is part of the filter so two concurrent ship requests cannot both succeed
+	filter = bson.D{primitive.E{Key: "_id", Value: shipmentID}, primitive.E{Key: "status", Value: models.ShipmentPacked}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "tracking_number", Value: shipment.Tracking_Number},
+		primitive.E{Key: "status", Value: shipment.Status},
+		primitive.E{Key: "shipped_at", Value: shipment.Shipped_At},
+		primitive.E{Key: "updated_at", Value: shipment.Updated_At},
+		primitive.E{Key: "events", Value: shipment.Events},
+	}}}
+
+	result, err := shipmentsCollection.UpdateOne(context, filter, update)
+
+	if err != nil {
+		slog.ErrorContext(context, "ShipShipment failed", "error", err)
+		return models.Shipment{}, ErrCantUpdateShipment
+	}
+
+	if result.MatchedCount == 0 {
+		return models.Shipment{}, ErrShipmentAlreadyShipped
+	}
+
+	if err = updateOrderFulfillment(context, usersCollection, shipmentsCollection, shipment.Order_ID); err != nil {
+		return models.Shipment{}, err
+	}
+
+	return shipment, nil
+
+}
+
+// merges carrier events into the shipment, events already recorded are skipped so webhooks and polling can overlap
+
+func ApplyTrackingEvents(context context.Context, usersCollection *mongo.Collection, shipmentsCollection *mongo.Collection, carrierName string, trackingNumber string, events []models.TrackingEvent) (models.Shipment, error) {
+
+	// the latest event becomes the shipment's status, so only statuses we know how to handle are stored
+	for _, event := range events {
+		if !shipmentStatuses[event.Status] {
+			return models.Shipment{}, ErrInvalidTrackingStatus
+		}
+	}
+
+	var shipment models.Shipment
+
+	filter := bson.D{primitive.E{Key: "carrier", Value: carrierName}, primitive.E{Key: "tracking_number", Value: trackingNumber}}
+
+	if err := shipmentsCollection.FindOne(context, filter).Decode(&shipment); err != nil {
+		slog.ErrorContext(context, "ApplyTrackingEvents failed", "error", err)
+		return models.Shipment{}, ErrCantFindShipment
+	}
+
+	type eventKey struct {
+		status     string
+		occurredAt int64
+	}
+
+	known := make(map[eventKey]bool)
+
+	for _, event := range shipment.Events {
+		known[eventKey{event.Status, event.Occurred_At.Unix()}] = true
+	}
+
+	added := false
+
+	for _, event := range events {
+
+		key := eventKey{event.Status, event.Occurred_At.Unix()}
+
+		if known[key] {
+			continue
+		}
+
+		known[key] = true
+		shipment.Events = append(shipment.Events, event)
+		added = true
+
+	}
+
+	if !added {
+		return shipment, nil
+	}
+
+	sort.SliceStable(shipment.Events, func(i, j int) bool {
+		return shipment.Events[i].Occurred_At.Before(shipment.Events[j].Occurred_At)
+	})
+
+	latest := shipment.Events[len(shipment.Events)-1]
+	shipment.Status = latest.Status
+	shipment.Updated_At = time.Now()
+
+	if latest.Status == models.ShipmentDelivered && shipment.Delivered_At == nil {
+		deliveredAt := latest.Occurred_At
+		shipment.Delivered_At = &deliveredAt
+	}
+
+	filter = bson.D{primitive.E{Key: "_id", Value: shipment.Shipment_ID}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "status", Value: shipment.Status},
+		primitive.E{Key: "events", Value: shipment.Events},
+		primitive.E{Key: "updated_at", Value: shipment.Updated_At},
+		primitive.E{Key: "delivered_at", Value: shipment.Delivered_At},
+	}}}
+
+	if _, err := shipmentsCollection.UpdateOne(context, filter, update); err != nil {
+		slog.ErrorContext(context, "ApplyTrackingEvents failed", "error", err)
+		return models.Shipment{}, ErrCantUpdateShipment
+	}
+
+	if err := updateOrderFulfillment(context, usersCollection, shipmentsCollection, shipment.Order_ID); err != nil {
+		return models.Shipment{}, err
+	}
+
+	return shipment, nil
+
+}
+
+// pulls the latest events from the carrier for every shipment of the order that is still on its way
+
+func RefreshOrderShipments(context context.Context, usersCollection *mongo.Collection, shipmentsCollection *mongo.Collection, orderID primitive.ObjectID) ([]models.Shipment, error) {
+
+	shipments, err := GetOrderShipments(context, shipmentsCollection, orderID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for index, shipment := range shipments {
+
+		if shipment.Tracking_Number == "" || shipment.Status == models.ShipmentDelivered {
+			continue
+		}
+
+		carrier, err := carriers.Get(shipment.Carrier)
+
+		if err != nil {
+			continue
+		}
+
+		events, err := carrier.Track(context, shipment.Tracking_Number)
+
+		// a carrier outage should not hide the shipments we already know about
+		if err != nil {
+			slog.ErrorContext(context, "RefreshOrderShipments failed", "error", err)
+			continue
+		}
+
+		updated, err := ApplyTrackingEvents(context, usersCollection, shipmentsCollection, shipment.Carrier, shipment.Tracking_Number, events)
+
+		if err != nil {
+			slog.ErrorContext(context, "RefreshOrderShipments failed", "error", err)
+			continue
+		}
+
+		shipments[index] = updated
+
+	}
+
+	return shipments, nil
+
+}
+
+func updateOrderFulfillment(context context.Context, usersCollection *mongo.Collection, shipmentsCollection *mongo.Collection, orderID primitive.ObjectID) error {
+
+	order, _, err := FindOrder(context, usersCollection, orderID)
+
+	if err != nil {
+		return err
+	}
+
+	shipments, err := GetOrderShipments(context, shipmentsCollection, orderID)
+
+	if err != nil {
+		return err
+	}
+
+	// packed shipments have not left the warehouse yet, so they do not count as shipped
+	handedOver := make([]models.Shipment, 0, len(shipments))
+	allDelivered := true
+
+	for _, shipment := range shipments {
+
+		if shipment.Status == models.ShipmentPacked {
+			continue
+		}
+
+		handedOver = append(handedOver, shipment)
+
+		if shipment.Status != models.ShipmentDelivered {
+			allDelivered = false
+		}
+
+	}
+
+	fullyShipped := true
+
+	for _, quantity := range unshippedQuantities(order, handedOver) {
+		if quantity > 0 {
+			fullyShipped = false
+		}
+	}
+
+	var status string
+
+	switch {
+	case len(handedOver) == 0:
+		status = models.FulfillmentUnfulfilled
+	case fullyShipped && allDelivered:
+		status = models.FulfillmentDelivered
+	case fullyShipped:
+		status = models.FulfillmentShipped
+	default:
+		status = models.FulfillmentPartiallyShipped
+	}
+
+	filter := bson.D{primitive.E{Key: "orders._id", Value: orderID}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "orders.$.fulfillment_status", Value: status}}}}
+
+	if _, err = usersCollection.UpdateOne(context, filter, update); err != nil {
+		slog.ErrorContext(context, "updateOrderFulfillment failed", "error", err)
+		return ErrCantUpdateUser
+	}
+
+	return nil
+
+}
@@ -0,0 +1,610 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/currency"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindWishlist          = errors.New("unable to find the specified wishlist")
+	ErrCantFindWishlistItem      = errors.New("product is not in the wishlist")
+	ErrWishlistNameTaken         = errors.New("a wishlist with this name already exists")
+	ErrTooManyWishlists          = errors.New("wishlist limit reached")
+	ErrWishlistFull              = errors.New("wishlist is full")
+	ErrCantDeleteDefaultWishlist = errors.New("the default wishlist can not be deleted")
+	ErrCantUpdateWishlist        = errors.New("unable to update wishlist")
+)
+
+const (
+	MaxWishlists     = 20
+	MaxWishlistItems = 500
+)
+
+func EnsureWishlistIndexes(context context.Context, wishlistsCollection *mongo.Collection) error {
+
+	_, err := wishlistsCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		{Keys: bson.D{primitive.E{Key: "user_id", Value: 1}, primitive.E{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		// a user has exactly one default list, concurrent first requests can not create two
+		{Keys: bson.D{primitive.E{Key: "user_id", Value: 1}, primitive.E{Key: "is_default", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.D{primitive.E{Key: "is_default", Value: true}})},
+		{Keys: bson.D{primitive.E{Key: "share_token", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.D{primitive.E{Key: "share_token", Value: bson.D{primitive.E{Key: "$type", Value: "string"}}}})},
+	})
+
+	if err != nil {
+		slog.ErrorContext(context, "EnsureWishlistIndexes failed", "error", err)
+	}
+
+	return err
+
+}
+
+// the default list is created the first time it is needed
+
+func defaultWishlist(context context.Context, wishlistsCollection *mongo.Collection, userID string) (models.Wishlist, error) {
+
+	name := models.DefaultWishlistName
+	now := time.Now()
+
+	filter := bson.D{primitive.E{Key: "user_id", Value: userID}, primitive.E{Key: "is_default", Value: true}}
+	update := bson.D{{Key: "$setOnInsert", Value: bson.D{
+		primitive.E{Key: "_id", Value: primitive.NewObjectID()},
+		primitive.E{Key: "name", Value: name},
+		primitive.E{Key: "items", Value: make([]models.WishlistItem, 0)},
+		primitive.E{Key: "created_at", Value: now},
+		primitive.E{Key: "updated_at", Value: now},
+	}}}
+
+	var wishlist models.Wishlist
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	err := wishlistsCollection.FindOneAndUpdate(context, filter, update, opts).Decode(&wishlist)
+
+	// a concurrent request created it first
+	if mongo.IsDuplicateKeyError(err) {
+		err = wishlistsCollection.FindOne(context, filter).Decode(&wishlist)
+	}
+
+	if err != nil {
+		slog.ErrorContext(context, "defaultWishlist failed", "error", err)
+		return models.Wishlist{}, ErrCantFindWishlist
+	}
+
+	return wishlist, nil
+
+}
+
+// lists are only ever found together with their owner, a nil ID means the default list
+
+func FindWishlist(context context.Context, wishlistsCollection *mongo.Collection, wishlistID *primitive.ObjectID, userID string) (models.Wishlist, error) {
+
+	if wishlistID == nil {
+		return defaultWishlist(context, wishlistsCollection, userID)
+	}
+
+	var wishlist models.Wishlist
+
+	filter := bson.D{primitive.E{Key: "_id", Value: *wishlistID}, primitive.E{Key: "user_id", Value: userID}}
+
+	if err := wishlistsCollection.FindOne(context, filter).Decode(&wishlist); err != nil {
+		slog.ErrorContext(context, "FindWishlist failed", "error", err)
+		return models.Wishlist{}, ErrCantFindWishlist
+	}
+
+	return wishlist, nil
+
+}
+
+func ListWishlists(context context.Context, productsCollection *mongo.Collection, wishlistsCollection *mongo.Collection, userID string) ([]models.Wishlist, error) {
+
+	if _, err := defaultWishlist(context, wishlistsCollection, userID); err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: "is_default", Value: -1}, primitive.E{Key: "created_at", Value: 1}})
+	cursor, err := wishlistsCollection.Find(context, bson.D{primitive.E{Key: "user_id", Value: userID}}, opts)
+
+	if err != nil {
+		slog.ErrorContext(context, "ListWishlists failed", "error", err)
+		return nil, ErrCantFindWishlist
+	}
+
+	wishlists := make([]models.Wishlist, 0)
+
+	if err = cursor.All(context, &wishlists); err != nil {
+		slog.ErrorContext(context, "ListWishlists failed", "error", err)
+		return nil, ErrCantFindWishlist
+	}
+
+	if err = annotateWishlistPrices(context, productsCollection, wishlists); err != nil {
+		return nil, err
+	}
+
+	return wishlists, nil
+
+}
+
+func GetWishlist(context context.Context, productsCollection *mongo.Collection, wishlistsCollection *mongo.Collection, wishlistID *primitive.ObjectID, userID string) (models.Wishlist, error) {
+
+	wishlist, err := FindWishlist(context, wishlistsCollection, wishlistID, userID)
+
+	if err != nil {
+		return models.Wishlist{}, err
+	}
+
+	wishlists := []models.Wishlist{wishlist}
+
+	if err = annotateWishlistPrices(context, productsCollection, wishlists); err != nil {
+		return models.Wishlist{}, err
+	}
+
+	return wishlists[0], nil
+
+}
+
+func CreateWishlist(context context.Context, wishlistsCollection *mongo.Collection, userID string, name string) (models.Wishlist, error) {
+
+	count, err := wishlistsCollection.CountDocuments(context, bson.D{primitive.E{Key: "user_id", Value: userID}})
+
+	if err != nil {
+		slog.ErrorContext(context, "CreateWishlist failed", "error", err)
+		return models.Wishlist{}, ErrCantUpdateWishlist
+	}
+
+	if count >= MaxWishlists {
+		return models.Wishlist{}, ErrTooManyWishlists
+	}
+
+	wishlist := models.Wishlist{
+		Wishlist_ID: primitive.NewObjectID(),
+		User_ID:     userID,
+		Name:        &name,
+		Items:       make([]models.WishlistItem, 0),
+		Created_At:  time.Now(),
+	}
+	wishlist.Updated_At = wishlist.Created_At
+
+	if _, err = wishlistsCollection.InsertOne(context, wishlist); err != nil {
+		slog.ErrorContext(context, "CreateWishlist failed", "error", err)
+		if mongo.IsDuplicateKeyError(err) {
+			return models.Wishlist{}, ErrWishlistNameTaken
+		}
+		return models.Wishlist{}, ErrCantUpdateWishlist
+	}
+
+	return wishlist, nil
+
+}
+
+func RenameWishlist(context context.Context, wishlistsCollection *mongo.Collection, wishlistID primitive.ObjectID, userID string, name string) (models.Wishlist, error) {
+
+	filter := bson.D{primitive.E{Key: "_id", Value: wishlistID}, primitive.E{Key: "user_id", Value: userID}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "name", Value: name}, primitive.E{Key: "updated_at", Value: time.Now()}}}}
+
+	var wishlist models.Wishlist
+
+	err := wishlistsCollection.FindOneAndUpdate(context, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&wishlist)
+
+	if err != nil {
+		slog.ErrorContext(context, "RenameWishlist failed", "error", err)
+		if mongo.IsDuplicateKeyError(err) {
+			return models.Wishlist{}, ErrWishlistNameTaken
+		}
+		return models.Wishlist{}, ErrCantFindWishlist
+	}
+
+	return wishlist, nil
+
+}
+
+func DeleteWishlist(context context.Context, wishlistsCollection *mongo.Collection, wishlistID primitive.ObjectID, userID string) error {
+
+	wishlist, err := FindWishlist(context, wishlistsCollection, &wishlistID, userID)
+
+	if err != nil {
+		return err
+	}
+
+	if wishlist.Is_Default {
+		return ErrCantDeleteDefaultWishlist
+	}
+
+	if _, err = wishlistsCollection.DeleteOne(context, bson.D{primitive.E{Key: "_id", Value: wishlistID}}); err != nil {
+		slog.ErrorContext(context, "DeleteWishlist failed", "error", err)
+		return ErrCantUpdateWishlist
+	}
+
+	return nil
+
+}
+
+// products with variants are saved as the selected SKU, out of stock SKUs can still be saved
+
+func wishlistItemForProduct(product models.Product, variantID *primitive.ObjectID) (models.WishlistItem, error) {
+
+	item := models.WishlistItem{
+		Product_ID:   product.Product_ID,
+		Product_Name: product.Product_Name,
+		Image:        product.Image,
+		Saved_Price:  product.Price,
+		Added_At:     time.Now(),
+	}
+
+	if len(product.Variants) == 0 {
+		return item, nil
+	}
+
+	if variantID == nil {
+		return models.WishlistItem{}, ErrVariantRequired
+	}
+
+	variant, ok := product.FindVariant(*variantID)
+
+	if !ok {
+		return models.WishlistItem{}, ErrCantFindVariant
+	}
+
+	item.Variant_ID = &variant.Variant_ID
+	item.SKU = variant.SKU
+	item.Options = variant.Options
+
+	if variant.Price.Currency != "" {
+		item.Saved_Price = variant.Price
+	}
+
+	if len(variant.Images) > 0 {
+		image := variant.Images[0]
+		item.Image = &image
+	}
+
+	return item, nil
+
+}
+
+// matches one line of a list, a nil variant also matches the stored lines without a variant_id
+func wishlistItemMatch(productID primitive.ObjectID, variantID *primitive.ObjectID) bson.D {
+
+	match := bson.D{primitive.E{Key: "product_id", Value: productID}, primitive.E{Key: "variant_id", Value: nil}}
+
+	if variantID != nil {
+		match[1].Value = *variantID
+	}
+
+	return match
+
+}
+
+func wishlistContains(wishlist models.Wishlist, productID primitive.ObjectID, variantID *primitive.ObjectID) bool {
+
+	for _, item := range wishlist.Items {
+		if item.Product_ID == productID && sameVariant(item.Variant_ID, variantID) {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+func sameVariant(left *primitive.ObjectID, right *primitive.ObjectID) bool {
+
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+
+	return *left == *right
+
+}
+
+// saving a product twice keeps the first entry, and with it the price it was first saved at
+
+func AddWishlistItem(context context.Context, productsCollection *mongo.Collection, wishlistsCollection *mongo.Collection, wishlistID *primitive.ObjectID, userID string, productID primitive.ObjectID, variantID *primitive.ObjectID) (models.Wishlist, error) {
+
+	wishlist, err := FindWishlist(context, wishlistsCollection, wishlistID, userID)
+
+	if err != nil {
+		return models.Wishlist{}, err
+	}
+
+	product, err := FindProduct(context, productsCollection, productID)
+
+	if err != nil {
+		return models.Wishlist{}, err
+	}
+
+	item, err := wishlistItemForProduct(product, variantID)
+
+	if err != nil {
+		return models.Wishlist{}, err
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: wishlist.Wishlist_ID},
+		primitive.E{Key: "items", Value: bson.D{primitive.E{Key: "$not", Value: bson.D{primitive.E{Key: "$elemMatch", Value: wishlistItemMatch(productID, item.Variant_ID)}}}}},
+		primitive.E{Key: "items." + strconv.Itoa(MaxWishlistItems-1), Value: bson.D{primitive.E{Key: "$exists", Value: false}}},
+	}
+	update := bson.D{
+		{Key: "$push", Value: bson.D{primitive.E{Key: "items", Value: item}}},
+		{Key: "$set", Value: bson.D{primitive.E{Key: "updated_at", Value: time.Now()}}},
+	}
+
+	result, err := wishlistsCollection.UpdateOne(context, filter, update)
+
+	if err != nil {
+		slog.ErrorContext(context, "AddWishlistItem failed", "error", err)
+		return models.Wishlist{}, ErrCantUpdateWishlist
+	}
+
+	if result.MatchedCount == 0 && !wishlistContains(wishlist, productID, item.Variant_ID) {
+		return models.Wishlist{}, ErrWishlistFull
+	}
+
+	return GetWishlist(context, productsCollection, wishlistsCollection, &wishlist.Wishlist_ID, userID)
+
+}
+
+func RemoveWishlistItem(context context.Context, wishlistsCollection *mongo.Collection, wishlistID *primitive.ObjectID, userID string, productID primitive.ObjectID, variantID *primitive.ObjectID) error {
+
+	wishlist, err := FindWishlist(context, wishlistsCollection, wishlistID, userID)
+
+	if err != nil {
+		return err
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: wishlist.Wishlist_ID}}
+	update := bson.D{
+		{Key: "$pull", Value: bson.D{primitive.E{Key: "items", Value: wishlistItemMatch(productID, variantID)}}},
+		{Key: "$set", Value: bson.D{primitive.E{Key: "updated_at", Value: time.Now()}}},
+	}
+
+	result, err := wishlistsCollection.UpdateOne(context, filter, update)
+
+	if err != nil {
+		slog.ErrorContext(context, "RemoveWishlistItem failed", "error", err)
+		return ErrCantUpdateWishlist
+	}
+
+	if result.ModifiedCount == 0 {
+		return ErrCantFindWishlistItem
+	}
+
+	return nil
+
+}
+
+// the item leaves the cart only once it is safely in the list
+
+func MoveCartItemToWishlist(context context.Context, productsCollection *mongo.Collection, usersCollection *mongo.Collection, wishlistsCollection *mongo.Collection, userID string, productID primitive.ObjectID, variantID *primitive.ObjectID, wishlistID *primitive.ObjectID) (models.Wishlist, error) {
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		slog.ErrorContext(context, "MoveCartItemToWishlist failed", "error", err)
+		return models.Wishlist{}, ErrUserIDIsNotValid
+	}
+
+	var user models.User
+
+	if err = usersCollection.FindOne(context, bson.D{primitive.E{Key: "_id", Value: userObjectID}}).Decode(&user); err != nil {
+		slog.ErrorContext(context, "MoveCartItemToWishlist failed", "error", err)
+		return models.Wishlist{}, ErrCantGetItem
+	}
+
+	var line *models.ProductUser
+
+	for index := range user.UserCart {
+		if user.UserCart[index].Product_ID == productID && (variantID == nil || sameVariant(user.UserCart[index].Variant_ID, variantID)) {
+			line = &user.UserCart[index]
+			break
+		}
+	}
+
+	if line == nil {
+		return models.Wishlist{}, ErrCantFindCartItem
+	}
+
+	wishlist, err := AddWishlistItem(context, productsCollection, wishlistsCollection, wishlistID, userID, productID, line.Variant_ID)
+
+	if err != nil {
+		return models.Wishlist{}, err
+	}
+
+	if err = RemoveCartItem(context, productsCollection, usersCollection, productID, line.Variant_ID, userID); err != nil {
+		return models.Wishlist{}, err
+	}
+
+	return wishlist, nil
+
+}
+
+// the cart applies its usual checks, so a SKU that went out of stock stays in the list
+
+func MoveWishlistItemToCart(context context.Context, productsCollection *mongo.Collection, usersCollection *mongo.Collection, wishlistsCollection *mongo.Collection, userID string, productID primitive.ObjectID, variantID *primitive.ObjectID, wishlistID *primitive.ObjectID) error {
+
+	wishlist, err := FindWishlist(context, wishlistsCollection, wishlistID, userID)
+
+	if err != nil {
+		return err
+	}
+
+	if !wishlistContains(wishlist, productID, variantID) {
+		return ErrCantFindWishlistItem
+	}
+
+	if err = AddProductToCart(context, productsCollection, usersCollection, productID, variantID, userID); err != nil {
+		return err
+	}
+
+	return RemoveWishlistItem(context, wishlistsCollection, &wishlist.Wishlist_ID, userID, productID, variantID)
+
+}
+
+// a share link gives anyone holding the token a read only view, sharing again returns the same link
+
+func ShareWishlist(context context.Context, wishlistsCollection *mongo.Collection, wishlistID primitive.ObjectID, userID string) (string, error) {
+
+	wishlist, err := FindWishlist(context, wishlistsCollection, &wishlistID, userID)
+
+	if err != nil {
+		return "", err
+	}
+
+	if wishlist.Share_Token != nil {
+		return *wishlist.Share_Token, nil
+	}
+
+	secret := make([]byte, 18)
+
+	if _, err = rand.Read(secret); err != nil {
+		slog.ErrorContext(context, "ShareWishlist failed", "error", err)
+		return "", ErrCantUpdateWishlist
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	filter := bson.D{primitive.E{Key: "_id", Value: wishlistID}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "share_token", Value: token}, primitive.E{Key: "updated_at", Value: time.Now()}}}}
+
+	if _, err = wishlistsCollection.UpdateOne(context, filter, update); err != nil {
+		slog.ErrorContext(context, "ShareWishlist failed", "error", err)
+		return "", ErrCantUpdateWishlist
+	}
+
+	return token, nil
+
+}
+
+// revoking invalidates the old link for good, sharing again creates a new one
+
+func UnshareWishlist(context context.Context, wishlistsCollection *mongo.Collection, wishlistID primitive.ObjectID, userID string) error {
+
+	filter := bson.D{primitive.E{Key: "_id", Value: wishlistID}, primitive.E{Key: "user_id", Value: userID}}
+	update := bson.D{
+		{Key: "$unset", Value: bson.D{primitive.E{Key: "share_token", Value: ""}}},
+		{Key: "$set", Value: bson.D{primitive.E{Key: "updated_at", Value: time.Now()}}},
+	}
+
+	result, err := wishlistsCollection.UpdateOne(context, filter, update)
+
+	if err != nil {
+		slog.ErrorContext(context, "UnshareWishlist failed", "error", err)
+		return ErrCantUpdateWishlist
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrCantFindWishlist
+	}
+
+	return nil
+
+}
+
+func FindSharedWishlist(context context.Context, productsCollection *mongo.Collection, wishlistsCollection *mongo.Collection, token string) (models.Wishlist, error) {
+
+	var wishlist models.Wishlist
+
+	if err := wishlistsCollection.FindOne(context, bson.D{primitive.E{Key: "share_token", Value: token}}).Decode(&wishlist); err != nil {
+		slog.ErrorContext(context, "FindSharedWishlist failed", "error", err)
+		return models.Wishlist{}, ErrCantFindWishlist
+	}
+
+	wishlists := []models.Wishlist{wishlist}
+
+	if err := annotateWishlistPrices(context, productsCollection, wishlists); err != nil {
+		return models.Wishlist{}, err
+	}
+
+	return wishlists[0], nil
+
+}
+
+// compares every item with the current price of its product, in the currency it was saved in
+
+func annotateWishlistPrices(context context.Context, productsCollection *mongo.Collection, wishlists []models.Wishlist) error {
+
+	productIDs := make([]primitive.ObjectID, 0)
+
+	for _, wishlist := range wishlists {
+		for _, item := range wishlist.Items {
+			productIDs = append(productIDs, item.Product_ID)
+		}
+	}
+
+	if len(productIDs) == 0 {
+		return nil
+	}
+
+	cursor, err := productsCollection.Find(context, bson.D{primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$in", Value: productIDs}}}})
+
+	if err != nil {
+		slog.ErrorContext(context, "annotateWishlistPrices failed", "error", err)
+		return ErrCantFindProduct
+	}
+
+	var products []models.Product
+
+	if err = cursor.All(context, &products); err != nil {
+		slog.ErrorContext(context, "annotateWishlistPrices failed", "error", err)
+		return ErrCantDecodeProducts
+	}
+
+	productsByID := make(map[primitive.ObjectID]models.Product, len(products))
+
+	for _, product := range products {
+		productsByID[product.Product_ID] = product
+	}
+
+	for _, wishlist := range wishlists {
+		for index := range wishlist.Items {
+
+			item := &wishlist.Items[index]
+			product, ok := productsByID[item.Product_ID]
+
+			if !ok {
+				continue
+			}
+
+			price, prices := product.Price, product.Prices
+			item.Available = len(product.Variants) == 0
+
+			if item.Variant_ID != nil {
+
+				variant, ok := product.FindVariant(*item.Variant_ID)
+
+				if !ok {
+					continue
+				}
+
+				item.Available = variant.Stock > 0
+
+				if variant.Price.Currency != "" {
+					price, prices = variant.Price, variant.Prices
+				}
+
+			}
+
+			current, err := currency.PriceIn(price, prices, item.Saved_Price.Currency)
+
+			if err != nil {
+				continue
+			}
+
+			item.Current_Price = &current
+			item.Price_Dropped = current.Amount < item.Saved_Price.Amount
+
+		}
+	}
+
+	return nil
+
+}
@@ -0,0 +1,133 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// how long a single dependency may take to answer before it counts as down
+const checkTimeout = 2 * time.Second
+
+// a dependency the server needs before it can take traffic, eg : {"mongo", ping}
+
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+var ErrNotRunning = errors.New("not running")
+
+// the check of a background worker, eg : health.Running(search.Suggestions.Running)
+
+func Running(running func() bool) func(ctx context.Context) error {
+
+	return func(ctx context.Context) error {
+
+		if !running() {
+			return ErrNotRunning
+		}
+
+		return nil
+
+	}
+
+}
+
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// milliseconds the check took
+	Duration int64 `json:"duration_ms"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// answers as long as the process can serve requests at all, dependencies are left to the readiness probe
+// so a database outage does not get every instance restarted
+
+func Liveness() gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, Report{Status: StatusUp})
+	}
+
+}
+
+// runs every check in parallel, the status is 503 as soon as one of them is down, eg :
+// {"status": "down", "checks": {"mongo": {"status": "down", "error": "server selection timeout"}, ...}}
+
+func Readiness(checks ...Check) gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		context, cancel := context.WithTimeout(ctx.Request.Context(), checkTimeout)
+		defer cancel()
+
+		report := Run(context, checks)
+		status := http.StatusOK
+
+		if report.Status != StatusUp {
+			status = http.StatusServiceUnavailable
+		}
+
+		ctx.JSON(status, report)
+
+	}
+
+}
+
+func Run(ctx context.Context, checks []Check) Report {
+
+	report := Report{Status: StatusUp, Checks: make(map[string]CheckResult, len(checks))}
+
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+
+	for _, check := range checks {
+
+		wait.Add(1)
+
+		go func(check Check) {
+
+			defer wait.Done()
+
+			startedAt := time.Now()
+			result := CheckResult{Status: StatusUp}
+
+			if err := check.Check(ctx); err != nil {
+				result = CheckResult{Status: StatusDown, Error: err.Error()}
+			}
+
+			result.Duration = time.Since(startedAt).Milliseconds()
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			report.Checks[check.Name] = result
+
+			if result.Status != StatusUp {
+				report.Status = StatusDown
+			}
+
+		}(check)
+
+	}
+
+	wait.Wait()
+
+	return report
+
+}
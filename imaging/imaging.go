@@ -0,0 +1,167 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+var (
+	ErrUnsupportedType = errors.New("image type is not supported, use JPEG, PNG or GIF")
+	ErrTooLarge        = errors.New("image is too large")
+	ErrCantDecode      = errors.New("unable to decode image")
+	ErrCantEncode      = errors.New("unable to encode image")
+)
+
+const (
+	MaxUploadBytes = 5 << 20
+	// guards against small files that decode into huge bitmaps
+	MaxPixels = 40_000_000
+)
+
+// the content type is sniffed from the bytes, the name and header sent by the client are never trusted
+
+var allowedTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+func Extension(contentType string) string {
+	return allowedTypes[contentType]
+}
+
+func Decode(data []byte) (image.Image, string, error) {
+
+	if len(data) > MaxUploadBytes {
+		return nil, "", ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+
+	if _, ok := allowedTypes[contentType]; !ok {
+		return nil, "", ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, "", ErrCantDecode
+	}
+
+	if config.Width*config.Height > MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+
+	var decoded image.Image
+
+	switch contentType {
+	case "image/jpeg":
+		decoded, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		decoded, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		decoded, err = gif.Decode(bytes.NewReader(data))
+	}
+
+	if err != nil {
+		return nil, "", ErrCantDecode
+	}
+
+	return decoded, contentType, nil
+
+}
+
+// scales the image down so its longest side is at most maxSide, smaller images are returned untouched
+// every target pixel is the average of the source pixels it covers, which keeps thumbnails free of aliasing
+
+func Resize(source image.Image, maxSide int) image.Image {
+
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width <= maxSide && height <= maxSide {
+		return source
+	}
+
+	targetWidth, targetHeight := maxSide, maxSide
+
+	if width > height {
+		targetHeight = max(1, height*maxSide/width)
+	} else {
+		targetWidth = max(1, width*maxSide/height)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(rgba, rgba.Bounds(), source, bounds.Min, draw.Src)
+
+	target := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+
+	for y := 0; y < targetHeight; y++ {
+
+		sourceTop := y * height / targetHeight
+		sourceBottom := max(sourceTop+1, (y+1)*height/targetHeight)
+
+		for x := 0; x < targetWidth; x++ {
+
+			sourceLeft := x * width / targetWidth
+			sourceRight := max(sourceLeft+1, (x+1)*width/targetWidth)
+
+			var red, green, blue, alpha, count uint64
+
+			for sourceY := sourceTop; sourceY < sourceBottom; sourceY++ {
+
+				row := rgba.Pix[sourceY*rgba.Stride:]
+
+				for sourceX := sourceLeft; sourceX < sourceRight; sourceX++ {
+					pixel := row[sourceX*4 : sourceX*4+4]
+					red += uint64(pixel[0])
+					green += uint64(pixel[1])
+					blue += uint64(pixel[2])
+					alpha += uint64(pixel[3])
+					count++
+				}
+
+			}
+
+			offset := y*target.Stride + x*4
+			target.Pix[offset] = uint8(red / count)
+			target.Pix[offset+1] = uint8(green / count)
+			target.Pix[offset+2] = uint8(blue / count)
+			target.Pix[offset+3] = uint8(alpha / count)
+
+		}
+
+	}
+
+	return target
+
+}
+
+// derivatives of PNG and GIF sources stay PNG so transparency survives, everything else becomes JPEG
+
+func Encode(img image.Image, sourceType string) ([]byte, string, error) {
+
+	var buffer bytes.Buffer
+	var err error
+	contentType := "image/jpeg"
+
+	if sourceType == "image/png" || sourceType == "image/gif" {
+		contentType = "image/png"
+		err = png.Encode(&buffer, img)
+	} else {
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 85})
+	}
+
+	if err != nil {
+		return nil, "", ErrCantEncode
+	}
+
+	return buffer.Bytes(), contentType, nil
+
+}
@@ -0,0 +1,559 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/currency"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/logging"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"github.com/aaravmahajanofficial/ecommerce-project/search"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindJob = errors.New("unable to find the specified import job")
+	ErrInterrupted = errors.New("the import was interrupted by a server shutdown, upload the file again")
+)
+
+const (
+	// the job document is written after this many rows, so polling shows steady progress
+	progressEvery = 100
+	// a broken file would otherwise produce an error per row, the count keeps going past this
+	maxReportedErrors = 1000
+)
+
+var (
+	validate = validator.New()
+	// background imports still running, waited for on shutdown
+	running sync.WaitGroup
+	// the parent of every background import, cancelled when shutdown stops waiting for them
+	jobsContext, stopJobs = context.WithCancel(context.Background())
+)
+
+type Importer struct {
+	productsCollection   *mongo.Collection
+	categoriesCollection *mongo.Collection
+	jobsCollection       *mongo.Collection
+}
+
+func NewImporter(productsCollection *mongo.Collection, categoriesCollection *mongo.Collection, jobsCollection *mongo.Collection) *Importer {
+
+	return &Importer{
+		productsCollection:   productsCollection,
+		categoriesCollection: categoriesCollection,
+		jobsCollection:       jobsCollection,
+	}
+}
+
+// waits for the background imports until the context is done, imports still running then are
+// stopped and marked as failed, which takes a moment longer than the context allows
+
+func Shutdown(ctx context.Context) error {
+
+	done := make(chan struct{})
+
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	stopJobs()
+	<-done
+
+	return ctx.Err()
+
+}
+
+func (importer *Importer) FindJob(ctx context.Context, jobID primitive.ObjectID) (models.ImportJob, error) {
+
+	var job models.ImportJob
+
+	if err := importer.jobsCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: jobID}}).Decode(&job); err != nil {
+		slog.ErrorContext(ctx, "FindJob failed", "error", err)
+		return models.ImportJob{}, ErrCantFindJob
+	}
+
+	return job, nil
+
+}
+
+// takes ownership of the uploaded file, it is removed once the background job is done with it
+
+func (importer *Importer) Start(ctx context.Context, format string, path string) (models.ImportJob, error) {
+
+	if format != FormatCSV && format != FormatJSONL {
+		os.Remove(path)
+		return models.ImportJob{}, ErrUnknownFormat
+	}
+
+	job := models.ImportJob{
+		Job_ID:     primitive.NewObjectID(),
+		Format:     format,
+		Status:     models.ImportQueued,
+		Errors:     make([]models.ImportRowError, 0),
+		Created_At: time.Now(),
+	}
+
+	if _, err := importer.jobsCollection.InsertOne(ctx, job); err != nil {
+		slog.ErrorContext(ctx, "Start failed", "error", err)
+		os.Remove(path)
+		return models.ImportJob{}, err
+	}
+
+	running.Add(1)
+
+	go func() {
+
+		defer running.Done()
+		defer os.Remove(path)
+
+		// the request that started the job is long gone, the job gets its own deadline
+		jobContext, cancel := context.WithTimeout(jobsContext, 2*time.Hour)
+		defer cancel()
+
+		// its log lines name the job and the request that started it
+		jobContext = logging.With(logging.WithAttrsFrom(jobContext, ctx), "import_job_id", job.Job_ID.Hex())
+
+		file, err := os.Open(path)
+
+		if err != nil {
+			importer.finish(jobContext, &job, err)
+			return
+		}
+
+		defer file.Close()
+
+		err = importer.Run(jobContext, &job, file, nil)
+
+		if jobsContext.Err() != nil {
+			err = ErrInterrupted
+		}
+
+		importer.finish(jobContext, &job, err)
+
+	}()
+
+	return job, nil
+
+}
+
+// the job context may be over by now, the final state is saved with a deadline of its own
+
+func (importer *Importer) finish(ctx context.Context, job *models.ImportJob, err error) {
+
+	finishedAt := time.Now()
+	job.Finished_At = &finishedAt
+	job.Status = models.ImportCompleted
+
+	if err != nil {
+		job.Status = models.ImportFailed
+		job.Message = err.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	importer.saveProgress(ctx, job)
+
+}
+
+func (importer *Importer) saveProgress(ctx context.Context, job *models.ImportJob) {
+
+	if importer.jobsCollection == nil {
+		return
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: job.Job_ID}}
+
+	if _, err := importer.jobsCollection.ReplaceOne(ctx, filter, job); err != nil {
+		slog.ErrorContext(ctx, "saveProgress failed", "error", err)
+	}
+
+}
+
+// reads every row, invalid rows are reported and skipped, the rest are upserted by SKU
+// progress is reported to the callback as well, the command line uses it to print status
+
+func (importer *Importer) Run(ctx context.Context, job *models.ImportJob, input io.Reader, progress func(models.ImportJob)) error {
+
+	startedAt := time.Now()
+	job.Started_At = &startedAt
+	job.Status = models.ImportRunning
+	importer.saveProgress(ctx, job)
+
+	reader, err := NewRowReader(job.Format, input)
+
+	if err != nil {
+		return err
+	}
+
+	categorySlugs := make(map[string]primitive.ObjectID)
+	touched := make([]string, 0, progressEvery)
+
+	for rowNumber := 1; ; rowNumber++ {
+
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		row, err := reader.Next()
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		job.Processed++
+
+		if err == nil {
+			err = importer.upsertRow(ctx, row, categorySlugs, job)
+		}
+
+		if err != nil {
+
+			job.Failed++
+
+			if len(job.Errors) < maxReportedErrors {
+				job.Errors = append(job.Errors, models.ImportRowError{Row: rowNumber, SKU: row.SKU, Message: err.Error()})
+			}
+
+			// the rest of a JSON Lines file cannot be read after a syntax error
+			if errors.Is(err, ErrMalformedFile) {
+				break
+			}
+
+		} else {
+			touched = append(touched, row.SKU)
+		}
+
+		if job.Processed%progressEvery == 0 {
+			importer.refreshSearch(ctx, touched)
+			touched = touched[:0]
+			importer.saveProgress(ctx, job)
+			if progress != nil {
+				progress(*job)
+			}
+		}
+
+	}
+
+	importer.refreshSearch(ctx, touched)
+	search.Suggestions.MarkDirty()
+
+	if progress != nil {
+		progress(*job)
+	}
+
+	return nil
+
+}
+
+func (importer *Importer) refreshSearch(ctx context.Context, skus []string) {
+
+	if len(skus) == 0 {
+		return
+	}
+
+	filter := bson.D{primitive.E{Key: "sku", Value: bson.D{primitive.E{Key: "$in", Value: skus}}}}
+
+	if err := search.RefreshSearchGrams(ctx, importer.productsCollection, filter); err != nil {
+		slog.ErrorContext(ctx, "refreshSearch failed", "error", err)
+	}
+
+}
+
+// a row is validated with the same rules as products created through the API before anything is written
+
+func (importer *Importer) validateRow(row ProductRow) (models.Product, error) {
+
+	if !database.SKUPattern.MatchString(row.SKU) {
+		return models.Product{}, errors.New("sku is missing or contains characters other than letters, digits, '.', '_' and '-'")
+	}
+
+	product := models.Product{
+		SKU:          row.SKU,
+		Product_Name: &row.Product_Name,
+		Tags:         row.Tags,
+		Price:        row.Price,
+		Prices:       row.Prices,
+		Options:      row.Options,
+		Variants:     row.Variants,
+	}
+
+	if row.Description != "" {
+		product.Description = &row.Description
+	}
+
+	if row.Brand != "" {
+		product.Brand = &row.Brand
+	}
+
+	if row.Image != "" {
+		product.Image = &row.Image
+	}
+
+	if err := validate.Struct(product); err != nil {
+		return models.Product{}, err
+	}
+
+	if !models.IsSupportedCurrency(product.Price.Currency) || product.Price.Amount < 0 {
+		return models.Product{}, errors.New("price must have a supported currency and a non negative amount")
+	}
+
+	// listings sort, filter and bucket on the stored amount, so every product's price must be in the same currency,
+	// prices in other currencies go in the price list
+	if base := currency.Rates.Base(); product.Price.Currency != base {
+		return models.Product{}, fmt.Errorf("price must be in the base currency %s, other currencies go in prices", base)
+	}
+
+	for _, price := range product.Prices {
+		if !models.IsSupportedCurrency(price.Currency) || price.Amount < 0 {
+			return models.Product{}, errors.New("prices must have a supported currency and a non negative amount")
+		}
+	}
+
+	if err := database.ValidateVariants(product.Options, product.Variants); err != nil {
+		return models.Product{}, err
+	}
+
+	return product, nil
+
+}
+
+func (importer *Importer) upsertRow(ctx context.Context, row ProductRow, categorySlugs map[string]primitive.ObjectID, job *models.ImportJob) error {
+
+	product, err := importer.validateRow(row)
+
+	if err != nil {
+		return err
+	}
+
+	categoryIDs := make([]primitive.ObjectID, 0, len(row.Categories))
+
+	for _, slug := range row.Categories {
+
+		categoryID, known := categorySlugs[slug]
+
+		if !known {
+
+			category, err := database.FindCategory(ctx, importer.categoriesCollection, bson.D{primitive.E{Key: "slug", Value: slug}})
+
+			if err != nil {
+				return fmt.Errorf("category %q does not exist", slug)
+			}
+
+			categoryID = category.Category_ID
+			categorySlugs[slug] = categoryID
+
+		}
+
+		categoryIDs = append(categoryIDs, categoryID)
+
+	}
+
+	// fields the file does not describe, like images, reviews and ratings, are left alone on existing products
+	fields := bson.D{
+		primitive.E{Key: "product_name", Value: product.Product_Name},
+		primitive.E{Key: "description", Value: product.Description},
+		primitive.E{Key: "brand", Value: product.Brand},
+		primitive.E{Key: "tags", Value: product.Tags},
+		primitive.E{Key: "price", Value: product.Price},
+		primitive.E{Key: "prices", Value: product.Prices},
+		primitive.E{Key: "category_ids", Value: categoryIDs},
+	}
+
+	if product.Image != nil {
+		fields = append(fields, primitive.E{Key: "image", Value: product.Image})
+	}
+
+	if len(product.Variants) > 0 {
+
+		variants, err := importer.keepVariantIDs(ctx, product.SKU, product.Variants)
+
+		if err != nil {
+			return err
+		}
+
+		fields = append(fields, primitive.E{Key: "options", Value: product.Options}, primitive.E{Key: "variants", Value: variants})
+
+	}
+
+	filter := bson.D{primitive.E{Key: "sku", Value: product.SKU}}
+	update := bson.D{
+		{Key: "$set", Value: fields},
+		{Key: "$setOnInsert", Value: bson.D{primitive.E{Key: "_id", Value: primitive.NewObjectID()}}},
+	}
+
+	result, err := importer.productsCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+
+	if err != nil {
+		slog.ErrorContext(ctx, "upsertRow failed", "error", err)
+		if mongo.IsDuplicateKeyError(err) {
+			return database.ErrSKUTaken
+		}
+		return database.ErrCantUpdateProductInfo
+	}
+
+	if result.UpsertedCount > 0 {
+		job.Inserted++
+	} else {
+		job.Updated++
+	}
+
+	return nil
+
+}
+
+// re-imported variants keep their ids when the SKU matches, so carts holding them stay valid
+
+func (importer *Importer) keepVariantIDs(ctx context.Context, productSKU string, variants []models.Variant) ([]models.Variant, error) {
+
+	var existing models.Product
+
+	err := importer.productsCollection.FindOne(ctx, bson.D{primitive.E{Key: "sku", Value: productSKU}}).Decode(&existing)
+
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		slog.ErrorContext(ctx, "keepVariantIDs failed", "error", err)
+		return nil, database.ErrCantFindProduct
+	}
+
+	ids := make(map[string]primitive.ObjectID, len(existing.Variants))
+
+	for _, variant := range existing.Variants {
+		ids[variant.SKU] = variant.Variant_ID
+	}
+
+	for index := range variants {
+
+		if variantID, ok := ids[variants[index].SKU]; ok {
+			variants[index].Variant_ID = variantID
+		} else {
+			variants[index].Variant_ID = primitive.NewObjectID()
+		}
+
+		if variants[index].Images == nil {
+			variants[index].Images = make([]string, 0)
+		}
+
+	}
+
+	return variants, nil
+
+}
+
+// streams the catalog one product at a time, so exports never hold the collection in memory
+
+func (importer *Importer) Export(ctx context.Context, format string, output io.Writer) error {
+
+	writer, err := NewRowWriter(format, output)
+
+	if err != nil {
+		return err
+	}
+
+	categorySlugs := make(map[primitive.ObjectID]string)
+	cursor, err := importer.categoriesCollection.Find(ctx, bson.D{}, options.Find().SetProjection(bson.D{primitive.E{Key: "slug", Value: 1}}))
+
+	if err != nil {
+		slog.ErrorContext(ctx, "Export failed", "error", err)
+		return database.ErrCantDecodeCategories
+	}
+
+	var categories []models.Category
+
+	if err = cursor.All(ctx, &categories); err != nil {
+		slog.ErrorContext(ctx, "Export failed", "error", err)
+		return database.ErrCantDecodeCategories
+	}
+
+	for _, category := range categories {
+		categorySlugs[category.Category_ID] = category.Slug
+	}
+
+	cursor, err = importer.productsCollection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{primitive.E{Key: "_id", Value: 1}}))
+
+	if err != nil {
+		slog.ErrorContext(ctx, "Export failed", "error", err)
+		return database.ErrCantFindProduct
+	}
+
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+
+		var product models.Product
+
+		if err = cursor.Decode(&product); err != nil {
+			slog.ErrorContext(ctx, "Export failed", "error", err)
+			return database.ErrCantDecodeProducts
+		}
+
+		if err = writer.Write(rowFromProduct(product, categorySlugs)); err != nil {
+			return err
+		}
+
+	}
+
+	if err = cursor.Err(); err != nil {
+		slog.ErrorContext(ctx, "Export failed", "error", err)
+		return database.ErrCantDecodeProducts
+	}
+
+	return writer.Flush()
+
+}
+
+func rowFromProduct(product models.Product, categorySlugs map[primitive.ObjectID]string) ProductRow {
+
+	row := ProductRow{
+		SKU:        product.SKU,
+		Tags:       product.Tags,
+		Price:      product.Price,
+		Prices:     product.Prices,
+		Categories: make([]string, 0, len(product.Category_IDs)),
+		Options:    product.Options,
+		Variants:   product.Variants,
+	}
+
+	if product.Product_Name != nil {
+		row.Product_Name = *product.Product_Name
+	}
+
+	if product.Description != nil {
+		row.Description = *product.Description
+	}
+
+	if product.Brand != nil {
+		row.Brand = *product.Brand
+	}
+
+	if product.Image != nil {
+		row.Image = *product.Image
+	}
+
+	for _, categoryID := range product.Category_IDs {
+		if slug, ok := categorySlugs[categoryID]; ok {
+			row.Categories = append(row.Categories, slug)
+		}
+	}
+
+	return row
+
+}
@@ -0,0 +1,226 @@
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+var (
+	ErrUnknownFormat = errors.New("format must be csv or jsonl")
+	ErrMalformedFile = errors.New("the file is malformed, the rest of it was skipped")
+)
+
+// one product per row, JSON Lines rows use these field names and CSV files these column headers
+// categories are referenced by slug so files can move between environments
+
+type ProductRow struct {
+	SKU          string                 `json:"sku"`
+	Product_Name string                 `json:"product_name"`
+	Description  string                 `json:"description"`
+	Brand        string                 `json:"brand"`
+	Tags         []string               `json:"tags"`
+	Price        models.Money           `json:"price"`
+	Prices       []models.Money         `json:"prices,omitempty"`
+	Image        string                 `json:"image"`
+	Categories   []string               `json:"categories"`
+	Options      []models.ProductOption `json:"options,omitempty"`
+	Variants     []models.Variant       `json:"variants,omitempty"`
+}
+
+// CSV carries the flat fields only, prices are decimals in major units and lists are "|" separated
+// eg : sku,product_name,description,brand,tags,price,currency,image,categories
+//      TEE-001,Cotton Tee,Soft tee,Acme,summer|cotton,499.00,INR,,men|tshirts
+
+var CSVHeader = []string{"sku", "product_name", "description", "brand", "tags", "price", "currency", "image", "categories"}
+
+type RowReader interface {
+	// returns io.EOF after the last row, a row error leaves the reader usable for the next row
+	Next() (ProductRow, error)
+}
+
+func NewRowReader(format string, input io.Reader) (RowReader, error) {
+
+	switch format {
+	case FormatCSV:
+		return newCSVRowReader(input)
+	case FormatJSONL:
+		return &jsonlRowReader{decoder: json.NewDecoder(input)}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+}
+
+type csvRowReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVRowReader(input io.Reader) (*csvRowReader, error) {
+
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+
+	for index, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = index
+	}
+
+	for _, required := range []string{"sku", "product_name", "price", "currency"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %q column", required)
+		}
+	}
+
+	return &csvRowReader{reader: reader, columns: columns}, nil
+
+}
+
+func splitList(value string) []string {
+
+	items := make([]string, 0)
+
+	for _, item := range strings.Split(value, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+
+}
+
+func (reader *csvRowReader) Next() (ProductRow, error) {
+
+	record, err := reader.reader.Read()
+
+	if err != nil {
+		return ProductRow{}, err
+	}
+
+	column := func(name string) string {
+		index, ok := reader.columns[name]
+		if !ok || index >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[index])
+	}
+
+	row := ProductRow{
+		SKU:          column("sku"),
+		Product_Name: column("product_name"),
+		Description:  column("description"),
+		Brand:        column("brand"),
+		Tags:         splitList(column("tags")),
+		Image:        column("image"),
+		Categories:   splitList(column("categories")),
+	}
+
+	if row.Price, err = models.ParseMoney(column("price"), column("currency")); err != nil {
+		return row, err
+	}
+
+	return row, nil
+
+}
+
+type jsonlRowReader struct {
+	decoder *json.Decoder
+}
+
+func (reader *jsonlRowReader) Next() (ProductRow, error) {
+
+	var row ProductRow
+
+	// the decoder resumes at the next value after a type error, a syntax error ends the file
+	if err := reader.decoder.Decode(&row); err != nil {
+		var syntaxError *json.SyntaxError
+		if errors.As(err, &syntaxError) {
+			return row, fmt.Errorf("%w: %v", ErrMalformedFile, err)
+		}
+		return row, err
+	}
+
+	return row, nil
+
+}
+
+type RowWriter interface {
+	Write(row ProductRow) error
+	Flush() error
+}
+
+func NewRowWriter(format string, output io.Writer) (RowWriter, error) {
+
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(output)
+		if err := writer.Write(CSVHeader); err != nil {
+			return nil, err
+		}
+		return &csvRowWriter{writer: writer}, nil
+	case FormatJSONL:
+		return &jsonlRowWriter{encoder: json.NewEncoder(output)}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+}
+
+type csvRowWriter struct {
+	writer *csv.Writer
+}
+
+func (writer *csvRowWriter) Write(row ProductRow) error {
+
+	return writer.writer.Write([]string{
+		row.SKU,
+		row.Product_Name,
+		row.Description,
+		row.Brand,
+		strings.Join(row.Tags, "|"),
+		row.Price.Decimal(),
+		row.Price.Currency,
+		row.Image,
+		strings.Join(row.Categories, "|"),
+	})
+
+}
+
+func (writer *csvRowWriter) Flush() error {
+
+	writer.writer.Flush()
+	return writer.writer.Error()
+
+}
+
+type jsonlRowWriter struct {
+	encoder *json.Encoder
+}
+
+func (writer *jsonlRowWriter) Write(row ProductRow) error {
+	return writer.encoder.Encode(row)
+}
+
+func (writer *jsonlRowWriter) Flush() error {
+	return nil
+}
@@ -1,13 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/aaravmahajanofficial/ecommerce-project/controllers"
 	"github.com/aaravmahajanofficial/ecommerce-project/database"
-	"github.com/aaravmahajanofficial/ecommerce-project/middleware"
+	"github.com/aaravmahajanofficial/ecommerce-project/health"
+	"github.com/aaravmahajanofficial/ecommerce-project/importer"
+	"github.com/aaravmahajanofficial/ecommerce-project/logging"
 	"github.com/aaravmahajanofficial/ecommerce-project/routes"
+	"github.com/aaravmahajanofficial/ecommerce-project/search"
+	"github.com/aaravmahajanofficial/ecommerce-project/tokens"
 	"github.com/gin-gonic/gin"
 )
 
@@ -20,20 +35,252 @@ func main() {
 
 	app := controllers.NewApplication(database.ProductData(database.Client, "Products"), database.UserData(database.Client, "Users"))
 
+	var indexes indexSetup
+
+	// gin's own output, eg : the registered routes, goes through the JSON logger as well
+	gin.DebugPrintRouteFunc = func(method string, path string, handler string, handlers int) {
+		slog.Debug("route registered", "method", method, "path", path, "handler", handler, "handlers", handlers)
+	}
+	gin.DebugPrintFunc = func(format string, values ...any) {
+		slog.Debug(strings.TrimSpace(fmt.Sprintf(format, values...)))
+	}
+
 	router := gin.New()
-	router.Use(gin.Logger())
-	routes.UserRoutes(router)
-
-	router.Use(middleware.Authorization())
-	router.GET("/addtocart", app.AddToCart())
-	router.GET("/removeitem", app.RemoveItem())
-	router.GET("/listcart", controllers.GetItemFromCart())
-	router.POST("/addaddress", controllers.AddAddress())
-	router.PUT("/edithomeaddress", controllers.EditHomeAddress())
-	router.PUT("/editworkaddress", controllers.EditWorkAddress())
-	router.GET("/deleteaddresses", controllers.DeleteAddress())
-	router.GET("/cartcheckout", app.BuyFromCart())
-	router.GET("/instantbuy", app.InstantBuy())
-	log.Fatal(router.Run(":" + port))
+
+	// X-Forwarded-For is only believed when it comes from one of TRUSTED_PROXIES, a comma separated list of IPs or CIDRs,
+	// otherwise any client could pick the address its rate limit is kept under
+	if err := router.SetTrustedProxies(trustedProxiesFromEnv()); err != nil {
+		log.Fatal(err)
+	}
+
+	routes.Register(router, app,
+		health.Check{Name: "mongo", Check: func(ctx context.Context) error { return database.Client.Ping(ctx, nil) }},
+		health.Check{Name: "indexes", Check: indexes.Check},
+		health.Check{Name: "signing_keys", Check: func(context.Context) error { return tokens.SigningKeyLoaded() }},
+		health.Check{Name: "suggestions", Check: health.Running(search.Suggestions.Running)},
+		health.Check{Name: "cart_reminders", Check: health.Running(controllers.CartReminders.Running)},
+	)
+
+	config := serverConfigFromEnv()
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           router,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       config.Read_Timeout,
+		WriteTimeout:      config.Write_Timeout,
+		IdleTimeout:       config.Idle_Timeout,
+	}
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	slog.Info("listening", "address", server.Addr, "log_level", logging.Level.Level().String())
+
+	// the server already answers /healthz while mongo is still starting, /readyz fails until it is reachable and indexed
+	connectErr := database.WaitConnected(signals, database.Client)
+
+	if connectErr != nil && signals.Err() == nil {
+		// every collection is used through this client, the server cannot run without it
+		slog.Error("starting without MongoDB is not possible", "error", connectErr)
+		os.Exit(1)
+	}
+
+	// the workers stop when their context is cancelled on shutdown, the wait group tells when they are done
+	workers, stopWorkers := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+
+	if connectErr == nil {
+
+		indexContext, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		// a failure is logged and retried by the readiness probe, an instance missing an index is not sent traffic
+		if err := indexes.Ensure(indexContext); err != nil {
+			slog.Error("creating the indexes failed", "error", err)
+		}
+		search.BackfillSearchGrams(indexContext, controllers.ProductsCollection)
+		cancel()
+
+		background.Add(2)
+
+		go func() {
+			defer background.Done()
+			search.Suggestions.Run(workers, controllers.ProductsCollection, controllers.CategoriesCollection, time.Minute)
+		}()
+
+		go func() {
+			defer background.Done()
+			controllers.CartReminders.Run(workers)
+		}()
+
+	}
+
+	<-signals.Done()
+	// a second signal kills the process right away
+	stopSignals()
+
+	slog.Info("shutting down, draining requests and background jobs", "deadline", config.Shutdown_Timeout.String())
+
+	drainContext, cancel := context.WithTimeout(context.Background(), config.Shutdown_Timeout)
+	defer cancel()
+
+	// stops accepting connections and waits for the requests in flight, eg : a checkout
+	if err := server.Shutdown(drainContext); err != nil {
+		slog.Warn("requests still running at the shutdown deadline", "error", err)
+	}
+
+	stopWorkers()
+
+	if err := waitContext(drainContext, background.Wait); err != nil {
+		slog.Warn("background workers still running at the shutdown deadline", "error", err)
+	}
+
+	if err := importer.Shutdown(drainContext); err != nil {
+		slog.Warn("product imports interrupted at the shutdown deadline", "error", err)
+	}
+
+	// the drain may have used up the deadline, disconnecting gets its own
+	disconnectContext, cancelDisconnect := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelDisconnect()
+
+	if err := database.Client.Disconnect(disconnectContext); err != nil {
+		slog.Error("disconnecting from MongoDB failed", "error", err)
+	}
+
+	slog.Info("server stopped")
+
+}
+
+// HTTP_READ_TIMEOUT, HTTP_WRITE_TIMEOUT and HTTP_IDLE_TIMEOUT bound a single connection, SHUTDOWN_TIMEOUT is how long
+// requests and background jobs get to finish once SIGTERM or SIGINT arrives
+
+type serverConfig struct {
+	Read_Timeout     time.Duration
+	Write_Timeout    time.Duration
+	Idle_Timeout     time.Duration
+	Shutdown_Timeout time.Duration
+}
+
+func serverConfigFromEnv() serverConfig {
+
+	config := serverConfig{
+		Read_Timeout:     30 * time.Second,
+		Write_Timeout:    2 * time.Minute,
+		Idle_Timeout:     2 * time.Minute,
+		Shutdown_Timeout: 25 * time.Second,
+	}
+
+	for name, setting := range map[string]*time.Duration{
+		"HTTP_READ_TIMEOUT":  &config.Read_Timeout,
+		"HTTP_WRITE_TIMEOUT": &config.Write_Timeout,
+		"HTTP_IDLE_TIMEOUT":  &config.Idle_Timeout,
+		"SHUTDOWN_TIMEOUT":   &config.Shutdown_Timeout,
+	} {
+		if value, err := time.ParseDuration(os.Getenv(name)); err == nil && value > 0 {
+			*setting = value
+		}
+	}
+
+	return config
+
+}
+
+// nil, not an empty list, when nothing is configured, gin then uses the address of the connection
+
+func trustedProxiesFromEnv() []string {
+
+	var proxies []string
+
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+
+}
+
+// the indexes are created once mongo is reachable, a failed attempt is made again by the readiness probe until one succeeds
+
+type indexSetup struct {
+	mutex     sync.Mutex
+	attempted atomic.Bool
+	done      atomic.Bool
+}
+
+var (
+	errIndexSetupPending = errors.New("waiting for MongoDB")
+	errIndexSetupRunning = errors.New("index setup is still running")
+)
+
+// the readiness check, it leaves the first attempt to startup, so probes do not try before mongo is reachable
+
+func (setup *indexSetup) Check(ctx context.Context) error {
+
+	if !setup.attempted.Load() {
+		return errIndexSetupPending
+	}
+
+	return setup.Ensure(ctx)
+
+}
+
+func (setup *indexSetup) Ensure(ctx context.Context) error {
+
+	if setup.done.Load() {
+		return nil
+	}
+
+	// a probe arriving while another attempt runs does not start a second one
+	if !setup.mutex.TryLock() {
+		return errIndexSetupRunning
+	}
+
+	defer setup.mutex.Unlock()
+	setup.attempted.Store(true)
+
+	if setup.done.Load() {
+		return nil
+	}
+
+	err := errors.Join(
+		database.EnsureCategoryIndexes(ctx, controllers.CategoriesCollection),
+		database.EnsureProductIndexes(ctx, controllers.ProductsCollection),
+		search.EnsureSearchIndexes(ctx, controllers.ProductsCollection),
+		database.EnsureReviewIndexes(ctx, controllers.ReviewsCollection),
+		database.EnsureWishlistIndexes(ctx, controllers.WishlistsCollection),
+		database.EnsureGuestCartIndexes(ctx, controllers.GuestCartsCollection),
+		database.EnsureAbandonedCartIndexes(ctx, controllers.UserCollection),
+	)
+
+	setup.done.Store(err == nil)
+
+	return err
+
+}
+
+// runs wait until it returns or the context is done, whichever comes first
+
+func waitContext(ctx context.Context, wait func()) error {
+
+	done := make(chan struct{})
+
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
 }
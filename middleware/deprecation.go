@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the date the legacy routes are removed, eg : "Wed, 01 Jul 2026 00:00:00 GMT", announced only when set
+var LegacySunset = os.Getenv("LEGACY_ROUTES_SUNSET")
+
+// legacy routes keep working until they are removed, their responses point clients at the /api/v1 replacement
+
+func Deprecated(successor string) gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		ctx.Header("Deprecation", "true")
+		ctx.Header("Link", "<"+successor+">; rel=\"successor-version\"")
+
+		if LegacySunset != "" {
+			ctx.Header("Sunset", LegacySunset)
+		}
+
+		ctx.Next()
+
+	}
+
+}
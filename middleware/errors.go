@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/gin-gonic/gin"
+)
+
+// writes the error envelope for whatever error the handlers left on the context,
+// must be registered before every route so it runs last
+
+func ErrorHandler() gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		ctx.Next()
+
+		if ctx.Writer.Written() {
+			return
+		}
+
+		var apiError *apierror.Error
+
+		switch {
+		case len(ctx.Errors) > 0:
+			apiError = apierror.From(ctx.Errors.Last().Err)
+		case ctx.Writer.Status() >= http.StatusBadRequest:
+			// handlers that only set a status, and unknown routes, still get a body eg : NOT_FOUND
+			status := ctx.Writer.Status()
+			apiError = apierror.New(status, strings.ToUpper(strings.ReplaceAll(http.StatusText(status), " ", "_")), http.StatusText(status))
+		default:
+			return
+		}
+
+		apiError.Request_ID = ctx.GetString("Request_ID")
+		ctx.JSON(apiError.Status, apierror.Envelope{Error: apiError})
+
+	}
+
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// one line per request, written once the response is complete, eg :
+// {"level":"INFO","msg":"request","request_id":"...","route":"/api/v1/cart","user_id":"...","status":200,"duration_ms":12}
+// the paths of probes that call every few seconds are only logged at debug level
+
+func RequestLogger(quietPaths ...string) gin.HandlerFunc {
+
+	quiet := make(map[string]bool, len(quietPaths))
+
+	for _, path := range quietPaths {
+		quiet[path] = true
+	}
+
+	return func(ctx *gin.Context) {
+
+		startedAt := time.Now()
+
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		level := slog.LevelInfo
+
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		case quiet[ctx.Request.URL.Path]:
+			level = slog.LevelDebug
+		}
+
+		attrs := []any{
+			"path", ctx.Request.URL.Path,
+			"status", status,
+			"duration_ms", time.Since(startedAt).Milliseconds(),
+			"bytes", ctx.Writer.Size(),
+			"client_ip", ctx.ClientIP(),
+		}
+
+		if len(ctx.Errors) > 0 {
+			attrs = append(attrs, "error", ctx.Errors.Last().Err)
+		}
+
+		// the query string is left out, legacy routes carry ids and tokens in it
+		slog.Log(ctx.Request.Context(), level, "request", attrs...)
+
+	}
+
+}
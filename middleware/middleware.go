@@ -1,9 +1,9 @@
 package middleware
 
 import (
-	"errors"
-	"net/http"
-
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/logging"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
 	token "github.com/aaravmahajanofficial/ecommerce-project/tokens"
 
 	"github.com/gin-gonic/gin"
@@ -16,7 +16,8 @@ func Authorization() gin.HandlerFunc {
 		ClientToken := ctx.Request.Header.Get("Token")
 
 		if ClientToken == "" {
-			ctx.AbortWithError(http.StatusInternalServerError, errors.New("No authorization header provided"))
+			ctx.Error(apierror.Unauthorized("TOKEN_MISSING", "No authorization header provided"))
+			ctx.Abort()
 			return
 		}
 
@@ -24,13 +25,36 @@ func Authorization() gin.HandlerFunc {
 
 		if err != "" {
 
-			ctx.AbortWithError(http.StatusInternalServerError, errors.New("Token validation failed"))
+			ctx.Error(apierror.Unauthorized("TOKEN_INVALID", "Token validation failed"))
+			ctx.Abort()
 			return
 
 		}
 
 		ctx.Set("Email", claims.Email)
 		ctx.Set("UID", claims.UID)
+		ctx.Set("Role", claims.Role)
+		ctx.Set("First_Name", claims.First_Name)
+		ctx.Set("Last_Name", claims.Last_Name)
+		ctx.Request = ctx.Request.WithContext(logging.With(ctx.Request.Context(), "user_id", claims.UID))
+		ctx.Next()
+
+	}
+
+}
+
+// must be registered after Authorization, it relies on the claims set there
+
+func AdminAuthorization() gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		if ctx.GetString("Role") != models.RoleAdmin {
+			ctx.Error(apierror.Forbidden("ADMIN_REQUIRED", "Admin access required"))
+			ctx.Abort()
+			return
+		}
+
 		ctx.Next()
 
 	}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/gin-gonic/gin"
+)
+
+// a token bucket per client IP, refilled continuously at ratePerSecond up to burst tokens
+
+type ipBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type IPRateLimiter struct {
+	mutex         sync.Mutex
+	buckets       map[string]*ipBucket
+	ratePerSecond float64
+	burst         float64
+	lastSweep     time.Time
+}
+
+func NewIPRateLimiter(ratePerSecond float64, burst int) *IPRateLimiter {
+
+	return &IPRateLimiter{
+		buckets:       make(map[string]*ipBucket),
+		ratePerSecond: ratePerSecond,
+		burst:         float64(burst),
+		lastSweep:     time.Now(),
+	}
+}
+
+// returns whether the request may go ahead, and otherwise how long until the next token
+
+func (limiter *IPRateLimiter) Allow(ip string) (bool, time.Duration) {
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	limiter.sweep(now)
+
+	bucket, ok := limiter.buckets[ip]
+
+	if !ok {
+		bucket = &ipBucket{tokens: limiter.burst, lastSeen: now}
+		limiter.buckets[ip] = bucket
+	}
+
+	bucket.tokens = math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*limiter.ratePerSecond)
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / limiter.ratePerSecond * float64(time.Second))
+		return false, wait
+	}
+
+	bucket.tokens--
+
+	return true, 0
+
+}
+
+// buckets idle long enough to be full again carry no state, dropping them keeps the map bounded
+
+func (limiter *IPRateLimiter) sweep(now time.Time) {
+
+	idle := time.Duration(limiter.burst / limiter.ratePerSecond * float64(time.Second))
+
+	if now.Sub(limiter.lastSweep) < idle || now.Sub(limiter.lastSweep) < time.Minute {
+		return
+	}
+
+	for ip, bucket := range limiter.buckets {
+		if now.Sub(bucket.lastSeen) > idle {
+			delete(limiter.buckets, ip)
+		}
+	}
+
+	limiter.lastSweep = now
+
+}
+
+func RateLimit(limiter *IPRateLimiter) gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		allowed, wait := limiter.Allow(ctx.ClientIP())
+
+		if !allowed {
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			ctx.Error(apierror.New(http.StatusTooManyRequests, "RATE_LIMITED", "Too many requests"))
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+
+	}
+
+}
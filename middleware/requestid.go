@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/logging"
+	"github.com/gin-gonic/gin"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// ids from the client or a proxy are kept when they look sane, anything else is replaced
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+func newRequestID() string {
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+
+}
+
+// every request carries an id, it is echoed in the response header, in error bodies and in the log
+
+func RequestID() gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		requestID := ctx.GetHeader(RequestIDHeader)
+
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+
+		ctx.Set("Request_ID", requestID)
+		ctx.Header(RequestIDHeader, requestID)
+
+		// every line logged with the request's context carries the id and the route, eg : "/api/v1/cart/items/:id"
+		ctx.Request = ctx.Request.WithContext(logging.With(ctx.Request.Context(), "request_id", requestID, "method", ctx.Request.Method, "route", ctx.FullPath()))
+		ctx.Next()
+
+	}
+
+}
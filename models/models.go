@@ -9,51 +9,348 @@ import (
 //  *string used for ensuring that field can be nullable
 
 type User struct {
-	ID              primitive.ObjectID `json:"_id" bson:"_id"`
-	First_Name      *string            `json:"first_name" validate:"required,min=2,max=30"`
-	Last_Name       *string            `json:"last_name"  validate:"required,min=2,max=30"`
-	Password        *string            `json:"password"   validate:"required,min=6"`
-	Email           *string            `json:"email"      validate:"email,required"`
-	Phone           *string            `json:"phone"      validate:"required"`
-	Token           *string            `json:"token" bson:"token"`
-	Refresh_Token   *string            `json:"refresh_token" bson:"refresh_token"`
-	Created_At      time.Time          `json:"created_at"`
-	Updated_At      time.Time          `json:"updated_at"`
-	User_ID         string             `json:"user_id" bson:"user_id"`
-	UserCart        []ProductUser      `json:"usercart" bson:"usercart"`
-	Address_Details []Address          `json:"address" bson:"address"`
-	Order_Status    []Order            `json:"orders" bson:"orders"`
+	ID               primitive.ObjectID `json:"_id" bson:"_id"`
+	First_Name       *string            `json:"first_name" validate:"required,min=2,max=30"`
+	Last_Name        *string            `json:"last_name"  validate:"required,min=2,max=30"`
+	Password         *string            `json:"password"   validate:"required,min=6"`
+	Email            *string            `json:"email"      validate:"email,required"`
+	Phone            *string            `json:"phone"      validate:"required"`
+	Token            *string            `json:"token" bson:"token"`
+	Refresh_Token    *string            `json:"refresh_token" bson:"refresh_token"`
+	Created_At       time.Time          `json:"created_at"`
+	Updated_At       time.Time          `json:"updated_at"`
+	User_ID          string             `json:"user_id" bson:"user_id"`
+	Role             string             `json:"role" bson:"role"`
+	UserCart         []ProductUser      `json:"usercart" bson:"usercart"`
+	Cart_Warnings    []CartWarning      `json:"cart_warnings" bson:"cart_warnings"`
+	Cart_Updated_At  *time.Time         `json:"cart_updated_at" bson:"cart_updated_at,omitempty"`
+	Cart_Reminded_At *time.Time         `json:"-" bson:"cart_reminded_at,omitempty"`
+	Cart_Reminders   int                `json:"-" bson:"cart_reminders,omitempty"`
+	// a reminder that could not be sent is retried after a backoff that grows with every failure
+	Cart_Reminder_Failures int        `json:"-" bson:"cart_reminder_failures,omitempty"`
+	Cart_Reminder_Retry_At *time.Time `json:"-" bson:"cart_reminder_retry_at,omitempty"`
+	// how many of the user's carts were ever reminded about, cart_reminders starts over with every cart change
+	Reminded_Carts_Total int       `json:"-" bson:"reminded_carts_total,omitempty"`
+	Address_Details      []Address `json:"address" bson:"address"`
+	Order_Status         []Order   `json:"orders" bson:"orders"`
 }
+
+// Price is always in the base currency so products compare by price, Prices holds fixed prices in other currencies
+
 type Product struct {
-	Product_ID   primitive.ObjectID `json:"_id" bson:"_id"`
-	Product_Name *string            `json:"product_name" bson:"product_name"`
-	Price        *uint64            `json:"price" bson:"price"`
-	Rating       *uint8             `json:"rating" bson:"rating"`
-	Image        *string            `json:"image" bson:"image"`
+	Product_ID   primitive.ObjectID   `json:"_id" bson:"_id"`
+	SKU          string               `json:"sku" bson:"sku,omitempty" validate:"omitempty,max=64"`
+	Product_Name *string              `json:"product_name" bson:"product_name" validate:"required,min=2,max=200"`
+	Description  *string              `json:"description" bson:"description" validate:"omitempty,max=5000"`
+	Brand        *string              `json:"brand" bson:"brand"`
+	Tags         []string             `json:"tags" bson:"tags" validate:"max=30,dive,max=40"`
+	Price        Money                `json:"price" bson:"price"`
+	Prices       []Money              `json:"prices" bson:"prices"`
+	Rating       *float64             `json:"rating" bson:"rating"`
+	Review_Count int                  `json:"review_count" bson:"review_count"`
+	Rating_Total int                  `json:"-" bson:"rating_total"`
+	Image        *string              `json:"image" bson:"image"`
+	Images       []ProductImage       `json:"images" bson:"images"`
+	Category_IDs []primitive.ObjectID `json:"category_ids" bson:"category_ids"`
+	Options      []ProductOption      `json:"options" bson:"options" validate:"dive"`
+	Variants     []Variant            `json:"variants" bson:"variants" validate:"dive"`
+	Search_Grams []string             `json:"-" bson:"search_grams,omitempty"`
+}
+
+// options are the axes a product varies on, eg : {Name: "size", Values: ["S", "M", "L"]}
+
+type ProductOption struct {
+	Name   string   `json:"name" bson:"name" validate:"required,max=30"`
+	Values []string `json:"values" bson:"values" validate:"required,min=1,dive,required,max=30"`
+}
+
+// an uploaded image with its derivatives, Image on the product always mirrors the first one
+// keys address the storage backend, urls are what clients load
+
+type ProductImage struct {
+	Image_ID      primitive.ObjectID `json:"_id" bson:"_id"`
+	Position      int                `json:"position" bson:"position"`
+	Alt           string             `json:"alt" bson:"alt"`
+	Content_Type  string             `json:"content_type" bson:"content_type"`
+	Width         int                `json:"width" bson:"width"`
+	Height        int                `json:"height" bson:"height"`
+	Original_Key  string             `json:"-" bson:"original_key"`
+	Medium_Key    string             `json:"-" bson:"medium_key"`
+	Thumbnail_Key string             `json:"-" bson:"thumbnail_key"`
+	Original_URL  string             `json:"original_url" bson:"original_url"`
+	Medium_URL    string             `json:"medium_url" bson:"medium_url"`
+	Thumbnail_URL string             `json:"thumbnail_url" bson:"thumbnail_url"`
+	Uploaded_At   time.Time          `json:"uploaded_at" bson:"uploaded_at"`
+}
+
+// every variant is a purchasable SKU, its options pick one value for each option of the product
+// a variant without its own price is sold at the product price
+
+type Variant struct {
+	Variant_ID primitive.ObjectID `json:"_id" bson:"_id"`
+	SKU        string             `json:"sku" bson:"sku" validate:"required,max=64"`
+	Options    map[string]string  `json:"options" bson:"options"`
+	Price      Money              `json:"price" bson:"price"`
+	Prices     []Money            `json:"prices" bson:"prices"`
+	Stock      int                `json:"stock" bson:"stock" validate:"min=0"`
+	Images     []string           `json:"images" bson:"images"`
+	Barcode    string             `json:"barcode" bson:"barcode"`
+}
+
+func (product Product) FindVariant(variantID primitive.ObjectID) (Variant, bool) {
+
+	for _, variant := range product.Variants {
+		if variant.Variant_ID == variantID {
+			return variant, true
+		}
+	}
+
+	return Variant{}, false
+
 }
+
 type ProductUser struct {
-	Product_ID   primitive.ObjectID `bson:"_id"`
-	Product_Name *string            `json:"product_name" bson:"product_name"`
-	Price        int                `json:"price"  bson:"price"`
-	Rating       *uint              `json:"rating" bson:"rating"`
-	Image        *string            `json:"image"  bson:"image"`
+	Product_ID   primitive.ObjectID  `bson:"_id"`
+	Product_Name *string             `json:"product_name" bson:"product_name"`
+	Price        Money               `json:"price"  bson:"price"`
+	Prices       []Money             `json:"prices" bson:"prices"`
+	Rating       *float64            `json:"rating" bson:"rating"`
+	Image        *string             `json:"image"  bson:"image"`
+	Variant_ID   *primitive.ObjectID `json:"variant_id" bson:"variant_id,omitempty"`
+	SKU          string              `json:"sku" bson:"sku,omitempty"`
+	Options      map[string]string   `json:"options" bson:"options,omitempty"`
+}
+
+// what changed in the cart since an item was added, checkout is blocked until the customer acknowledges them
+
+const (
+	CartPriceChanged      = "PRICE_CHANGED"
+	CartProductRemoved    = "PRODUCT_REMOVED"
+	CartVariantRemoved    = "VARIANT_REMOVED"
+	CartOutOfStock        = "OUT_OF_STOCK"
+	CartInsufficientStock = "INSUFFICIENT_STOCK"
+)
+
+type CartWarning struct {
+	Code            string              `json:"code" bson:"code"`
+	Message         string              `json:"message" bson:"message"`
+	Product_ID      primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	Variant_ID      *primitive.ObjectID `json:"variant_id" bson:"variant_id,omitempty"`
+	Product_Name    *string             `json:"product_name" bson:"product_name"`
+	Old_Price       *Money              `json:"old_price,omitempty" bson:"old_price,omitempty"`
+	New_Price       *Money              `json:"new_price,omitempty" bson:"new_price,omitempty"`
+	Available_Stock *int                `json:"available_stock,omitempty" bson:"available_stock,omitempty"`
+}
+
+// carts of visitors without an account, they expire unless the visitor keeps using them
+
+type GuestCart struct {
+	Cart_ID    primitive.ObjectID `json:"_id" bson:"_id"`
+	Items      []ProductUser      `json:"items" bson:"items"`
+	Created_At time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At time.Time          `json:"updated_at" bson:"updated_at"`
+	Expires_At time.Time          `json:"expires_at" bson:"expires_at"`
 }
+
+// a cart is abandoned once it has been left untouched for longer than the configured threshold
+
+type AbandonedCartMetrics struct {
+	Idle_Threshold  string `json:"idle_threshold"`
+	Active_Carts    int    `json:"active_carts"`
+	Abandoned_Carts int    `json:"abandoned_carts"`
+	Reminded_Carts  int    `json:"reminded_carts"`
+	// every cart reminded about so far, the base of the recovery rate together with the recovered orders
+	Reminded_Carts_Total int     `json:"reminded_carts_total"`
+	Recovered_Orders     int     `json:"recovered_orders"`
+	Recovery_Rate        float64 `json:"recovery_rate"`
+	Abandoned_Value      []Money `json:"abandoned_value"`
+}
+
+// an entry of the address book, at most one address of a user carries each default flag
+// city, district and state are filled in from the postal code, Serviceable tells whether orders can be delivered there
+
 type Address struct {
-	Address_id primitive.ObjectID `bson:"_id"`
-	House      *string            `json:"house_name" bson:"house_name"`
-	Street     *string            `json:"street_name" bson:"street_name"`
-	City       *string            `json:"city_name" bson:"city_name"`
-	Pincode    *string            `json:"pin_code" bson:"pin_code"`
+	Address_id          primitive.ObjectID `bson:"_id"`
+	Label               *string            `json:"label" bson:"label" validate:"omitempty,max=40"`
+	House               *string            `json:"house_name" bson:"house_name" validate:"required,max=100"`
+	Street              *string            `json:"street_name" bson:"street_name" validate:"required,max=200"`
+	City                *string            `json:"city_name" bson:"city_name" validate:"omitempty,max=100"`
+	District            *string            `json:"district" bson:"district"`
+	State               *string            `json:"state" bson:"state"`
+	Pincode             *string            `json:"pin_code" bson:"pin_code" validate:"required,max=20"`
+	Country             string             `json:"country" bson:"country" validate:"omitempty,len=2"`
+	Serviceable         bool               `json:"serviceable" bson:"serviceable"`
+	Is_Default_Shipping bool               `json:"is_default_shipping" bson:"is_default_shipping"`
+	Is_Default_Billing  bool               `json:"is_default_billing" bson:"is_default_billing"`
 }
 type Order struct {
 	Order_ID       primitive.ObjectID `bson:"_id"`
 	Order_Cart     []ProductUser      `json:"order_list"  bson:"order_list"`
 	Orderered_At   time.Time          `json:"ordered_on"  bson:"ordered_on"`
-	Price          int                `json:"total_price" bson:"total_price"`
+	Price          Money              `json:"total_price" bson:"total_price"`
 	Discount       *int               `json:"discount"    bson:"discount"`
 	Payment_Method Payment            `json:"payment_method" bson:"payment_method"`
+	Fulfillment    string             `json:"fulfillment_status" bson:"fulfillment_status"`
+	Recovered_Cart bool               `json:"recovered_cart" bson:"recovered_cart,omitempty"`
+	// units of each product, by hex id, already packed in a shipment, claimed before a shipment is created
+	Packed_Quantities map[string]int `json:"-" bson:"packed_quantities,omitempty"`
+	// copies of the address book entries at checkout, later edits of the book never reach the order
+	Shipping_Address *Address `json:"shipping_address" bson:"shipping_address,omitempty"`
+	Billing_Address  *Address `json:"billing_address" bson:"billing_address,omitempty"`
 }
 type Payment struct {
 	Digital bool `json:"digital" bson:"digital"`
 	COD     bool `json:"cod"     bson:"cod"`
 }
+
+// roles a user can hold, ADMIN is only granted directly in the database
+
+const (
+	RoleUser  = "USER"
+	RoleAdmin = "ADMIN"
+)
+
+// fulfillment states of an order, derived from its shipments
+
+const (
+	FulfillmentUnfulfilled      = "UNFULFILLED"
+	FulfillmentPartiallyShipped = "PARTIALLY_SHIPPED"
+	FulfillmentShipped          = "SHIPPED"
+	FulfillmentDelivered        = "DELIVERED"
+)
+
+// states of a single shipment, PACKED until handed to the carrier
+
+const (
+	ShipmentPacked         = "PACKED"
+	ShipmentShipped        = "SHIPPED"
+	ShipmentInTransit      = "IN_TRANSIT"
+	ShipmentOutForDelivery = "OUT_FOR_DELIVERY"
+	ShipmentDelivered      = "DELIVERED"
+	ShipmentException      = "EXCEPTION"
+)
+
+type Shipment struct {
+	Shipment_ID     primitive.ObjectID `json:"_id" bson:"_id"`
+	Order_ID        primitive.ObjectID `json:"order_id" bson:"order_id"`
+	User_ID         string             `json:"user_id" bson:"user_id"`
+	Carrier         string             `json:"carrier" bson:"carrier"`
+	Tracking_Number string             `json:"tracking_number" bson:"tracking_number"`
+	Items           []ShipmentItem     `json:"items" bson:"items"`
+	Status          string             `json:"status" bson:"status"`
+	Events          []TrackingEvent    `json:"events" bson:"events"`
+	Created_At      time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At      time.Time          `json:"updated_at" bson:"updated_at"`
+	Shipped_At      *time.Time         `json:"shipped_at" bson:"shipped_at"`
+	Delivered_At    *time.Time         `json:"delivered_at" bson:"delivered_at"`
+}
+type Category struct {
+	Category_ID primitive.ObjectID   `json:"_id" bson:"_id"`
+	Name        *string              `json:"name" bson:"name" validate:"required,min=2,max=60"`
+	Slug        string               `json:"slug" bson:"slug"`
+	Description *string              `json:"description" bson:"description" validate:"omitempty,max=1000"`
+	Parent_ID   *primitive.ObjectID  `json:"parent_id" bson:"parent_id"`
+	Ancestors   []primitive.ObjectID `json:"ancestors" bson:"ancestors"`
+	Created_At  time.Time            `json:"created_at" bson:"created_at"`
+	Updated_At  time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+// a CategoryNode is a category with its children, only used when returning the tree
+
+type CategoryNode struct {
+	Category
+	Children []*CategoryNode `json:"children"`
+}
+
+// new reviews are PUBLISHED straight away, moderators can reject them later
+
+const (
+	ReviewPublished = "PUBLISHED"
+	ReviewRejected  = "REJECTED"
+)
+
+type Review struct {
+	Review_ID       primitive.ObjectID `json:"_id" bson:"_id"`
+	Product_ID      primitive.ObjectID `json:"product_id" bson:"product_id"`
+	User_ID         string             `json:"user_id" bson:"user_id"`
+	Author_Name     string             `json:"author_name" bson:"author_name"`
+	Stars           int                `json:"stars" bson:"stars" validate:"required,min=1,max=5"`
+	Title           *string            `json:"title" bson:"title" validate:"required,min=2,max=120"`
+	Body            *string            `json:"body" bson:"body" validate:"required,min=2,max=5000"`
+	Status          string             `json:"status" bson:"status"`
+	Moderation_Note string             `json:"moderation_note,omitempty" bson:"moderation_note,omitempty"`
+	Helpful_Votes   int                `json:"helpful_votes" bson:"helpful_votes"`
+	Voter_IDs       []string           `json:"-" bson:"voter_ids"`
+	Created_At      time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At      time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// import jobs run in the background, their document is the progress and the error report
+
+const (
+	ImportQueued    = "QUEUED"
+	ImportRunning   = "RUNNING"
+	ImportCompleted = "COMPLETED"
+	ImportFailed    = "FAILED"
+)
+
+type ImportJob struct {
+	Job_ID      primitive.ObjectID `json:"_id" bson:"_id"`
+	Format      string             `json:"format" bson:"format"`
+	Status      string             `json:"status" bson:"status"`
+	Processed   int                `json:"processed" bson:"processed"`
+	Inserted    int                `json:"inserted" bson:"inserted"`
+	Updated     int                `json:"updated" bson:"updated"`
+	Failed      int                `json:"failed" bson:"failed"`
+	Errors      []ImportRowError   `json:"errors" bson:"errors"`
+	Message     string             `json:"message,omitempty" bson:"message,omitempty"`
+	Created_At  time.Time          `json:"created_at" bson:"created_at"`
+	Started_At  *time.Time         `json:"started_at" bson:"started_at"`
+	Finished_At *time.Time         `json:"finished_at" bson:"finished_at"`
+}
+type ImportRowError struct {
+	Row     int    `json:"row" bson:"row"`
+	SKU     string `json:"sku" bson:"sku"`
+	Message string `json:"message" bson:"message"`
+}
+
+// every user has a default wishlist, items remember the price they were saved at
+
+const DefaultWishlistName = "Saved for later"
+
+type Wishlist struct {
+	Wishlist_ID primitive.ObjectID `json:"_id" bson:"_id"`
+	User_ID     string             `json:"-" bson:"user_id"`
+	Name        *string            `json:"name" bson:"name" validate:"required,min=1,max=60"`
+	Is_Default  bool               `json:"is_default" bson:"is_default"`
+	Share_Token *string            `json:"share_token" bson:"share_token,omitempty"`
+	Items       []WishlistItem     `json:"items" bson:"items"`
+	Created_At  time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At  time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// Current_Price and Price_Dropped are filled in when the list is read, they are never stored
+
+type WishlistItem struct {
+	Product_ID    primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	Variant_ID    *primitive.ObjectID `json:"variant_id" bson:"variant_id,omitempty"`
+	SKU           string              `json:"sku" bson:"sku,omitempty"`
+	Options       map[string]string   `json:"options" bson:"options,omitempty"`
+	Product_Name  *string             `json:"product_name" bson:"product_name"`
+	Image         *string             `json:"image" bson:"image"`
+	Saved_Price   Money               `json:"saved_price" bson:"saved_price"`
+	Added_At      time.Time           `json:"added_at" bson:"added_at"`
+	Current_Price *Money              `json:"current_price" bson:"-"`
+	Price_Dropped bool                `json:"price_dropped" bson:"-"`
+	Available     bool                `json:"available" bson:"-"`
+}
+type ShipmentItem struct {
+	Product_ID primitive.ObjectID `json:"product_id" bson:"product_id" validate:"required"`
+	Quantity   int                `json:"quantity" bson:"quantity" validate:"min=1"`
+}
+type TrackingEvent struct {
+	Status      string    `json:"status" bson:"status"`
+	Description string    `json:"description" bson:"description"`
+	Location    string    `json:"location" bson:"location"`
+	Occurred_At time.Time `json:"occurred_at" bson:"occurred_at"`
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// amounts are always stored in minor units (paise, cents), so no floating point rounding ever reaches an order total
+
+type Money struct {
+	Amount   int64  `json:"amount" bson:"amount"`
+	Currency string `json:"currency" bson:"currency"`
+}
+
+// documents written before prices carried a currency stored rupees, whatever the base currency is now
+const LegacyCurrency = "INR"
+
+// number of minor units digits for every currency we accept
+
+var currencyExponents = map[string]int{
+	"INR": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"AED": 2,
+	"SGD": 2,
+	"AUD": 2,
+	"CAD": 2,
+	"JPY": 0,
+}
+
+func IsSupportedCurrency(code string) bool {
+
+	_, ok := currencyExponents[code]
+	return ok
+
+}
+
+func CurrencyExponent(code string) int {
+
+	exponent, ok := currencyExponents[code]
+
+	if !ok {
+		return 2
+	}
+
+	return exponent
+
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+func (money Money) IsZero() bool {
+	return money.Amount == 0
+}
+
+func (money Money) Add(other Money) (Money, error) {
+
+	if money.Currency == "" {
+		return other, nil
+	}
+
+	if other.Currency != "" && other.Currency != money.Currency {
+		return Money{}, fmt.Errorf("cannot add %s to %s", other.Currency, money.Currency)
+	}
+
+	return Money{Amount: money.Amount + other.Amount, Currency: money.Currency}, nil
+
+}
+
+func (money Money) Multiply(quantity int) Money {
+	return Money{Amount: money.Amount * int64(quantity), Currency: money.Currency}
+}
+
+// eg : {Amount: 129950, Currency: "INR"} -> "1299.50 INR"
+
+func (money Money) String() string {
+
+	exponent := CurrencyExponent(money.Currency)
+
+	if exponent == 0 {
+		return fmt.Sprintf("%d %s", money.Amount, money.Currency)
+	}
+
+	divisor := int64(math.Pow10(exponent))
+	sign := ""
+	amount := money.Amount
+
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/divisor, exponent, amount%divisor, money.Currency)
+
+}
+
+var ErrInvalidAmount = errors.New("amount is not a valid decimal for the currency")
+
+// parses a decimal amount in major units without going through floats
+// eg : ("1299.5", "INR") -> {Amount: 129950, Currency: "INR"}
+
+func ParseMoney(amount string, currency string) (Money, error) {
+
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+
+	if !IsSupportedCurrency(currency) {
+		return Money{}, fmt.Errorf("currency %q is not supported", currency)
+	}
+
+	exponent := CurrencyExponent(currency)
+	whole, fraction, _ := strings.Cut(strings.TrimSpace(amount), ".")
+
+	if whole == "" || len(fraction) > exponent || strings.HasPrefix(whole, "-") || strings.HasPrefix(whole, "+") {
+		return Money{}, ErrInvalidAmount
+	}
+
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	value, err := strconv.ParseInt(whole+fraction, 10, 64)
+
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+
+	return Money{Amount: value, Currency: currency}, nil
+
+}
+
+// the amount in major units without the currency, the inverse of ParseMoney
+// eg : {Amount: 129950, Currency: "INR"} -> "1299.50"
+
+func (money Money) Decimal() string {
+	return strings.TrimSuffix(money.String(), " "+money.Currency)
+}
+
+// older documents stored prices as a bare number of whole units in the legacy currency
+
+func (money *Money) UnmarshalBSONValue(valueType bsontype.Type, data []byte) error {
+
+	raw := bson.RawValue{Type: valueType, Value: data}
+	scale := math.Pow10(CurrencyExponent(LegacyCurrency))
+
+	switch valueType {
+	case bsontype.Int32:
+		*money = Money{Amount: int64(raw.Int32()) * int64(scale), Currency: LegacyCurrency}
+	case bsontype.Int64:
+		*money = Money{Amount: raw.Int64() * int64(scale), Currency: LegacyCurrency}
+	case bsontype.Double:
+		*money = Money{Amount: int64(math.Round(raw.Double() * scale)), Currency: LegacyCurrency}
+	case bsontype.Null, bsontype.Undefined:
+		*money = Money{}
+	case bsontype.EmbeddedDocument:
+		type plain Money
+		var decoded plain
+		if err := raw.Unmarshal(&decoded); err != nil {
+			return err
+		}
+		*money = Money(decoded)
+	default:
+		return fmt.Errorf("cannot decode %s into Money", valueType)
+	}
+
+	return nil
+
+}
@@ -0,0 +1,49 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+var ErrDriverNotAvailable = errors.New("notification driver is not available")
+
+// kinds of notification sent to customers
+const (
+	KindAbandonedCart = "ABANDONED_CART"
+)
+
+type Notification struct {
+	Kind       string            `json:"kind"`
+	User_ID    string            `json:"user_id"`
+	Email      string            `json:"email"`
+	Subject    string            `json:"subject"`
+	Body       string            `json:"body"`
+	Data       map[string]string `json:"data,omitempty"`
+	Created_At time.Time         `json:"created_at"`
+}
+
+// reminders and other customer messages go through this, an email or push provider only has to implement Send
+
+type Notifier interface {
+	Name() string
+	Send(ctx context.Context, notification Notification) error
+}
+
+// NOTIFIER_DRIVER picks the backend, "outbox" (default) appends every notification to OUTBOX_FILE
+
+func NewFromEnv() (Notifier, error) {
+
+	switch os.Getenv("NOTIFIER_DRIVER") {
+	case "", "outbox":
+		path := os.Getenv("OUTBOX_FILE")
+		if path == "" {
+			path = "outbox/notifications.jsonl"
+		}
+		return NewLocalOutbox(path)
+	default:
+		return nil, ErrDriverNotAvailable
+	}
+
+}
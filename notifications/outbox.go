@@ -0,0 +1,61 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// nothing is delivered, notifications are appended to a JSON Lines file to be inspected or relayed by another process
+
+type LocalOutbox struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func NewLocalOutbox(path string) (*LocalOutbox, error) {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	return &LocalOutbox{path: path}, nil
+
+}
+
+func (outbox *LocalOutbox) Name() string {
+	return "outbox"
+}
+
+func (outbox *LocalOutbox) Send(ctx context.Context, notification Notification) error {
+
+	if notification.Created_At.IsZero() {
+		notification.Created_At = time.Now()
+	}
+
+	line, err := json.Marshal(notification)
+
+	if err != nil {
+		return err
+	}
+
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	file, err := os.OpenFile(outbox.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+
+}
@@ -0,0 +1,58 @@
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"html"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed docs/index.html
+var docsPage []byte
+
+// the document never changes once the routes are registered, it is encoded on the first request only
+
+func Handler(document *Document) gin.HandlerFunc {
+
+	var (
+		once    sync.Once
+		encoded []byte
+		err     error
+	)
+
+	return func(ctx *gin.Context) {
+
+		once.Do(func() {
+			encoded, err = json.Marshal(document)
+		})
+
+		if err != nil {
+			slog.ErrorContext(ctx.Request.Context(), "encoding the OpenAPI document failed", "error", err)
+			ctx.Error(err)
+			return
+		}
+
+		ctx.Data(http.StatusOK, "application/json; charset=utf-8", encoded)
+
+	}
+
+}
+
+// a self contained page that renders the document served at specPath and can send requests from the browser
+
+func Docs(specPath string) gin.HandlerFunc {
+
+	page := bytes.ReplaceAll(docsPage, []byte("{{spec_path}}"), []byte(html.EscapeString(specPath)))
+
+	return func(ctx *gin.Context) {
+
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", page)
+
+	}
+
+}
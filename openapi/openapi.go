@@ -0,0 +1,414 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/gin-gonic/gin"
+)
+
+// the subset of OpenAPI 3.0 the store uses, eg : /openapi.json
+
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Tags       []Tag                `json:"tags,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+	// the Go type behind every component, so equally named types of two packages are told apart
+	types map[string]reflect.Type
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type PathItem struct {
+	Get    *Operation `json:"get,omitempty"`
+	Put    *Operation `json:"put,omitempty"`
+	Post   *Operation `json:"post,omitempty"`
+	Delete *Operation `json:"delete,omitempty"`
+	Patch  *Operation `json:"patch,omitempty"`
+}
+
+type Operation struct {
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// the header the Authorization middleware reads the signed token from
+const TokenSecurity = "Token"
+
+// the schema every failed request is answered with, see apierror.Envelope
+const ErrorSchema = "Envelope"
+
+// a route as documented, the request and response shapes are given as Go values and turned into schemas, eg :
+// Route{Method: "GET", Path: "/api/v1/products/:id/reviews", Tag: "reviews", Response: controllers.ReviewPage{}}
+
+type Route struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Tag         string
+	// "" for public routes, TokenSecurity for the ones behind Authorization
+	Security string
+	// the struct the handler binds its parameters into, its fields become query parameters, see QueryFrom
+	Request any
+	// parameters the struct cannot describe, added after the ones of Request
+	Query []Parameter
+	// the JSON body, nil when the route takes none
+	Body any
+	// multipart form fields, used instead of Body for uploads
+	Form     map[string]*Schema
+	Response any
+	// defaults to 200
+	Status      int
+	ContentType string
+	Deprecated  bool
+}
+
+func New(title string, version string, description string) *Document {
+
+	document := &Document{
+		OpenAPI: "3.0.3",
+		Info:    Info{Title: title, Version: version, Description: description},
+		Paths:   make(map[string]*PathItem),
+		types:   make(map[string]reflect.Type),
+		Components: Components{
+			Schemas: make(map[string]*Schema),
+			SecuritySchemes: map[string]SecurityScheme{
+				TokenSecurity: {Type: "apiKey", In: "header", Name: "Token", Description: "the token returned by login or signup"},
+			},
+		},
+	}
+
+	document.SchemaFor(apierror.Envelope{})
+
+	return document
+
+}
+
+// gin writes path parameters as ":id" and "*key", OpenAPI as "{id}" and "{key}"
+
+func OpenAPIPath(ginPath string) (string, []string) {
+
+	segments := strings.Split(ginPath, "/")
+	params := make([]string, 0)
+
+	for index, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			params = append(params, segment[1:])
+			segments[index] = "{" + segment[1:] + "}"
+		}
+	}
+
+	return strings.Join(segments, "/"), params
+
+}
+
+func (item *PathItem) operation(method string) **Operation {
+
+	switch method {
+	case http.MethodGet:
+		return &item.Get
+	case http.MethodPut:
+		return &item.Put
+	case http.MethodPost:
+		return &item.Post
+	case http.MethodDelete:
+		return &item.Delete
+	case http.MethodPatch:
+		return &item.Patch
+	default:
+		return nil
+	}
+
+}
+
+func (document *Document) Operation(method string, ginPath string) *Operation {
+
+	path, _ := OpenAPIPath(ginPath)
+	item, ok := document.Paths[path]
+
+	if !ok {
+		return nil
+	}
+
+	if operation := item.operation(method); operation != nil {
+		return *operation
+	}
+
+	return nil
+
+}
+
+func (document *Document) Add(route Route) {
+
+	path, pathParams := OpenAPIPath(route.Path)
+
+	operation := &Operation{
+		Summary:     route.Summary,
+		Description: route.Description,
+		OperationID: operationID(route.Method, path),
+		Deprecated:  route.Deprecated,
+		Responses:   make(map[string]Response),
+	}
+
+	if route.Tag != "" {
+		operation.Tags = []string{route.Tag}
+	}
+
+	for _, name := range pathParams {
+		operation.Parameters = append(operation.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	}
+
+	if route.Request != nil {
+		operation.Parameters = append(operation.Parameters, QueryFrom(route.Request, route.Path)...)
+	}
+
+	operation.Parameters = append(operation.Parameters, route.Query...)
+
+	if route.Security != "" {
+		operation.Security = []map[string][]string{{route.Security: {}}}
+	}
+
+	switch {
+	case route.Form != nil:
+		form := &Schema{Type: "object", Properties: route.Form}
+		operation.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{"multipart/form-data": {Schema: form}}}
+	case route.Body != nil:
+		operation.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: document.SchemaFor(route.Body)}}}
+	}
+
+	status := route.Status
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	contentType := route.ContentType
+
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	success := Response{Description: http.StatusText(status)}
+
+	if route.Response != nil {
+		success.Content = map[string]MediaType{contentType: {Schema: document.SchemaFor(route.Response)}}
+	}
+
+	operation.Responses[strconv.Itoa(status)] = success
+	operation.Responses["default"] = Response{
+		Description: "Error",
+		Content:     map[string]MediaType{"application/json": {Schema: &Schema{Ref: "#/components/schemas/" + ErrorSchema}}},
+	}
+
+	item, ok := document.Paths[path]
+
+	if !ok {
+		item = &PathItem{}
+		document.Paths[path] = item
+	}
+
+	if target := item.operation(route.Method); target != nil {
+		*target = operation
+	}
+
+	document.addTag(route.Tag)
+
+}
+
+func (document *Document) addTag(name string) {
+
+	if name == "" {
+		return
+	}
+
+	for _, tag := range document.Tags {
+		if tag.Name == name {
+			return
+		}
+	}
+
+	document.Tags = append(document.Tags, Tag{Name: name})
+
+}
+
+// eg : GET /api/v1/products/{id}/reviews -> getApiV1ProductsIdReviews
+
+func operationID(method string, path string) string {
+
+	var id strings.Builder
+	id.WriteString(strings.ToLower(method))
+
+	for _, word := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '{' || r == '}' || r == '-' || r == '.' }) {
+		id.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+
+	return id.String()
+
+}
+
+// the routes the router serves and the document describes must be the same set, anything on one side only is reported
+// eg : "GET /api/v1/cart is not documented"
+
+func Verify(document *Document, routes gin.RoutesInfo) error {
+
+	problems := make([]string, 0)
+	registered := make(map[string]bool, len(routes))
+
+	for _, route := range routes {
+
+		registered[route.Method+" "+route.Path] = true
+
+		if document.Operation(route.Method, route.Path) == nil {
+			problems = append(problems, route.Method+" "+route.Path+" is not documented")
+		}
+
+	}
+
+	for path, item := range document.Paths {
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete, http.MethodPatch} {
+			if *item.operation(method) != nil && !registeredPath(registered, method, path) {
+				problems = append(problems, method+" "+path+" is documented but not registered")
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.Strings(problems)
+
+	return fmt.Errorf("openapi document does not match the router:\n  %s", strings.Join(problems, "\n  "))
+
+}
+
+func registeredPath(registered map[string]bool, method string, path string) bool {
+
+	for route := range registered {
+
+		routeMethod, routePath, _ := strings.Cut(route, " ")
+
+		if routeMethod != method {
+			continue
+		}
+
+		if converted, _ := OpenAPIPath(routePath); converted == path {
+			return true
+		}
+
+	}
+
+	return false
+
+}
+
+// the query parameters of a request struct, read from the same "form" and "uri" tags and validator rules the handler
+// binds with, a field whose "uri" parameter is part of the path is left out, a "doc" tag is the description, eg :
+// QueryFrom(controllers.ReviewListRequest{}, "/api/v1/products/:id/reviews") -> limit, offset and sort
+// QueryFrom(controllers.ReviewListRequest{}, "/reviews") -> productID, limit, offset and sort
+
+func QueryFrom(request any, path string) []Parameter {
+
+	requestType := reflect.TypeOf(request)
+	segments := strings.Split(path, "/")
+
+	var parameters []Parameter
+
+	for index := 0; index < requestType.NumField(); index++ {
+
+		field := requestType.Field(index)
+		name, _, _ := strings.Cut(field.Tag.Get("form"), ",")
+		param := field.Tag.Get("uri")
+
+		if name == "" || name == "-" {
+			continue
+		}
+
+		if param != "" && (slices.Contains(segments, ":"+param) || slices.Contains(segments, "*"+param)) {
+			continue
+		}
+
+		schema := new(Document).schemaForType(field.Type)
+		// a missing parameter is how a query string says nothing, there is no null
+		schema.Nullable = false
+		rules := field.Tag.Get("validate")
+		applyRules(schema, rules)
+
+		parameters = append(parameters, Parameter{
+			Name:        name,
+			In:          "query",
+			Description: field.Tag.Get("doc"),
+			Required:    hasRule(rules, "required"),
+			Schema:      schema,
+		})
+
+	}
+
+	return parameters
+
+}
@@ -0,0 +1,252 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+var (
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// describes the JSON encoding of a Go value, named structs become components and are referenced,
+// so every model appears once however many routes use it
+
+func (document *Document) SchemaFor(value any) *Schema {
+
+	return document.schemaForType(reflect.TypeOf(value))
+
+}
+
+func (document *Document) schemaForType(valueType reflect.Type) *Schema {
+
+	if valueType == nil {
+		return &Schema{}
+	}
+
+	switch valueType {
+	case objectIDType:
+		return &Schema{Type: "string", Format: "objectid", Pattern: "^[0-9a-f]{24}$"}
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &Schema{Type: "integer", Format: "int64", Description: "nanoseconds"}
+	}
+
+	switch valueType.Kind() {
+	case reflect.Pointer:
+		schema := document.schemaForType(valueType.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if valueType.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: document.schemaForType(valueType.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: document.schemaForType(valueType.Elem())}
+	case reflect.Struct:
+		if valueType.Name() == "" {
+			return document.structSchema(valueType)
+		}
+		return document.componentRef(valueType)
+	default:
+		// interfaces, any JSON value
+		return &Schema{}
+	}
+
+}
+
+func (document *Document) componentRef(valueType reflect.Type) *Schema {
+
+	name := valueType.Name()
+
+	// two packages may use the same type name, the second one is qualified eg : "search.Page"
+	if existing, ok := document.types[name]; ok && existing != valueType {
+		name = valueType.String()
+	}
+
+	if _, ok := document.types[name]; !ok {
+
+		// registered before the fields are walked, so self referencing types like category trees terminate
+		document.types[name] = valueType
+		schema := &Schema{}
+		document.Components.Schemas[name] = schema
+		*schema = *document.structSchema(valueType)
+
+	}
+
+	return &Schema{Ref: "#/components/schemas/" + name}
+
+}
+
+func (document *Document) structSchema(valueType reflect.Type) *Schema {
+
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	document.addFields(schema, valueType)
+	return schema
+
+}
+
+func (document *Document) addFields(schema *Schema, valueType reflect.Type) {
+
+	for index := 0; index < valueType.NumField(); index++ {
+
+		field := valueType.Field(index)
+
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if name == "-" {
+			continue
+		}
+
+		// embedded structs without a name of their own are flattened, like encoding/json does
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			document.addFields(schema, field.Type)
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		property := document.schemaForType(field.Type)
+		rules := field.Tag.Get("validate")
+
+		if property.Ref == "" {
+			applyRules(property, rules)
+		}
+
+		if strings.Contains(options, "string") && property.Type != "string" {
+			property = &Schema{Type: "string"}
+		}
+
+		schema.Properties[name] = property
+
+		if hasRule(rules, "required") && !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+
+	}
+
+}
+
+func hasRule(rules string, name string) bool {
+
+	for _, rule := range strings.Split(rules, ",") {
+		if rule == "dive" {
+			return false
+		}
+		if rule == name {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+// validator tags up to the first "dive" describe the field itself, eg : "required,min=2,max=30" or "oneof=shipping billing"
+
+func applyRules(schema *Schema, rules string) {
+
+	for _, rule := range strings.Split(rules, ",") {
+
+		if rule == "dive" {
+			return
+		}
+
+		name, value, _ := strings.Cut(rule, "=")
+
+		switch name {
+		case "email":
+			schema.Format = "email"
+		case "url":
+			schema.Format = "uri"
+		case "oneof":
+			schema.Enum = strings.Fields(value)
+		case "min", "gte", "max", "lte", "len":
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			applyBound(schema, name, number)
+		}
+
+	}
+
+}
+
+func applyBound(schema *Schema, name string, number float64) {
+
+	lower := name == "min" || name == "gte" || name == "len"
+	upper := name == "max" || name == "lte" || name == "len"
+	count := int(number)
+
+	switch schema.Type {
+	case "string":
+		if lower {
+			schema.MinLength = &count
+		}
+		if upper {
+			schema.MaxLength = &count
+		}
+	case "array":
+		if lower {
+			schema.MinItems = &count
+		}
+		if upper {
+			schema.MaxItems = &count
+		}
+	case "integer", "number":
+		if lower {
+			schema.Minimum = &number
+		}
+		if upper {
+			schema.Maximum = &number
+		}
+	}
+
+}
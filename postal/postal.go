@@ -0,0 +1,259 @@
+package postal
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+)
+
+var (
+	ErrUnsupportedCountry = errors.New("addresses in this country are not supported")
+	ErrInvalidPostalCode  = errors.New("postal code does not match the format of the country")
+	ErrUnserviceable      = errors.New("we do not deliver to this postal code")
+	ErrCityRequired       = errors.New("city is required for this postal code")
+)
+
+const DefaultCountry = "IN"
+
+// the format every postal code of a country must have, codes are normalized before they are matched
+// eg : "  560 001" -> "560001" for IN, "sw1a1aa" -> "SW1A 1AA" for GB
+
+type Rule struct {
+	Pattern   *regexp.Regexp
+	Normalize func(code string) string
+	Example   string
+}
+
+func withoutSpaces(code string) string {
+	return strings.ReplaceAll(strings.ToUpper(strings.TrimSpace(code)), " ", "")
+}
+
+// the inward code of a UK or Canadian postcode is always the last three characters
+func spacedBeforeLastThree(code string) string {
+
+	code = withoutSpaces(code)
+
+	if len(code) <= 3 {
+		return code
+	}
+
+	return code[:len(code)-3] + " " + code[len(code)-3:]
+
+}
+
+var Rules = map[string]Rule{
+	"IN": {Pattern: regexp.MustCompile(`^[1-9][0-9]{5}$`), Normalize: withoutSpaces, Example: "560001"},
+	"US": {Pattern: regexp.MustCompile(`^[0-9]{5}(-[0-9]{4})?$`), Normalize: withoutSpaces, Example: "94105"},
+	"GB": {Pattern: regexp.MustCompile(`^[A-Z]{1,2}[0-9][A-Z0-9]? [0-9][A-Z]{2}$`), Normalize: spacedBeforeLastThree, Example: "SW1A 1AA"},
+	"CA": {Pattern: regexp.MustCompile(`^[A-Z][0-9][A-Z] [0-9][A-Z][0-9]$`), Normalize: spacedBeforeLastThree, Example: "K1A 0B1"},
+	"DE": {Pattern: regexp.MustCompile(`^[0-9]{5}$`), Normalize: withoutSpaces, Example: "10115"},
+	"AU": {Pattern: regexp.MustCompile(`^[0-9]{4}$`), Normalize: withoutSpaces, Example: "2000"},
+	"SG": {Pattern: regexp.MustCompile(`^[0-9]{6}$`), Normalize: withoutSpaces, Example: "018956"},
+}
+
+type Place struct {
+	Pincode     string `json:"pin_code"`
+	City        string `json:"city_name"`
+	District    string `json:"district"`
+	State       string `json:"state"`
+	Country     string `json:"country"`
+	Serviceable bool   `json:"serviceable"`
+}
+
+// postal codes of the countries it covers, used to autofill addresses and to mark areas that are not delivered to
+// a code it does not know is only checked against the Rule of its country
+
+type Dataset struct {
+	places map[string]Place
+}
+
+// the bundled file covers the main cities, POSTAL_DATASET points to a complete export in the same format
+//
+//go:embed data/pincodes.csv
+var bundledDataset []byte
+
+var Places = loadDataset()
+
+func loadDataset() *Dataset {
+
+	var source io.Reader = bytes.NewReader(bundledDataset)
+
+	if path := os.Getenv("POSTAL_DATASET"); path != "" {
+
+		file, err := os.Open(path)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer file.Close()
+		source = file
+
+	}
+
+	dataset, err := Load(source)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return dataset
+
+}
+
+// eg : pincode,city,district,state,country,serviceable
+//      560001,Bengaluru,Bengaluru Urban,Karnataka,IN,true
+
+func Load(source io.Reader) (*Dataset, error) {
+
+	reader := csv.NewReader(source)
+	reader.FieldsPerRecord = 6
+
+	dataset := &Dataset{places: make(map[string]Place)}
+
+	for line := 1; ; line++ {
+
+		record, err := reader.Read()
+
+		if errors.Is(err, io.EOF) {
+			return dataset, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if line == 1 && record[0] == "pincode" {
+			continue
+		}
+
+		country := strings.ToUpper(strings.TrimSpace(record[4]))
+		rule, ok := Rules[country]
+
+		if !ok {
+			return nil, fmt.Errorf("postal dataset line %d: %w", line, ErrUnsupportedCountry)
+		}
+
+		place := Place{
+			Pincode:     rule.Normalize(record[0]),
+			City:        strings.TrimSpace(record[1]),
+			District:    strings.TrimSpace(record[2]),
+			State:       strings.TrimSpace(record[3]),
+			Country:     country,
+			Serviceable: strings.EqualFold(strings.TrimSpace(record[5]), "true"),
+		}
+
+		if !rule.Pattern.MatchString(place.Pincode) {
+			return nil, fmt.Errorf("postal dataset line %d: %w", line, ErrInvalidPostalCode)
+		}
+
+		dataset.places[country+":"+place.Pincode] = place
+
+	}
+
+}
+
+// SHIPPING_COUNTRIES lists the countries orders are delivered to, comma separated
+
+var ShippingCountries = shippingCountriesFromEnv()
+
+func shippingCountriesFromEnv() map[string]bool {
+
+	countries := make(map[string]bool)
+
+	for _, country := range strings.Split(os.Getenv("SHIPPING_COUNTRIES"), ",") {
+		if country = strings.ToUpper(strings.TrimSpace(country)); country != "" {
+			countries[country] = true
+		}
+	}
+
+	if len(countries) == 0 {
+		countries[DefaultCountry] = true
+	}
+
+	return countries
+
+}
+
+// checks the format of the code and looks it up, a well formed code missing from the dataset is accepted without
+// autofill, the dataset is not complete, so only codes it lists as unserviceable are refused delivery
+// the returned place is only serviceable when orders can be delivered there
+
+func (dataset *Dataset) Lookup(country string, code string) (Place, error) {
+
+	country = strings.ToUpper(strings.TrimSpace(country))
+
+	if country == "" {
+		country = DefaultCountry
+	}
+
+	rule, ok := Rules[country]
+
+	if !ok {
+		return Place{}, ErrUnsupportedCountry
+	}
+
+	code = rule.Normalize(code)
+
+	if !rule.Pattern.MatchString(code) {
+		return Place{}, ErrInvalidPostalCode
+	}
+
+	place, ok := dataset.places[country+":"+code]
+
+	if !ok {
+		return Place{Pincode: code, Country: country, Serviceable: ShippingCountries[country]}, nil
+	}
+
+	place.Serviceable = place.Serviceable && ShippingCountries[country]
+
+	return place, nil
+
+}
+
+// normalizes the postal code of an address and fills in city, district and state from the dataset,
+// a city the customer typed is kept, the dataset only fills it when it is missing
+
+func (dataset *Dataset) Complete(address *models.Address) error {
+
+	if address.Pincode == nil {
+		return ErrInvalidPostalCode
+	}
+
+	place, err := dataset.Lookup(address.Country, *address.Pincode)
+
+	if err != nil {
+		return err
+	}
+
+	address.Country = place.Country
+	address.Pincode = &place.Pincode
+	address.Serviceable = place.Serviceable
+
+	if address.City == nil || strings.TrimSpace(*address.City) == "" {
+		if place.City == "" {
+			return ErrCityRequired
+		}
+		address.City = &place.City
+	}
+
+	if place.State != "" {
+		address.State = &place.State
+	}
+
+	if place.District != "" {
+		address.District = &place.District
+	}
+
+	return nil
+
+}
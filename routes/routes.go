@@ -1,16 +1,112 @@
 package routes
 
-import(
+import (
 	"github.com/aaravmahajanofficial/ecommerce-project/controllers"
+	"github.com/aaravmahajanofficial/ecommerce-project/health"
+	"github.com/aaravmahajanofficial/ecommerce-project/middleware"
+	"github.com/aaravmahajanofficial/ecommerce-project/openapi"
 	"github.com/gin-gonic/gin"
 )
 
+// every route the server answers, main and the spec test both build their router here so they cannot differ,
+// the readiness checks come from main since they need the running dependencies
+
+func Register(router *gin.Engine, app *controllers.Application, readiness ...health.Check) {
+
+	// registered first so every route, and requests matching none, answer with the error envelope,
+	// the logger sits in between and sees the final status, probes arrive every few seconds and are logged at debug only
+	router.Use(middleware.RequestID(), middleware.RequestLogger(HealthPath, ReadyPath), middleware.ErrorHandler())
+	router.GET(HealthPath, health.Liveness())
+	router.GET(ReadyPath, health.Readiness(readiness...))
+	router.GET(SpecPath, openapi.Handler(Spec()))
+	router.GET(DocsPath, openapi.Docs(SpecPath))
+	V1Routes(router, app)
+	UserRoutes(router)
+	LegacyUserRoutes(router, app)
+
+}
+
+// the routes from before /api/v1, kept as deprecated aliases until clients have moved
+
 func UserRoutes(incomingRoutes *gin.Engine) {
 
-	incomingRoutes.POST("/users/signup", controllers.SignUp())
-	incomingRoutes.POST("/users/login", controllers.Login())
+	incomingRoutes.POST("/users/signup", middleware.Deprecated(APIPrefix+"/users/signup"), controllers.SignUp())
+	incomingRoutes.POST("/users/login", middleware.Deprecated(APIPrefix+"/users/login"), controllers.Login())
 	// incomingRoutes.POST("/admin/addproduct", controllers.ProductViewerAdmin())
-	incomingRoutes.GET("/users/productview", controllers.SearchProduct())
-	incomingRoutes.GET("/users/search", controllers.SearchProductByQuery())
+	incomingRoutes.GET("/users/productview", middleware.Deprecated(APIPrefix+"/products"), controllers.SearchProduct())
+	incomingRoutes.GET("/users/search", middleware.Deprecated(APIPrefix+"/products/search"), controllers.SearchProductByQuery())
+	// suggestions are requested on every keystroke, 10 per second per IP with room for quick typing
+	incomingRoutes.GET("/search/suggest", middleware.Deprecated(APIPrefix+"/search/suggestions"), middleware.RateLimit(suggestionLimiter), controllers.SuggestSearch())
+	incomingRoutes.GET("/exchangerates", middleware.Deprecated(APIPrefix+"/exchange-rates"), controllers.ExchangeRates())
+	incomingRoutes.GET("/categories", middleware.Deprecated(APIPrefix+"/categories"), controllers.GetCategoryTree())
+	incomingRoutes.GET("/categories/products", middleware.Deprecated(APIPrefix+"/categories/{slug}/products"), controllers.GetCategoryProducts())
+	incomingRoutes.GET("/reviews", middleware.Deprecated(APIPrefix+"/products/{id}/reviews"), controllers.GetProductReviews())
+	// stored image urls point here, so this route stays after the legacy ones are removed
+	incomingRoutes.GET("/images/*key", controllers.ServeImage())
+	incomingRoutes.GET("/wishlists/shared/:token", middleware.Deprecated(APIPrefix+"/wishlists/shared/{token}"), controllers.GetSharedWishlist())
+	incomingRoutes.GET("/guest/cart", middleware.Deprecated(APIPrefix+"/guest/cart"), controllers.GetGuestCart())
+	incomingRoutes.POST("/addresses/validate", middleware.Deprecated(APIPrefix+"/addresses/validate"), controllers.ValidateAddress())
+	incomingRoutes.GET("/postalcodes/:code", middleware.Deprecated(APIPrefix+"/postal-codes/{code}"), controllers.LookupPostalCode())
+	incomingRoutes.POST("/guest/cart/items", middleware.Deprecated(APIPrefix+"/guest/cart/items"), controllers.AddToGuestCart())
+	incomingRoutes.DELETE("/guest/cart/items", middleware.Deprecated(APIPrefix+"/guest/cart/items/{id}"), controllers.RemoveFromGuestCart())
+	incomingRoutes.POST("/carriers/webhook", middleware.Deprecated(APIPrefix+"/carriers/{carrier}/webhook"), controllers.CarrierWebhook())
+
+}
+
+// the legacy routes behind Authorization, on a group of their own, on the engine the middleware would also run
+// for requests matching no route and answer them 401 instead of 404
+
+func LegacyUserRoutes(incomingRoutes *gin.Engine, app *controllers.Application) {
+
+	user := incomingRoutes.Group("", middleware.Authorization())
+
+	user.GET("/addtocart", middleware.Deprecated(APIPrefix+"/cart/items"), app.AddToCart())
+	user.GET("/removeitem", middleware.Deprecated(APIPrefix+"/cart/items/{id}"), app.RemoveItem())
+	user.GET("/listcart", middleware.Deprecated(APIPrefix+"/cart"), controllers.GetItemFromCart())
+	user.POST("/cart/acknowledge", middleware.Deprecated(APIPrefix+"/cart/acknowledge"), controllers.AcknowledgeCartChanges())
+	user.POST("/addaddress", middleware.Deprecated(APIPrefix+"/addresses"), controllers.AddAddress())
+	user.PUT("/edithomeaddress", middleware.Deprecated(APIPrefix+"/addresses/{id}"), controllers.EditHomeAddress())
+	user.PUT("/editworkaddress", middleware.Deprecated(APIPrefix+"/addresses/{id}"), controllers.EditWorkAddress())
+	user.GET("/deleteaddresses", middleware.Deprecated(APIPrefix+"/addresses"), controllers.DeleteAddress())
+	user.GET("/addresses", middleware.Deprecated(APIPrefix+"/addresses"), controllers.ListAddresses())
+	user.POST("/addresses", middleware.Deprecated(APIPrefix+"/addresses"), controllers.CreateAddress())
+	user.PUT("/addresses", middleware.Deprecated(APIPrefix+"/addresses/{id}"), controllers.UpdateAddress())
+	user.DELETE("/addresses", middleware.Deprecated(APIPrefix+"/addresses/{id}"), controllers.RemoveAddress())
+	user.PUT("/addresses/default", middleware.Deprecated(APIPrefix+"/addresses/{id}/default"), controllers.SetDefaultAddress())
+	user.GET("/cartcheckout", middleware.Deprecated(APIPrefix+"/orders"), app.BuyFromCart())
+	user.GET("/instantbuy", middleware.Deprecated(APIPrefix+"/orders/instant"), app.InstantBuy())
+	user.POST("/movetowishlist", middleware.Deprecated(APIPrefix+"/cart/items/{id}/move-to-wishlist"), controllers.MoveToWishlist())
+	user.POST("/movetocart", middleware.Deprecated(APIPrefix+"/wishlists/{id}/items/{productID}/move-to-cart"), controllers.MoveToCart())
+	user.GET("/wishlists", middleware.Deprecated(APIPrefix+"/wishlists"), controllers.GetWishlists())
+	user.POST("/wishlists", middleware.Deprecated(APIPrefix+"/wishlists"), controllers.CreateWishlist())
+	user.PUT("/wishlists", middleware.Deprecated(APIPrefix+"/wishlists/{id}"), controllers.RenameWishlist())
+	user.DELETE("/wishlists", middleware.Deprecated(APIPrefix+"/wishlists/{id}"), controllers.DeleteWishlist())
+	user.POST("/wishlists/items", middleware.Deprecated(APIPrefix+"/wishlists/{id}/items/{productID}"), controllers.AddWishlistItem())
+	user.DELETE("/wishlists/items", middleware.Deprecated(APIPrefix+"/wishlists/{id}/items/{productID}"), controllers.RemoveWishlistItem())
+	user.POST("/wishlists/share", middleware.Deprecated(APIPrefix+"/wishlists/{id}/share"), controllers.ShareWishlist())
+	user.DELETE("/wishlists/share", middleware.Deprecated(APIPrefix+"/wishlists/{id}/share"), controllers.UnshareWishlist())
+	user.GET("/orders/tracking", middleware.Deprecated(APIPrefix+"/orders/{id}/tracking"), controllers.TrackOrder())
+	user.POST("/reviews", middleware.Deprecated(APIPrefix+"/products/{id}/reviews"), controllers.CreateReview())
+	user.PUT("/reviews", middleware.Deprecated(APIPrefix+"/reviews/{id}"), controllers.UpdateReview())
+	user.DELETE("/reviews", middleware.Deprecated(APIPrefix+"/reviews/{id}"), controllers.DeleteReview())
+	user.POST("/reviews/helpful", middleware.Deprecated(APIPrefix+"/reviews/{id}/helpful"), controllers.VoteReviewHelpful())
+
+	admin := user.Group("/admin", middleware.AdminAuthorization())
+	admin.POST("/shipments", middleware.Deprecated(APIPrefix+"/admin/orders/{id}/shipments"), controllers.CreateShipment())
+	admin.POST("/shipments/ship", middleware.Deprecated(APIPrefix+"/admin/shipments/{id}/ship"), controllers.ShipShipment())
+	admin.POST("/categories", middleware.Deprecated(APIPrefix+"/admin/categories"), controllers.CreateCategory())
+	admin.PUT("/categories", middleware.Deprecated(APIPrefix+"/admin/categories/{id}"), controllers.UpdateCategory())
+	admin.DELETE("/categories", middleware.Deprecated(APIPrefix+"/admin/categories/{id}"), controllers.DeleteCategory())
+	admin.PUT("/products/categories", middleware.Deprecated(APIPrefix+"/admin/products/{id}/categories"), controllers.SetProductCategories())
+	admin.PUT("/products/variants", middleware.Deprecated(APIPrefix+"/admin/products/{id}/variants"), controllers.SetProductVariants())
+	admin.PUT("/reviews/moderate", middleware.Deprecated(APIPrefix+"/admin/reviews/{id}/moderation"), controllers.ModerateReview())
+	admin.POST("/products/images", middleware.Deprecated(APIPrefix+"/admin/products/{id}/images"), controllers.UploadProductImage())
+	admin.DELETE("/products/images", middleware.Deprecated(APIPrefix+"/admin/products/{id}/images/{imageID}"), controllers.DeleteProductImage())
+	admin.PUT("/products/images/order", middleware.Deprecated(APIPrefix+"/admin/products/{id}/images/order"), controllers.ReorderProductImages())
+	admin.POST("/products/import", middleware.Deprecated(APIPrefix+"/admin/products/imports"), controllers.ImportProducts())
+	admin.GET("/products/import", middleware.Deprecated(APIPrefix+"/admin/products/imports/{id}"), controllers.GetImportJob())
+	admin.GET("/products/export", middleware.Deprecated(APIPrefix+"/admin/products/export"), controllers.ExportProducts())
+	admin.GET("/carts/abandoned", middleware.Deprecated(APIPrefix+"/admin/carts/abandoned"), controllers.GetAbandonedCartMetrics())
+	admin.POST("/carts/abandoned/reminders", middleware.Deprecated(APIPrefix+"/admin/carts/abandoned/reminders"), controllers.SendCartReminders())
 
-}
\ No newline at end of file
+}
@@ -0,0 +1,239 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/controllers"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/health"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"github.com/aaravmahajanofficial/ecommerce-project/openapi"
+	"github.com/aaravmahajanofficial/ecommerce-project/postal"
+	"github.com/aaravmahajanofficial/ecommerce-project/search"
+)
+
+// where the document and its docs page are served, and where the probes of the orchestrator are answered
+const (
+	SpecPath   = "/openapi.json"
+	DocsPath   = "/docs"
+	HealthPath = "/healthz"
+	ReadyPath  = "/readyz"
+)
+
+// the query of the product listing and search routes, the handlers check the sort apart from the struct,
+// so the names it accepts are given here
+
+func listingQuery(sorts ...string) []openapi.Parameter {
+
+	parameters := openapi.QueryFrom(controllers.ProductListRequest{}, "")
+
+	for index := range parameters {
+		if parameters[index].Name == "sort" {
+			parameters[index].Schema.Enum = sorts
+		}
+	}
+
+	return parameters
+
+}
+
+var message = controllers.Message{}
+
+// every route served under /api/v1, paths are written the way gin registers them
+
+var v1Routes = []openapi.Route{
+	{Method: http.MethodPost, Path: "/users/signup", Tag: "users", Summary: "Create an account", Body: models.User{}, Response: message, Status: http.StatusCreated, Description: "a Cart-Token header merges the visitor's guest cart into the new account"},
+	{Method: http.MethodPost, Path: "/users/login", Tag: "users", Summary: "Sign in", Body: controllers.Credentials{}, Response: models.User{}, Description: "a Cart-Token header merges the visitor's guest cart into the account"},
+
+	{Method: http.MethodGet, Path: "/products", Tag: "products", Summary: "List products", Query: listingQuery(database.ProductSortNames()...), Response: database.ProductPage{}},
+	{Method: http.MethodGet, Path: "/products/search", Tag: "products", Summary: "Search products", Request: controllers.SearchRequest{}, Query: listingQuery(append(database.ProductSortNames(), search.SortRelevance)...), Response: search.Page{}},
+	{Method: http.MethodGet, Path: "/products/:id/reviews", Tag: "reviews", Summary: "List the published reviews of a product", Request: controllers.ReviewListRequest{}, Response: controllers.ReviewPage{}},
+	{Method: http.MethodGet, Path: "/search/suggestions", Tag: "products", Summary: "Suggest completions while typing", Description: "rate limited per IP", Request: controllers.SuggestRequest{}, Response: search.SuggestResult{}},
+	{Method: http.MethodGet, Path: "/categories", Tag: "categories", Summary: "Get the category tree", Response: []*models.CategoryNode{}},
+	{Method: http.MethodGet, Path: "/categories/:slug/products", Tag: "categories", Summary: "List the products of a category and its subcategories", Request: controllers.CategoryProductsRequest{}, Query: listingQuery(database.ProductSortNames()...), Response: controllers.CategoryProducts{}},
+	{Method: http.MethodGet, Path: "/exchange-rates", Tag: "currencies", Summary: "Get the current exchange rates", Response: controllers.ExchangeRateTable{}},
+	{Method: http.MethodGet, Path: "/images/*key", Tag: "images", Summary: "Download a stored product image", ContentType: "image/*", Response: []byte{}},
+	{Method: http.MethodGet, Path: "/wishlists/shared/:token", Tag: "wishlists", Summary: "View a shared wishlist", Response: models.Wishlist{}},
+	{Method: http.MethodPost, Path: "/addresses/validate", Tag: "addresses", Summary: "Validate and autofill an address", Body: models.Address{}, Response: controllers.AddressValidation{}},
+	{Method: http.MethodGet, Path: "/postal-codes/:code", Tag: "addresses", Summary: "Look up the place of a postal code", Request: controllers.PostalCodeRequest{}, Response: postal.Place{}},
+	{Method: http.MethodPost, Path: "/carriers/:carrier/webhook", Tag: "shipments", Summary: "Receive tracking events from a carrier", Request: controllers.CarrierWebhookRequest{}, Description: "the X-Carrier-Secret header must match CARRIER_WEBHOOK_SECRET, without one configured the webhook answers 503, the body is the carrier's own format, at most 1 MB", Response: controllers.WebhookResult{}},
+
+	{Method: http.MethodGet, Path: "/guest/cart", Tag: "guest cart", Summary: "Get the visitor's cart", Description: "the cart is identified by the Cart-Token header", Request: controllers.GuestCartRequest{}, Response: controllers.GuestCartListing{}},
+	{Method: http.MethodPost, Path: "/guest/cart/items", Tag: "guest cart", Summary: "Add a product to the visitor's cart", Description: "without a valid Cart-Token header a new cart is created, the token is returned in the body and the header", Request: controllers.GuestCartItemRequest{}, Response: controllers.GuestCartListing{}},
+	{Method: http.MethodDelete, Path: "/guest/cart/items/:id", Tag: "guest cart", Summary: "Remove a product from the visitor's cart", Request: controllers.GuestCartItemRequest{}, Response: controllers.GuestCartListing{}},
+
+	{Method: http.MethodGet, Path: "/cart", Tag: "cart", Security: openapi.TokenSecurity, Summary: "Get the cart", Request: controllers.CartRequest{}, Response: controllers.CartListing{}},
+	{Method: http.MethodPost, Path: "/cart/items", Tag: "cart", Security: openapi.TokenSecurity, Summary: "Add a product to the cart", Request: controllers.AddToCartRequest{}, Response: message},
+	{Method: http.MethodDelete, Path: "/cart/items/:id", Tag: "cart", Security: openapi.TokenSecurity, Summary: "Remove a product from the cart", Request: controllers.RemoveItemRequest{}, Response: message},
+	{Method: http.MethodPost, Path: "/cart/items/:id/move-to-wishlist", Tag: "cart", Security: openapi.TokenSecurity, Summary: "Move a cart item to a wishlist", Request: controllers.CartItemToWishlistRequest{}, Response: models.Wishlist{}},
+	{Method: http.MethodPost, Path: "/cart/acknowledge", Tag: "cart", Security: openapi.TokenSecurity, Summary: "Accept the price and stock changes of the cart", Response: message},
+
+	{Method: http.MethodPost, Path: "/orders", Tag: "orders", Security: openapi.TokenSecurity, Summary: "Check out the cart", Request: controllers.CheckoutRequest{}, Response: message},
+	{Method: http.MethodPost, Path: "/orders/instant", Tag: "orders", Security: openapi.TokenSecurity, Summary: "Buy a single product right away", Request: controllers.InstantBuyRequest{}, Response: message},
+	{Method: http.MethodGet, Path: "/orders/:id/tracking", Tag: "orders", Security: openapi.TokenSecurity, Summary: "Track the shipments of an order", Request: controllers.OrderRequest{}, Response: controllers.OrderTracking{}},
+
+	{Method: http.MethodGet, Path: "/addresses", Tag: "addresses", Security: openapi.TokenSecurity, Summary: "List the address book", Response: []models.Address{}},
+	{Method: http.MethodPost, Path: "/addresses", Tag: "addresses", Security: openapi.TokenSecurity, Summary: "Add an address", Body: models.Address{}, Response: models.Address{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/addresses", Tag: "addresses", Security: openapi.TokenSecurity, Summary: "Delete every address", Response: message},
+	{Method: http.MethodPut, Path: "/addresses/:id", Tag: "addresses", Security: openapi.TokenSecurity, Summary: "Replace an address", Request: controllers.IDRequest{}, Body: models.Address{}, Response: models.Address{}},
+	{Method: http.MethodDelete, Path: "/addresses/:id", Tag: "addresses", Security: openapi.TokenSecurity, Summary: "Delete an address", Request: controllers.IDRequest{}, Response: message},
+	{Method: http.MethodPut, Path: "/addresses/:id/default", Tag: "addresses", Security: openapi.TokenSecurity, Summary: "Make an address the default", Request: controllers.DefaultAddressRequest{}, Response: []models.Address{}},
+
+	{Method: http.MethodGet, Path: "/wishlists", Tag: "wishlists", Security: openapi.TokenSecurity, Summary: "List the wishlists, the default one first", Request: controllers.WishlistsRequest{}, Response: []models.Wishlist{}},
+	{Method: http.MethodPost, Path: "/wishlists", Tag: "wishlists", Security: openapi.TokenSecurity, Summary: "Create a wishlist", Body: controllers.WishlistNameRequest{}, Response: models.Wishlist{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/wishlists/:id", Tag: "wishlists", Security: openapi.TokenSecurity, Summary: "Get a wishlist", Request: controllers.WishlistsRequest{}, Response: models.Wishlist{}},
+	{Method: http.MethodPut, Path: "/wishlists/:id", Tag: "wishlists", Security: openapi.TokenSecurity, Summary: "Rename a wishlist", Request: controllers.IDRequest{}, Body: controllers.WishlistNameRequest{}, Response: models.Wishlist{}},
+	{Method: http.MethodDelete, Path: "/wishlists/:id", Tag: "wishlists", Security: openapi.TokenSecurity, Summary: "Delete a wishlist", Request: controllers.IDRequest{}, Response: message},
+	{Method: http.MethodPut, Path: "/wishlists/:id/items/:productID", Tag: "wishlists", Security: openapi.TokenSecurity, Summary: "Add a product to a wishlist", Request: controllers.WishlistItemRequest{}, Response: models.Wishlist{}},
+	{Method: http.MethodDelete, Path: "/wishlists/:id/items/:productID", Tag: "wishlists", Security: openapi.TokenSecurity, Summary: "Remove a product from a wishlist", Request: controllers.WishlistItemRequest{}, Response: message},
+	{Method: http.MethodPost, Path: "/wishlists/:id/items/:productID/move-to-cart", Tag: "wishlists", Security: openapi.TokenSecurity, Summary: "Move a wishlist item to the cart", Request: controllers.WishlistItemRequest{}, Response: message},
+	{Method: http.MethodPost, Path: "/wishlists/:id/share", Tag: "wishlists", Security: openapi.TokenSecurity, Summary: "Create a share link for a wishlist", Request: controllers.IDRequest{}, Response: controllers.WishlistShare{}},
+	{Method: http.MethodDelete, Path: "/wishlists/:id/share", Tag: "wishlists", Security: openapi.TokenSecurity, Summary: "Revoke the share link of a wishlist", Request: controllers.IDRequest{}, Response: message},
+
+	{Method: http.MethodPost, Path: "/products/:id/reviews", Tag: "reviews", Security: openapi.TokenSecurity, Summary: "Review a product", Request: controllers.ReviewProductRequest{}, Body: models.Review{}, Response: models.Review{}, Status: http.StatusCreated},
+	{Method: http.MethodPut, Path: "/reviews/:id", Tag: "reviews", Security: openapi.TokenSecurity, Summary: "Edit an own review", Request: controllers.IDRequest{}, Body: models.Review{}, Response: models.Review{}},
+	{Method: http.MethodDelete, Path: "/reviews/:id", Tag: "reviews", Security: openapi.TokenSecurity, Summary: "Delete an own review", Request: controllers.IDRequest{}, Response: message},
+	{Method: http.MethodPost, Path: "/reviews/:id/helpful", Tag: "reviews", Security: openapi.TokenSecurity, Summary: "Vote a review helpful", Request: controllers.IDRequest{}, Response: models.Review{}},
+
+	{Method: http.MethodPost, Path: "/admin/orders/:id/shipments", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Ship items of an order", Request: controllers.OrderRequest{}, Body: controllers.ShipmentRequest{}, Response: models.Shipment{}, Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/admin/shipments/:id/ship", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Hand a shipment to its carrier", Request: controllers.IDRequest{}, Description: "the body is optional, without a tracking number the shipment is booked with the carrier", Body: controllers.ShipRequest{}, Response: models.Shipment{}},
+	{Method: http.MethodPost, Path: "/admin/categories", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Create a category", Body: models.Category{}, Response: models.Category{}, Status: http.StatusCreated},
+	{Method: http.MethodPut, Path: "/admin/categories/:id", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Update or move a category", Request: controllers.IDRequest{}, Description: "fields left out keep their value", Body: models.Category{}, Response: models.Category{}},
+	{Method: http.MethodDelete, Path: "/admin/categories/:id", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Delete an empty category", Request: controllers.IDRequest{}, Response: message},
+	{Method: http.MethodPut, Path: "/admin/products/:id/categories", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Assign a product to categories", Request: controllers.IDRequest{}, Body: controllers.ProductCategoriesRequest{}, Response: message},
+	{Method: http.MethodPut, Path: "/admin/products/:id/variants", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Replace the options and variants of a product", Request: controllers.IDRequest{}, Body: controllers.VariantsRequest{}, Response: models.Product{}},
+	{Method: http.MethodPost, Path: "/admin/products/:id/images", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Upload a product image", Request: controllers.ImageUploadRequest{}, Form: map[string]*openapi.Schema{
+		"image": {Type: "string", Format: "binary"},
+		"alt":   {Type: "string"},
+	}, Response: []models.ProductImage{}, Status: http.StatusCreated},
+	{Method: http.MethodPut, Path: "/admin/products/:id/images/order", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Reorder the images of a product", Request: controllers.IDRequest{}, Body: controllers.ImageOrderRequest{}, Response: []models.ProductImage{}},
+	{Method: http.MethodDelete, Path: "/admin/products/:id/images/:imageID", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Delete a product image", Request: controllers.ImageRequest{}, Response: message},
+	{Method: http.MethodPost, Path: "/admin/products/imports", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Start a catalog import", Description: "the file is sent as the \"file\" form field or as the raw body", Request: controllers.CatalogFormatRequest{}, Form: map[string]*openapi.Schema{
+		"file": {Type: "string", Format: "binary"},
+	}, Response: models.ImportJob{}, Status: http.StatusAccepted},
+	{Method: http.MethodGet, Path: "/admin/products/imports/:id", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Get the progress of an import", Request: controllers.IDRequest{}, Response: models.ImportJob{}},
+	{Method: http.MethodGet, Path: "/admin/products/export", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Export the catalog", Description: "jsonl is sent as application/x-ndjson", Request: controllers.CatalogFormatRequest{}, ContentType: "text/csv", Response: ""},
+	{Method: http.MethodPut, Path: "/admin/reviews/:id/moderation", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Publish or reject a review", Request: controllers.IDRequest{}, Body: controllers.ModerationRequest{}, Response: models.Review{}},
+	{Method: http.MethodGet, Path: "/admin/carts/abandoned", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Get abandoned cart metrics", Response: models.AbandonedCartMetrics{}},
+	{Method: http.MethodPost, Path: "/admin/carts/abandoned/reminders", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Send the due cart reminders now", Response: controllers.ReminderRun{}},
+	{Method: http.MethodGet, Path: "/admin/log-level", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Get the log level", Response: controllers.LogLevel{}},
+	{Method: http.MethodPut, Path: "/admin/log-level", Tag: "admin", Security: openapi.TokenSecurity, Summary: "Change the log level until the next restart", Body: controllers.LogLevel{}, Response: controllers.LogLevel{}},
+}
+
+// the routes from before /api/v1 and the v1 route replacing each, eg : "GET /listcart" -> "GET /cart"
+// they take the same bodies, ids the successor has in its path are sent in the query string instead
+
+var legacyRoutes = []struct {
+	Method, Path, Security, Successor string
+}{
+	{http.MethodPost, "/users/signup", "", "POST /users/signup"},
+	{http.MethodPost, "/users/login", "", "POST /users/login"},
+	{http.MethodGet, "/users/productview", "", "GET /products"},
+	{http.MethodGet, "/users/search", "", "GET /products/search"},
+	{http.MethodGet, "/search/suggest", "", "GET /search/suggestions"},
+	{http.MethodGet, "/exchangerates", "", "GET /exchange-rates"},
+	{http.MethodGet, "/categories", "", "GET /categories"},
+	{http.MethodGet, "/categories/products", "", "GET /categories/:slug/products"},
+	{http.MethodGet, "/reviews", "", "GET /products/:id/reviews"},
+	{http.MethodGet, "/images/*key", "", "GET /images/*key"},
+	{http.MethodGet, "/wishlists/shared/:token", "", "GET /wishlists/shared/:token"},
+	{http.MethodGet, "/guest/cart", "", "GET /guest/cart"},
+	{http.MethodPost, "/addresses/validate", "", "POST /addresses/validate"},
+	{http.MethodGet, "/postalcodes/:code", "", "GET /postal-codes/:code"},
+	{http.MethodPost, "/guest/cart/items", "", "POST /guest/cart/items"},
+	{http.MethodDelete, "/guest/cart/items", "", "DELETE /guest/cart/items/:id"},
+	{http.MethodPost, "/carriers/webhook", "", "POST /carriers/:carrier/webhook"},
+
+	{http.MethodGet, "/addtocart", openapi.TokenSecurity, "POST /cart/items"},
+	{http.MethodGet, "/removeitem", openapi.TokenSecurity, "DELETE /cart/items/:id"},
+	{http.MethodGet, "/listcart", openapi.TokenSecurity, "GET /cart"},
+	{http.MethodPost, "/cart/acknowledge", openapi.TokenSecurity, "POST /cart/acknowledge"},
+	{http.MethodPost, "/addaddress", openapi.TokenSecurity, "POST /addresses"},
+	{http.MethodPut, "/edithomeaddress", openapi.TokenSecurity, "PUT /addresses/:id"},
+	{http.MethodPut, "/editworkaddress", openapi.TokenSecurity, "PUT /addresses/:id"},
+	{http.MethodGet, "/deleteaddresses", openapi.TokenSecurity, "DELETE /addresses"},
+	{http.MethodGet, "/addresses", openapi.TokenSecurity, "GET /addresses"},
+	{http.MethodPost, "/addresses", openapi.TokenSecurity, "POST /addresses"},
+	{http.MethodPut, "/addresses", openapi.TokenSecurity, "PUT /addresses/:id"},
+	{http.MethodDelete, "/addresses", openapi.TokenSecurity, "DELETE /addresses/:id"},
+	{http.MethodPut, "/addresses/default", openapi.TokenSecurity, "PUT /addresses/:id/default"},
+	{http.MethodGet, "/cartcheckout", openapi.TokenSecurity, "POST /orders"},
+	{http.MethodGet, "/instantbuy", openapi.TokenSecurity, "POST /orders/instant"},
+	{http.MethodPost, "/movetowishlist", openapi.TokenSecurity, "POST /cart/items/:id/move-to-wishlist"},
+	{http.MethodPost, "/movetocart", openapi.TokenSecurity, "POST /wishlists/:id/items/:productID/move-to-cart"},
+	{http.MethodGet, "/wishlists", openapi.TokenSecurity, "GET /wishlists"},
+	{http.MethodPost, "/wishlists", openapi.TokenSecurity, "POST /wishlists"},
+	{http.MethodPut, "/wishlists", openapi.TokenSecurity, "PUT /wishlists/:id"},
+	{http.MethodDelete, "/wishlists", openapi.TokenSecurity, "DELETE /wishlists/:id"},
+	{http.MethodPost, "/wishlists/items", openapi.TokenSecurity, "PUT /wishlists/:id/items/:productID"},
+	{http.MethodDelete, "/wishlists/items", openapi.TokenSecurity, "DELETE /wishlists/:id/items/:productID"},
+	{http.MethodPost, "/wishlists/share", openapi.TokenSecurity, "POST /wishlists/:id/share"},
+	{http.MethodDelete, "/wishlists/share", openapi.TokenSecurity, "DELETE /wishlists/:id/share"},
+	{http.MethodGet, "/orders/tracking", openapi.TokenSecurity, "GET /orders/:id/tracking"},
+	{http.MethodPost, "/reviews", openapi.TokenSecurity, "POST /products/:id/reviews"},
+	{http.MethodPut, "/reviews", openapi.TokenSecurity, "PUT /reviews/:id"},
+	{http.MethodDelete, "/reviews", openapi.TokenSecurity, "DELETE /reviews/:id"},
+	{http.MethodPost, "/reviews/helpful", openapi.TokenSecurity, "POST /reviews/:id/helpful"},
+
+	{http.MethodPost, "/admin/shipments", openapi.TokenSecurity, "POST /admin/orders/:id/shipments"},
+	{http.MethodPost, "/admin/shipments/ship", openapi.TokenSecurity, "POST /admin/shipments/:id/ship"},
+	{http.MethodPost, "/admin/categories", openapi.TokenSecurity, "POST /admin/categories"},
+	{http.MethodPut, "/admin/categories", openapi.TokenSecurity, "PUT /admin/categories/:id"},
+	{http.MethodDelete, "/admin/categories", openapi.TokenSecurity, "DELETE /admin/categories/:id"},
+	{http.MethodPut, "/admin/products/categories", openapi.TokenSecurity, "PUT /admin/products/:id/categories"},
+	{http.MethodPut, "/admin/products/variants", openapi.TokenSecurity, "PUT /admin/products/:id/variants"},
+	{http.MethodPut, "/admin/reviews/moderate", openapi.TokenSecurity, "PUT /admin/reviews/:id/moderation"},
+	{http.MethodPost, "/admin/products/images", openapi.TokenSecurity, "POST /admin/products/:id/images"},
+	{http.MethodDelete, "/admin/products/images", openapi.TokenSecurity, "DELETE /admin/products/:id/images/:imageID"},
+	{http.MethodPut, "/admin/products/images/order", openapi.TokenSecurity, "PUT /admin/products/:id/images/order"},
+	{http.MethodPost, "/admin/products/import", openapi.TokenSecurity, "POST /admin/products/imports"},
+	{http.MethodGet, "/admin/products/import", openapi.TokenSecurity, "GET /admin/products/imports/:id"},
+	{http.MethodGet, "/admin/products/export", openapi.TokenSecurity, "GET /admin/products/export"},
+	{http.MethodGet, "/admin/carts/abandoned", openapi.TokenSecurity, "GET /admin/carts/abandoned"},
+	{http.MethodPost, "/admin/carts/abandoned/reminders", openapi.TokenSecurity, "POST /admin/carts/abandoned/reminders"},
+}
+
+// the document is built from the same controller and model types the handlers encode and the request structs
+// they bind, spec_test.go checks it against the registered routes so a new route cannot go undocumented
+
+func Spec() *openapi.Document {
+
+	document := openapi.New("E-commerce API", "1.0.0", "Every failed request is answered with the error envelope, the default response of each operation.")
+
+	successors := make(map[string]openapi.Route, len(v1Routes))
+
+	for _, route := range v1Routes {
+		route.Path = APIPrefix + route.Path
+		document.Add(route)
+		successors[route.Method+" "+route.Path] = route
+	}
+
+	for _, legacy := range legacyRoutes {
+
+		method, path, _ := strings.Cut(legacy.Successor, " ")
+		route := successors[method+" "+APIPrefix+path]
+		successorPath, _ := openapi.OpenAPIPath(APIPrefix + path)
+
+		route.Method = legacy.Method
+		route.Path = legacy.Path
+		route.Security = legacy.Security
+		route.Tag = "legacy"
+		route.Summary = route.Summary + ", use " + method + " " + successorPath
+		route.Description = "ids the successor takes in its path are sent in the query string"
+		// stored image urls point here, so it stays when the other legacy routes are removed
+		route.Deprecated = legacy.Path != "/images/*key"
+
+		document.Add(route)
+
+	}
+
+	document.Add(openapi.Route{Method: http.MethodGet, Path: SpecPath, Tag: "docs", Summary: "This document", Response: map[string]any{}})
+	document.Add(openapi.Route{Method: http.MethodGet, Path: DocsPath, Tag: "docs", Summary: "Interactive documentation", ContentType: "text/html", Response: ""})
+
+	document.Add(openapi.Route{Method: http.MethodGet, Path: HealthPath, Tag: "health", Summary: "Check that the process is alive", Response: health.Report{}})
+	document.Add(openapi.Route{Method: http.MethodGet, Path: ReadyPath, Tag: "health", Summary: "Check that every dependency is ready", Description: "answers 503 with the same body while a dependency is down", Response: health.Report{}})
+
+	return document
+
+}
@@ -0,0 +1,63 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/apierror"
+	"github.com/aaravmahajanofficial/ecommerce-project/controllers"
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/openapi"
+	"github.com/gin-gonic/gin"
+)
+
+// the client only connects in the background, so the router is built without a running mongo
+
+func newRouter() *gin.Engine {
+
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	app := controllers.NewApplication(database.ProductData(database.Client, "Products"), database.UserData(database.Client, "Users"))
+	Register(router, app)
+
+	return router
+
+}
+
+func TestSpecDocumentsEveryRoute(t *testing.T) {
+
+	router := newRouter()
+
+	if err := openapi.Verify(Spec(), router.Routes()); err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+// no route means 404, whatever middleware the authenticated routes use
+
+func TestUnknownRouteIsNotFound(t *testing.T) {
+
+	router := newRouter()
+
+	for _, path := range []string{"/nope", APIPrefix + "/nope"} {
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+		var envelope apierror.Envelope
+
+		if err := json.Unmarshal(recorder.Body.Bytes(), &envelope); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		if recorder.Code != http.StatusNotFound || envelope.Error.Code != "NOT_FOUND" {
+			t.Errorf("%s answered %d %s, want 404 NOT_FOUND", path, recorder.Code, envelope.Error.Code)
+		}
+
+	}
+
+}
@@ -0,0 +1,97 @@
+package routes
+
+import (
+	"github.com/aaravmahajanofficial/ecommerce-project/controllers"
+	"github.com/aaravmahajanofficial/ecommerce-project/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+const APIPrefix = "/api/v1"
+
+// shared by the legacy and the v1 suggestion route, a client gets one budget whichever of them it calls
+var suggestionLimiter = middleware.NewIPRateLimiter(10, 20)
+
+// path parameters are read by the handlers themselves, see the "uri" tags of their request structs,
+// and the user always comes from the token
+
+func V1Routes(incomingRoutes *gin.Engine, app *controllers.Application) {
+
+	v1 := incomingRoutes.Group(APIPrefix)
+
+	v1.POST("/users/signup", controllers.SignUp())
+	v1.POST("/users/login", controllers.Login())
+
+	v1.GET("/products", controllers.SearchProduct())
+	v1.GET("/products/search", controllers.SearchProductByQuery())
+	v1.GET("/products/:id/reviews", controllers.GetProductReviews())
+	v1.GET("/search/suggestions", middleware.RateLimit(suggestionLimiter), controllers.SuggestSearch())
+	v1.GET("/categories", controllers.GetCategoryTree())
+	v1.GET("/categories/:slug/products", controllers.GetCategoryProducts())
+	v1.GET("/exchange-rates", controllers.ExchangeRates())
+	v1.GET("/images/*key", controllers.ServeImage())
+	v1.GET("/wishlists/shared/:token", controllers.GetSharedWishlist())
+	v1.POST("/addresses/validate", controllers.ValidateAddress())
+	v1.GET("/postal-codes/:code", controllers.LookupPostalCode())
+	v1.POST("/carriers/:carrier/webhook", controllers.CarrierWebhook())
+
+	v1.GET("/guest/cart", controllers.GetGuestCart())
+	v1.POST("/guest/cart/items", controllers.AddToGuestCart())
+	v1.DELETE("/guest/cart/items/:id", controllers.RemoveFromGuestCart())
+
+	user := v1.Group("", middleware.Authorization())
+
+	user.GET("/cart", controllers.GetItemFromCart())
+	user.POST("/cart/items", app.AddToCart())
+	user.DELETE("/cart/items/:id", app.RemoveItem())
+	user.POST("/cart/items/:id/move-to-wishlist", controllers.MoveToWishlist())
+	user.POST("/cart/acknowledge", controllers.AcknowledgeCartChanges())
+
+	user.POST("/orders", app.BuyFromCart())
+	user.POST("/orders/instant", app.InstantBuy())
+	user.GET("/orders/:id/tracking", controllers.TrackOrder())
+
+	user.GET("/addresses", controllers.ListAddresses())
+	user.POST("/addresses", controllers.CreateAddress())
+	user.DELETE("/addresses", controllers.DeleteAddress())
+	user.PUT("/addresses/:id", controllers.UpdateAddress())
+	user.DELETE("/addresses/:id", controllers.RemoveAddress())
+	user.PUT("/addresses/:id/default", controllers.SetDefaultAddress())
+
+	user.GET("/wishlists", controllers.GetWishlists())
+	user.POST("/wishlists", controllers.CreateWishlist())
+	user.GET("/wishlists/:id", controllers.GetWishlists())
+	user.PUT("/wishlists/:id", controllers.RenameWishlist())
+	user.DELETE("/wishlists/:id", controllers.DeleteWishlist())
+	user.PUT("/wishlists/:id/items/:productID", controllers.AddWishlistItem())
+	user.DELETE("/wishlists/:id/items/:productID", controllers.RemoveWishlistItem())
+	user.POST("/wishlists/:id/items/:productID/move-to-cart", controllers.MoveToCart())
+	user.POST("/wishlists/:id/share", controllers.ShareWishlist())
+	user.DELETE("/wishlists/:id/share", controllers.UnshareWishlist())
+
+	user.POST("/products/:id/reviews", controllers.CreateReview())
+	user.PUT("/reviews/:id", controllers.UpdateReview())
+	user.DELETE("/reviews/:id", controllers.DeleteReview())
+	user.POST("/reviews/:id/helpful", controllers.VoteReviewHelpful())
+
+	admin := user.Group("/admin", middleware.AdminAuthorization())
+
+	admin.POST("/orders/:id/shipments", controllers.CreateShipment())
+	admin.POST("/shipments/:id/ship", controllers.ShipShipment())
+	admin.POST("/categories", controllers.CreateCategory())
+	admin.PUT("/categories/:id", controllers.UpdateCategory())
+	admin.DELETE("/categories/:id", controllers.DeleteCategory())
+	admin.PUT("/products/:id/categories", controllers.SetProductCategories())
+	admin.PUT("/products/:id/variants", controllers.SetProductVariants())
+	admin.POST("/products/:id/images", controllers.UploadProductImage())
+	admin.PUT("/products/:id/images/order", controllers.ReorderProductImages())
+	admin.DELETE("/products/:id/images/:imageID", controllers.DeleteProductImage())
+	admin.POST("/products/imports", controllers.ImportProducts())
+	admin.GET("/products/imports/:id", controllers.GetImportJob())
+	admin.GET("/products/export", controllers.ExportProducts())
+	admin.PUT("/reviews/:id/moderation", controllers.ModerateReview())
+	admin.GET("/carts/abandoned", controllers.GetAbandonedCartMetrics())
+	admin.POST("/carts/abandoned/reminders", controllers.SendCartReminders())
+	admin.GET("/log-level", controllers.GetLogLevel())
+	admin.PUT("/log-level", controllers.SetLogLevel())
+
+}
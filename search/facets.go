@@ -0,0 +1,230 @@
+package search
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strconv"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// bucket boundaries in minor units of the base currency, the last bucket is open ended
+// eg : 0-500, 500-1000, 1000-2500, 2500-5000, 5000-10000, 10000+ in INR
+var PriceBucketBoundaries = []int64{0, 50000, 100000, 250000, 500000, 1000000}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"`
+	Count int64  `json:"count"`
+}
+
+type PriceBucket struct {
+	Min   int64  `json:"min"`
+	Max   *int64 `json:"max"`
+	Count int64  `json:"count"`
+}
+
+type Facets struct {
+	Categories []FacetCount            `json:"categories"`
+	Prices     []PriceBucket           `json:"prices"`
+	Ratings    []FacetCount            `json:"ratings"`
+	Brands     []FacetCount            `json:"brands"`
+	Attributes map[string][]FacetCount `json:"attributes"`
+}
+
+// the sub pipelines run inside the same $facet as the results, so every count reflects the filters already selected
+
+func facetPipelines() bson.D {
+
+	boundaries := bson.A{}
+
+	for _, boundary := range PriceBucketBoundaries {
+		boundaries = append(boundaries, boundary)
+	}
+
+	// $bucket needs an upper bound, so one past the last boundary closes the open ended bucket
+	boundaries = append(boundaries, int64(1)<<62)
+
+	return bson.D{
+		primitive.E{Key: "categories", Value: bson.A{
+			bson.D{{Key: "$unwind", Value: "$category_ids"}},
+			bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$category_ids"}, primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}}}}},
+		}},
+		primitive.E{Key: "prices", Value: bson.A{
+			bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "price.amount", Value: bson.D{primitive.E{Key: "$type", Value: "number"}}}}}},
+			bson.D{{Key: "$bucket", Value: bson.D{
+				primitive.E{Key: "groupBy", Value: "$price.amount"},
+				primitive.E{Key: "boundaries", Value: boundaries},
+				primitive.E{Key: "default", Value: "other"},
+			}}},
+		}},
+		primitive.E{Key: "ratings", Value: bson.A{
+			bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "rating", Value: bson.D{primitive.E{Key: "$type", Value: "number"}}}}}},
+			bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$floor", Value: "$rating"}}}, primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}}}}},
+		}},
+		primitive.E{Key: "brands", Value: bson.A{
+			bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "brand", Value: bson.D{primitive.E{Key: "$type", Value: "string"}}}}}},
+			bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$brand"}, primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}}}}},
+		}},
+		// a product counts once per option value even when several of its variants share it
+		primitive.E{Key: "attributes", Value: bson.A{
+			bson.D{{Key: "$unwind", Value: "$variants"}},
+			bson.D{{Key: "$project", Value: bson.D{primitive.E{Key: "option", Value: bson.D{primitive.E{Key: "$objectToArray", Value: "$variants.options"}}}}}},
+			bson.D{{Key: "$unwind", Value: "$option"}},
+			bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "name", Value: "$option.k"}, primitive.E{Key: "value", Value: "$option.v"}}}, primitive.E{Key: "products", Value: bson.D{primitive.E{Key: "$addToSet", Value: "$_id"}}}}}},
+			bson.D{{Key: "$project", Value: bson.D{primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$size", Value: "$products"}}}}}},
+		}},
+	}
+
+}
+
+type rawFacets struct {
+	Categories []struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Count int64              `bson:"count"`
+	} `bson:"categories"`
+	Prices []struct {
+		ID    interface{} `bson:"_id"`
+		Count int64       `bson:"count"`
+	} `bson:"prices"`
+	Ratings []struct {
+		ID    float64 `bson:"_id"`
+		Count int64   `bson:"count"`
+	} `bson:"ratings"`
+	Brands []struct {
+		ID    string `bson:"_id"`
+		Count int64  `bson:"count"`
+	} `bson:"brands"`
+	Attributes []struct {
+		ID struct {
+			Name  string `bson:"name"`
+			Value string `bson:"value"`
+		} `bson:"_id"`
+		Count int64 `bson:"count"`
+	} `bson:"attributes"`
+}
+
+func buildFacets(context context.Context, categoriesCollection *mongo.Collection, raw rawFacets) *Facets {
+
+	facets := &Facets{
+		Categories: make([]FacetCount, 0, len(raw.Categories)),
+		Prices:     make([]PriceBucket, 0, len(PriceBucketBoundaries)),
+		Ratings:    make([]FacetCount, 0, 5),
+		Brands:     make([]FacetCount, 0, len(raw.Brands)),
+		Attributes: make(map[string][]FacetCount),
+	}
+
+	// category facets are shown by slug and name, the ids are resolved in one query
+	categoryIDs := make([]primitive.ObjectID, 0, len(raw.Categories))
+
+	for _, category := range raw.Categories {
+		categoryIDs = append(categoryIDs, category.ID)
+	}
+
+	categories := make(map[primitive.ObjectID]models.Category, len(categoryIDs))
+
+	if len(categoryIDs) > 0 {
+
+		cursor, err := categoriesCollection.Find(context, bson.D{primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$in", Value: categoryIDs}}}})
+
+		if err == nil {
+			var found []models.Category
+			if err = cursor.All(context, &found); err == nil {
+				for _, category := range found {
+					categories[category.Category_ID] = category
+				}
+			}
+		}
+
+		if err != nil {
+			slog.ErrorContext(context, "buildFacets failed", "error", err)
+		}
+
+	}
+
+	for _, entry := range raw.Categories {
+
+		category, ok := categories[entry.ID]
+
+		// products can still point at a category that was removed in the meantime
+		if !ok {
+			continue
+		}
+
+		facets.Categories = append(facets.Categories, FacetCount{Value: category.Slug, Label: *category.Name, Count: entry.Count})
+
+	}
+
+	bucketCounts := make(map[int64]int64, len(raw.Prices))
+
+	for _, bucket := range raw.Prices {
+		switch lower := bucket.ID.(type) {
+		case int64:
+			bucketCounts[lower] = bucket.Count
+		case int32:
+			bucketCounts[int64(lower)] = bucket.Count
+		}
+	}
+
+	for index, boundary := range PriceBucketBoundaries {
+
+		bucket := PriceBucket{Min: boundary, Count: bucketCounts[boundary]}
+
+		if index+1 < len(PriceBucketBoundaries) {
+			upper := PriceBucketBoundaries[index+1]
+			bucket.Max = &upper
+		}
+
+		facets.Prices = append(facets.Prices, bucket)
+
+	}
+
+	// ratings are shown as "4 & up", so each bucket includes every higher rating
+	ratingCounts := make(map[int]int64, len(raw.Ratings))
+
+	for _, rating := range raw.Ratings {
+		ratingCounts[int(rating.ID)] += rating.Count
+	}
+
+	var cumulative int64
+
+	for stars := 5; stars >= 1; stars-- {
+
+		cumulative += ratingCounts[stars]
+		facets.Ratings = append(facets.Ratings, FacetCount{Value: strconv.Itoa(stars), Label: strconv.Itoa(stars) + " & up", Count: cumulative})
+
+	}
+
+	for _, brand := range raw.Brands {
+		facets.Brands = append(facets.Brands, FacetCount{Value: brand.ID, Count: brand.Count})
+	}
+
+	for _, attribute := range raw.Attributes {
+		facets.Attributes[attribute.ID.Name] = append(facets.Attributes[attribute.ID.Name], FacetCount{Value: attribute.ID.Value, Count: attribute.Count})
+	}
+
+	sortFacetCounts(facets.Categories)
+	sortFacetCounts(facets.Brands)
+
+	for name := range facets.Attributes {
+		sortFacetCounts(facets.Attributes[name])
+	}
+
+	return facets
+
+}
+
+func sortFacetCounts(counts []FacetCount) {
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Value < counts[j].Value
+	})
+
+}
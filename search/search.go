@@ -0,0 +1,323 @@
+package search
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/database"
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrEmptyQuery = errors.New("search query has no searchable terms")
+
+// the default sort of search results, any listing sort can be used instead
+const SortRelevance = "relevance"
+
+// a product has to share this share of the query trigrams to be returned as a fuzzy match
+var FuzzyThreshold = 0.35
+
+// text index weights, a match in the name counts ten times a match in the description
+var FieldWeights = bson.D{
+	primitive.E{Key: "product_name", Value: 10},
+	primitive.E{Key: "tags", Value: 5},
+	primitive.E{Key: "description", Value: 1},
+}
+
+type Hit struct {
+	Product    models.Product    `json:"product"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+type Page struct {
+	Items       []Hit  `json:"items"`
+	Next_Cursor string `json:"next_cursor"`
+	Total       int64  `json:"total"`
+	Limit       int    `json:"limit"`
+	// set when nothing matched exactly and the results come from trigram matching
+	Fuzzy  bool    `json:"fuzzy"`
+	Facets *Facets `json:"facets"`
+}
+
+func EnsureSearchIndexes(context context.Context, productsCollection *mongo.Collection) error {
+
+	_, err := productsCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		{
+			Keys:    bson.D{primitive.E{Key: "product_name", Value: "text"}, primitive.E{Key: "tags", Value: "text"}, primitive.E{Key: "description", Value: "text"}},
+			Options: options.Index().SetName("product_text").SetWeights(FieldWeights),
+		},
+		{Keys: bson.D{primitive.E{Key: "search_grams", Value: 1}}},
+	})
+
+	if err != nil {
+		slog.ErrorContext(context, "EnsureSearchIndexes failed", "error", err)
+	}
+
+	return err
+
+}
+
+// recomputes the stored trigrams of every product matching the filter, called after products are written
+// and at startup for products saved before trigrams existed
+
+func RefreshSearchGrams(context context.Context, productsCollection *mongo.Collection, filter bson.D) error {
+
+	projection := bson.D{primitive.E{Key: "product_name", Value: 1}, primitive.E{Key: "tags", Value: 1}}
+	cursor, err := productsCollection.Find(context, filter, options.Find().SetProjection(projection))
+
+	if err != nil {
+		slog.ErrorContext(context, "RefreshSearchGrams failed", "error", err)
+		return database.ErrCantFindProduct
+	}
+
+	defer cursor.Close(context)
+
+	writes := make([]mongo.WriteModel, 0)
+
+	for cursor.Next(context) {
+
+		var product models.Product
+
+		if err = cursor.Decode(&product); err != nil {
+			slog.ErrorContext(context, "RefreshSearchGrams failed", "error", err)
+			return database.ErrCantDecodeProducts
+		}
+
+		name := ""
+
+		if product.Product_Name != nil {
+			name = *product.Product_Name
+		}
+
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{primitive.E{Key: "_id", Value: product.Product_ID}}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "search_grams", Value: ProductGrams(name, product.Tags)}}}}))
+
+	}
+
+	if len(writes) == 0 {
+		return nil
+	}
+
+	if _, err = productsCollection.BulkWrite(context, writes); err != nil {
+		slog.ErrorContext(context, "RefreshSearchGrams failed", "error", err)
+		return database.ErrCantUpdateProductInfo
+	}
+
+	return nil
+
+}
+
+func BackfillSearchGrams(context context.Context, productsCollection *mongo.Collection) error {
+	return RefreshSearchGrams(context, productsCollection, bson.D{primitive.E{Key: "search_grams", Value: bson.D{primitive.E{Key: "$exists", Value: false}}}})
+}
+
+// relevance ordering cannot be resumed from a sort value, so search cursors carry the offset instead
+
+func encodeOffset(offset int) string {
+
+	data, _ := bson.Marshal(bson.D{primitive.E{Key: "o", Value: int64(offset)}})
+	return base64.RawURLEncoding.EncodeToString(data)
+
+}
+
+func decodeOffset(cursor string) (int, error) {
+
+	if cursor == "" {
+		return 0, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+
+	if err != nil {
+		return 0, database.ErrInvalidCursor
+	}
+
+	var decoded struct {
+		Offset int64 `bson:"o"`
+	}
+
+	if err = bson.Unmarshal(data, &decoded); err != nil || decoded.Offset < 0 {
+		return 0, database.ErrInvalidCursor
+	}
+
+	return int(decoded.Offset), nil
+
+}
+
+// runs the text search first, when it finds nothing the same filters are retried with trigram similarity
+// so "snekers" still finds "Sneakers"
+
+func Search(context context.Context, productsCollection *mongo.Collection, categoriesCollection *mongo.Collection, query string, listQuery database.ProductListQuery) (Page, error) {
+
+	terms := Terms(query)
+
+	if len(terms) == 0 {
+		return Page{}, ErrEmptyQuery
+	}
+
+	if listQuery.Limit <= 0 {
+		listQuery.Limit = database.DefaultPageSize
+	}
+
+	if listQuery.Limit > database.MaxPageSize {
+		listQuery.Limit = database.MaxPageSize
+	}
+
+	offset, err := decodeOffset(listQuery.Cursor)
+
+	if err != nil {
+		return Page{}, err
+	}
+
+	sortOrder := bson.D{primitive.E{Key: "score", Value: -1}, primitive.E{Key: "_id", Value: 1}}
+
+	if listQuery.Sort != "" && listQuery.Sort != SortRelevance {
+
+		order, ok := database.ProductSortOrder(listQuery.Sort)
+
+		if !ok {
+			return Page{}, database.ErrInvalidSort
+		}
+
+		sortOrder = order
+
+	}
+
+	// $text has to be the first condition of the first stage
+	textFilter := bson.D{primitive.E{Key: "$text", Value: bson.D{primitive.E{Key: "$search", Value: TextSearchString(terms)}}}}
+	filter, err := database.ProductListFilter(context, categoriesCollection, textFilter, listQuery)
+
+	if err != nil {
+		return Page{}, err
+	}
+
+	page, err := runSearch(context, productsCollection, categoriesCollection, filter, bson.A{
+		bson.D{{Key: "$addFields", Value: bson.D{primitive.E{Key: "score", Value: bson.D{primitive.E{Key: "$meta", Value: "textScore"}}}}}},
+	}, sortOrder, offset, listQuery.Limit, terms)
+
+	if err != nil || page.Total > 0 {
+		return page, err
+	}
+
+	queryGrams := Grams(query)
+	gramFilter := bson.D{primitive.E{Key: "search_grams", Value: bson.D{primitive.E{Key: "$in", Value: queryGrams}}}}
+
+	if filter, err = database.ProductListFilter(context, categoriesCollection, gramFilter, listQuery); err != nil {
+		return Page{}, err
+	}
+
+	// similarity is the share of the query trigrams the product also has
+	page, err = runSearch(context, productsCollection, categoriesCollection, filter, bson.A{
+		bson.D{{Key: "$addFields", Value: bson.D{primitive.E{Key: "score", Value: bson.D{primitive.E{Key: "$divide", Value: bson.A{
+			bson.D{primitive.E{Key: "$size", Value: bson.D{primitive.E{Key: "$setIntersection", Value: bson.A{"$search_grams", queryGrams}}}}},
+			len(queryGrams),
+		}}}}}}},
+		bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "score", Value: bson.D{primitive.E{Key: "$gte", Value: FuzzyThreshold}}}}}},
+	}, sortOrder, offset, listQuery.Limit, terms)
+
+	page.Fuzzy = true
+
+	return page, err
+
+}
+
+func runSearch(context context.Context, productsCollection *mongo.Collection, categoriesCollection *mongo.Collection, filter bson.D, scoring bson.A, sortOrder bson.D, offset int, limit int, terms []string) (Page, error) {
+
+	pipeline := bson.A{bson.D{{Key: "$match", Value: filter}}}
+	pipeline = append(pipeline, scoring...)
+	facetStages := bson.D{
+		primitive.E{Key: "total", Value: bson.A{bson.D{{Key: "$count", Value: "count"}}}},
+		primitive.E{Key: "items", Value: bson.A{
+			bson.D{{Key: "$sort", Value: sortOrder}},
+			bson.D{{Key: "$skip", Value: offset}},
+			bson.D{{Key: "$limit", Value: limit + 1}},
+		}},
+	}
+	facetStages = append(facetStages, facetPipelines()...)
+	pipeline = append(pipeline, bson.D{{Key: "$facet", Value: facetStages}})
+
+	cursor, err := productsCollection.Aggregate(context, pipeline)
+
+	if err != nil {
+		slog.ErrorContext(context, "runSearch failed", "error", err)
+		return Page{}, database.ErrCantFindProduct
+	}
+
+	defer cursor.Close(context)
+
+	var results []struct {
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+		Items []struct {
+			models.Product `bson:",inline"`
+			Score          float64 `bson:"score"`
+		} `bson:"items"`
+		Facets rawFacets `bson:",inline"`
+	}
+
+	if err = cursor.All(context, &results); err != nil {
+		slog.ErrorContext(context, "runSearch failed", "error", err)
+		return Page{}, database.ErrCantDecodeProducts
+	}
+
+	page := Page{Items: make([]Hit, 0, limit), Limit: limit}
+
+	if len(results) == 0 {
+		return page, nil
+	}
+
+	if len(results[0].Total) > 0 {
+		page.Total = results[0].Total[0].Count
+	}
+
+	page.Facets = buildFacets(context, categoriesCollection, results[0].Facets)
+
+	items := results[0].Items
+
+	if len(items) > limit {
+		items = items[:limit]
+		page.Next_Cursor = encodeOffset(offset + limit)
+	}
+
+	for _, item := range items {
+		page.Items = append(page.Items, Hit{Product: item.Product, Score: item.Score, Highlights: highlights(item.Product, terms)})
+	}
+
+	return page, nil
+
+}
+
+func highlights(product models.Product, terms []string) map[string]string {
+
+	result := make(map[string]string)
+
+	if product.Product_Name != nil {
+		if highlighted, matched := Highlight(*product.Product_Name, terms); matched {
+			result["product_name"] = highlighted
+		}
+	}
+
+	if product.Description != nil {
+		if snippet, matched := Snippet(*product.Description, terms); matched {
+			result["description"] = snippet
+		}
+	}
+
+	for _, tag := range product.Tags {
+		if highlighted, matched := Highlight(tag, terms); matched {
+			result["tags"] = highlighted
+			break
+		}
+	}
+
+	return result
+
+}
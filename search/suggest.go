@@ -0,0 +1,424 @@
+package search
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aaravmahajanofficial/ecommerce-project/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultSuggestLimit = 5
+	MaxSuggestLimit     = 10
+	// bounds the work of a single lookup on very short prefixes
+	maxPrefixScan = 500
+	// the popular query table keeps at most this many distinct queries
+	maxTrackedQueries = 10000
+)
+
+type Suggestion struct {
+	Text string `json:"text"`
+	ID   string `json:"id,omitempty"`
+	Slug string `json:"slug,omitempty"`
+}
+
+type SuggestResult struct {
+	Query      string       `json:"query"`
+	Products   []Suggestion `json:"products"`
+	Categories []Suggestion `json:"categories"`
+	Queries    []Suggestion `json:"queries"`
+}
+
+// every word position of a name gets its own key, so "sho" finds "Running Shoes" as well as "Shoe Rack"
+
+type suggestEntry struct {
+	key        string
+	suggestion Suggestion
+	weight     float64
+}
+
+type SuggestIndex struct {
+	mutex      sync.RWMutex
+	products   []suggestEntry
+	categories []suggestEntry
+
+	queriesMutex sync.Mutex
+	queries      map[string]int
+
+	dirty   atomic.Bool
+	polling atomic.Bool
+	running atomic.Bool
+}
+
+var Suggestions = NewSuggestIndex()
+
+func NewSuggestIndex() *SuggestIndex {
+
+	return &SuggestIndex{
+		products:   make([]suggestEntry, 0),
+		categories: make([]suggestEntry, 0),
+		queries:    make(map[string]int),
+	}
+}
+
+func normalize(text string) string {
+	return strings.Join(Terms(text), " ")
+}
+
+func entriesFor(text string, suggestion Suggestion, weight float64) []suggestEntry {
+
+	words := Terms(text)
+	entries := make([]suggestEntry, 0, len(words))
+
+	for index := range words {
+		entries = append(entries, suggestEntry{key: strings.Join(words[index:], " "), suggestion: suggestion, weight: weight})
+	}
+
+	return entries
+
+}
+
+func sortEntries(entries []suggestEntry) {
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+}
+
+// loads names only, the whole catalog fits in memory as a sorted key list
+
+func (index *SuggestIndex) Rebuild(context context.Context, productsCollection *mongo.Collection, categoriesCollection *mongo.Collection) error {
+
+	projection := bson.D{primitive.E{Key: "product_name", Value: 1}, primitive.E{Key: "rating", Value: 1}}
+	cursor, err := productsCollection.Find(context, bson.D{}, options.Find().SetProjection(projection))
+
+	if err != nil {
+		slog.ErrorContext(context, "Rebuild failed", "error", err)
+		return err
+	}
+
+	var products []models.Product
+
+	if err = cursor.All(context, &products); err != nil {
+		slog.ErrorContext(context, "Rebuild failed", "error", err)
+		return err
+	}
+
+	cursor, err = categoriesCollection.Find(context, bson.D{}, options.Find().SetProjection(bson.D{primitive.E{Key: "name", Value: 1}, primitive.E{Key: "slug", Value: 1}}))
+
+	if err != nil {
+		slog.ErrorContext(context, "Rebuild failed", "error", err)
+		return err
+	}
+
+	var categories []models.Category
+
+	if err = cursor.All(context, &categories); err != nil {
+		slog.ErrorContext(context, "Rebuild failed", "error", err)
+		return err
+	}
+
+	productEntries := make([]suggestEntry, 0, len(products)*3)
+
+	for _, product := range products {
+
+		if product.Product_Name == nil {
+			continue
+		}
+
+		weight := 0.0
+
+		if product.Rating != nil {
+			weight = *product.Rating
+		}
+
+		suggestion := Suggestion{Text: *product.Product_Name, ID: product.Product_ID.Hex()}
+		productEntries = append(productEntries, entriesFor(*product.Product_Name, suggestion, weight)...)
+
+	}
+
+	categoryEntries := make([]suggestEntry, 0, len(categories)*2)
+
+	for _, category := range categories {
+
+		if category.Name == nil {
+			continue
+		}
+
+		suggestion := Suggestion{Text: *category.Name, Slug: category.Slug}
+		categoryEntries = append(categoryEntries, entriesFor(*category.Name, suggestion, 0)...)
+
+	}
+
+	sortEntries(productEntries)
+	sortEntries(categoryEntries)
+
+	index.mutex.Lock()
+	index.products = productEntries
+	index.categories = categoryEntries
+	index.mutex.Unlock()
+
+	return nil
+
+}
+
+// marks the index stale, the next refresh tick rebuilds it
+
+func (index *SuggestIndex) MarkDirty() {
+	index.dirty.Store(true)
+}
+
+func lookup(entries []suggestEntry, prefix string, limit int) []Suggestion {
+
+	start := sort.Search(len(entries), func(i int) bool {
+		return entries[i].key >= prefix
+	})
+
+	matches := make([]suggestEntry, 0, limit)
+	seen := make(map[Suggestion]bool)
+
+	for position := start; position < len(entries) && position-start < maxPrefixScan; position++ {
+
+		entry := entries[position]
+
+		if !strings.HasPrefix(entry.key, prefix) {
+			break
+		}
+
+		if seen[entry.suggestion] {
+			continue
+		}
+
+		seen[entry.suggestion] = true
+		matches = append(matches, entry)
+
+	}
+
+	// a match at the start of the name ranks above one in the middle, then the weight decides
+	sort.SliceStable(matches, func(i, j int) bool {
+
+		iLeading := strings.HasPrefix(normalize(matches[i].suggestion.Text), prefix)
+		jLeading := strings.HasPrefix(normalize(matches[j].suggestion.Text), prefix)
+
+		if iLeading != jLeading {
+			return iLeading
+		}
+
+		return matches[i].weight > matches[j].weight
+
+	})
+
+	suggestions := make([]Suggestion, 0, limit)
+
+	for _, match := range matches {
+
+		if len(suggestions) == limit {
+			break
+		}
+
+		suggestions = append(suggestions, match.suggestion)
+
+	}
+
+	return suggestions
+
+}
+
+func (index *SuggestIndex) Lookup(query string, limit int) SuggestResult {
+
+	if limit <= 0 {
+		limit = DefaultSuggestLimit
+	}
+
+	if limit > MaxSuggestLimit {
+		limit = MaxSuggestLimit
+	}
+
+	prefix := normalize(query)
+	result := SuggestResult{Query: query, Products: make([]Suggestion, 0), Categories: make([]Suggestion, 0), Queries: make([]Suggestion, 0)}
+
+	if prefix == "" {
+		return result
+	}
+
+	index.mutex.RLock()
+	result.Products = lookup(index.products, prefix, limit)
+	result.Categories = lookup(index.categories, prefix, limit)
+	index.mutex.RUnlock()
+
+	result.Queries = index.popularQueries(prefix, limit)
+
+	return result
+
+}
+
+// only queries that returned results are recorded, so typos do not get suggested back to everyone
+
+func (index *SuggestIndex) RecordQuery(query string) {
+
+	normalized := normalize(query)
+
+	if normalized == "" {
+		return
+	}
+
+	index.queriesMutex.Lock()
+	defer index.queriesMutex.Unlock()
+
+	if _, known := index.queries[normalized]; !known && len(index.queries) >= maxTrackedQueries {
+		index.evictLeastPopular()
+	}
+
+	index.queries[normalized]++
+
+}
+
+func (index *SuggestIndex) evictLeastPopular() {
+
+	leastQuery := ""
+	leastCount := 0
+
+	for query, count := range index.queries {
+		if leastQuery == "" || count < leastCount {
+			leastQuery, leastCount = query, count
+		}
+	}
+
+	delete(index.queries, leastQuery)
+
+}
+
+func (index *SuggestIndex) popularQueries(prefix string, limit int) []Suggestion {
+
+	index.queriesMutex.Lock()
+
+	type counted struct {
+		query string
+		count int
+	}
+
+	matches := make([]counted, 0)
+
+	for query, count := range index.queries {
+		if strings.HasPrefix(query, prefix) {
+			matches = append(matches, counted{query, count})
+		}
+	}
+
+	index.queriesMutex.Unlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].count != matches[j].count {
+			return matches[i].count > matches[j].count
+		}
+		return matches[i].query < matches[j].query
+	})
+
+	suggestions := make([]Suggestion, 0, limit)
+
+	for _, match := range matches {
+
+		if len(suggestions) == limit {
+			break
+		}
+
+		suggestions = append(suggestions, Suggestion{Text: match.query})
+
+	}
+
+	return suggestions
+
+}
+
+// keeps the index in step with the collections until the context is cancelled
+// a change stream marks it dirty as soon as products or categories change, on a standalone mongo without
+// change streams the index is simply rebuilt on every tick
+
+func (index *SuggestIndex) Run(context context.Context, productsCollection *mongo.Collection, categoriesCollection *mongo.Collection, interval time.Duration) {
+
+	index.running.Store(true)
+	defer index.running.Store(false)
+
+	if err := index.Rebuild(context, productsCollection, categoriesCollection); err != nil {
+		slog.ErrorContext(context, "suggestion index build failed", "error", err)
+	}
+
+	if !index.watch(context, productsCollection) || !index.watch(context, categoriesCollection) {
+		index.fallBackToPolling()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+
+		select {
+		case <-context.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !index.polling.Load() && !index.dirty.Load() {
+			continue
+		}
+
+		index.dirty.Store(false)
+
+		if err := index.Rebuild(context, productsCollection, categoriesCollection); err != nil {
+			slog.ErrorContext(context, "suggestion index rebuild failed", "error", err)
+			index.dirty.Store(true)
+		}
+
+	}
+
+}
+
+func (index *SuggestIndex) Running() bool {
+	return index.running.Load()
+}
+
+func (index *SuggestIndex) watch(context context.Context, collection *mongo.Collection) bool {
+
+	stream, err := collection.Watch(context, mongo.Pipeline{})
+
+	if err != nil {
+		return false
+	}
+
+	go func() {
+
+		defer stream.Close(context)
+
+		for stream.Next(context) {
+			index.MarkDirty()
+		}
+
+		// a broken stream would leave the index stale forever, polling takes over instead
+		if context.Err() == nil {
+			slog.WarnContext(context, "suggestion change stream closed", "error", stream.Err())
+			index.fallBackToPolling()
+		}
+
+	}()
+
+	return true
+
+}
+
+func (index *SuggestIndex) fallBackToPolling() {
+
+	if !index.polling.Swap(true) {
+		slog.Info("change streams unavailable, suggestions are rebuilt on every refresh tick")
+	}
+
+}
@@ -0,0 +1,170 @@
+package search
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	MaxQueryLength = 100
+	MaxQueryTerms  = 10
+	snippetLength  = 160
+)
+
+// splits on anything that is not a letter or a digit, so user input can never carry text search operators
+// eg : `"red" -shoes\` -> ["red", "shoes"]
+
+func Terms(query string) []string {
+
+	if len(query) > MaxQueryLength {
+		query = query[:MaxQueryLength]
+	}
+
+	fields := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	if len(fields) > MaxQueryTerms {
+		fields = fields[:MaxQueryTerms]
+	}
+
+	return fields
+
+}
+
+// the $text search string is rebuilt from the sanitized terms, quotes and leading dashes never reach mongo
+
+func TextSearchString(terms []string) string {
+	return strings.Join(terms, " ")
+}
+
+// trigrams of every word, padded so prefixes and suffixes weigh more
+// eg : "shoe" -> [" sh", "sho", "hoe", "oe "]
+
+func Grams(text string) []string {
+
+	seen := make(map[string]bool)
+	grams := make([]string, 0)
+
+	for _, word := range Terms(text) {
+
+		runes := []rune(" " + word + " ")
+
+		for index := 0; index+3 <= len(runes); index++ {
+
+			gram := string(runes[index : index+3])
+
+			if !seen[gram] {
+				seen[gram] = true
+				grams = append(grams, gram)
+			}
+
+		}
+
+	}
+
+	return grams
+
+}
+
+// grams are built from the fields a shopper is likely to misspell, the description is left out to keep them small
+
+func ProductGrams(name string, tags []string) []string {
+	return Grams(name + " " + strings.Join(tags, " "))
+}
+
+// wraps every term match in <em>, the rest of the text is html escaped so stored content cannot inject markup
+// fuzzy matches are highlighted by the words of the text that share a prefix with a term
+
+func Highlight(text string, terms []string) (string, bool) {
+
+	if text == "" || len(terms) == 0 {
+		return html.EscapeString(text), false
+	}
+
+	patterns := make([]string, 0, len(terms))
+
+	for _, term := range terms {
+
+		prefix := []rune(term)
+
+		if len(prefix) > 3 {
+			prefix = prefix[:3]
+		}
+
+		patterns = append(patterns, regexp.QuoteMeta(term), `\b`+regexp.QuoteMeta(string(prefix))+`\w*`)
+
+	}
+
+	matcher := regexp.MustCompile(`(?i)(` + strings.Join(patterns, "|") + `)`)
+	matches := matcher.FindAllStringIndex(text, -1)
+
+	if len(matches) == 0 {
+		return html.EscapeString(text), false
+	}
+
+	var builder strings.Builder
+	last := 0
+
+	for _, match := range matches {
+		builder.WriteString(html.EscapeString(text[last:match[0]]))
+		builder.WriteString("<em>")
+		builder.WriteString(html.EscapeString(text[match[0]:match[1]]))
+		builder.WriteString("</em>")
+		last = match[1]
+	}
+
+	builder.WriteString(html.EscapeString(text[last:]))
+
+	return builder.String(), true
+
+}
+
+// cuts a window of the text around the first match before highlighting it
+
+func Snippet(text string, terms []string) (string, bool) {
+
+	runes := []rune(text)
+
+	if len(runes) <= snippetLength {
+		return Highlight(text, terms)
+	}
+
+	lower := strings.ToLower(text)
+	start := 0
+
+	for _, term := range terms {
+		if index := strings.Index(lower, term); index >= 0 {
+			start = len([]rune(lower[:index]))
+			break
+		}
+	}
+
+	start -= snippetLength / 4
+
+	if start < 0 {
+		start = 0
+	}
+
+	end := start + snippetLength
+
+	if end > len(runes) {
+		end = len(runes)
+		start = end - snippetLength
+	}
+
+	snippet := string(runes[start:end])
+
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+
+	if end < len(runes) {
+		snippet += "…"
+	}
+
+	return Highlight(snippet, terms)
+
+}
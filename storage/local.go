@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// objects are plain files, the content type is kept next to each file in a small sidecar
+
+type LocalStorage struct {
+	root      string
+	urlPrefix string
+}
+
+func NewLocalStorage(root string, urlPrefix string) (*LocalStorage, error) {
+
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &LocalStorage{root: root, urlPrefix: urlPrefix}, nil
+
+}
+
+// keys may never leave the storage root, eg : "../../etc/passwd" is rejected
+
+func (local *LocalStorage) path(key string) (string, error) {
+
+	cleaned := filepath.Clean("/" + key)
+
+	if key == "" || cleaned != "/"+key || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+
+	return filepath.Join(local.root, filepath.FromSlash(cleaned)), nil
+
+}
+
+type localMetadata struct {
+	Content_Type string `json:"content_type"`
+}
+
+func (local *LocalStorage) Put(ctx context.Context, key string, contentType string, body io.Reader) error {
+
+	path, err := local.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// written to a temporary file first so readers never see half an image
+	temporary, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temporary.Name())
+
+	if _, err = io.Copy(temporary, body); err != nil {
+		temporary.Close()
+		return err
+	}
+
+	if err = temporary.Close(); err != nil {
+		return err
+	}
+
+	metadata, _ := json.Marshal(localMetadata{Content_Type: contentType})
+
+	if err = os.WriteFile(path+".meta", metadata, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(temporary.Name(), path)
+
+}
+
+func (local *LocalStorage) Get(ctx context.Context, key string) (io.ReadSeekCloser, ObjectInfo, error) {
+
+	path, err := local.path(key)
+
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	file, err := os.Open(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ObjectInfo{}, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	stat, err := file.Stat()
+
+	if err != nil || stat.IsDir() {
+		file.Close()
+		return nil, ObjectInfo{}, ErrNotFound
+	}
+
+	info := ObjectInfo{Size: stat.Size(), Modified_At: stat.ModTime()}
+
+	var metadata localMetadata
+
+	if data, err := os.ReadFile(path + ".meta"); err == nil && json.Unmarshal(data, &metadata) == nil {
+		info.Content_Type = metadata.Content_Type
+	}
+
+	return file, info, nil
+
+}
+
+func (local *LocalStorage) Delete(ctx context.Context, key string) error {
+
+	path, err := local.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	os.Remove(path + ".meta")
+
+	if err = os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+
+}
+
+func (local *LocalStorage) URL(key string) string {
+	return local.urlPrefix + key
+}
+
+var _ Storage = (*LocalStorage)(nil)
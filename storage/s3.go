@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+)
+
+// settings for an S3 compatible bucket (AWS, MinIO, R2), read from S3_ENDPOINT, S3_BUCKET, S3_REGION,
+// S3_ACCESS_KEY, S3_SECRET_KEY and S3_PUBLIC_URL
+
+type S3Config struct {
+	Endpoint   string
+	Bucket     string
+	Region     string
+	Access_Key string
+	Secret_Key string
+	Public_URL string
+}
+
+func S3ConfigFromEnv() S3Config {
+
+	return S3Config{
+		Endpoint:   os.Getenv("S3_ENDPOINT"),
+		Bucket:     os.Getenv("S3_BUCKET"),
+		Region:     os.Getenv("S3_REGION"),
+		Access_Key: os.Getenv("S3_ACCESS_KEY"),
+		Secret_Key: os.Getenv("S3_SECRET_KEY"),
+		Public_URL: os.Getenv("S3_PUBLIC_URL"),
+	}
+}
+
+// the bucket backend is reserved, the type fixes the shape callers depend on until a client is wired in
+// every operation reports ErrDriverNotAvailable
+
+type S3Storage struct {
+	config S3Config
+}
+
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	return nil, ErrDriverNotAvailable
+}
+
+func (bucket *S3Storage) Put(ctx context.Context, key string, contentType string, body io.Reader) error {
+	return ErrDriverNotAvailable
+}
+
+func (bucket *S3Storage) Get(ctx context.Context, key string) (io.ReadSeekCloser, ObjectInfo, error) {
+	return nil, ObjectInfo{}, ErrDriverNotAvailable
+}
+
+func (bucket *S3Storage) Delete(ctx context.Context, key string) error {
+	return ErrDriverNotAvailable
+}
+
+func (bucket *S3Storage) URL(key string) string {
+	return strings.TrimSuffix(bucket.config.Public_URL, "/") + "/" + key
+}
+
+var _ Storage = (*S3Storage)(nil)
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	ErrNotFound           = errors.New("stored object not found")
+	ErrInvalidKey         = errors.New("storage key is not valid")
+	ErrDriverNotAvailable = errors.New("storage driver is not available")
+)
+
+type ObjectInfo struct {
+	Content_Type string
+	Size         int64
+	Modified_At  time.Time
+}
+
+// product images are stored through this, so moving from local disk to a bucket does not touch the handlers
+// keys are slash separated paths, eg : products/<product id>/<image id>/thumb.jpg
+
+type Storage interface {
+	Put(ctx context.Context, key string, contentType string, body io.Reader) error
+	// the returned reader also seeks, so ranges and conditional requests can be served from it
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	// public URL the object is served from
+	URL(key string) string
+}
+
+// STORAGE_DRIVER picks the backend, "local" (default) keeps files under STORAGE_DIR
+
+func NewFromEnv() (Storage, error) {
+
+	switch os.Getenv("STORAGE_DRIVER") {
+	case "", "local":
+		directory := os.Getenv("STORAGE_DIR")
+		if directory == "" {
+			directory = "uploads"
+		}
+		return NewLocalStorage(directory, "/images/")
+	case "s3":
+		return NewS3Storage(S3ConfigFromEnv())
+	default:
+		return nil, ErrDriverNotAvailable
+	}
+
+}
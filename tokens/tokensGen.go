@@ -2,6 +2,9 @@ package tokens
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
 	"log"
 	"os"
 	"time"
@@ -17,15 +20,30 @@ import (
 var UserCollection *mongo.Collection = database.UserData(database.Client, "Users")
 var SECRET_KEY = os.Getenv("SECRET_KEY")
 
+var ErrMissingSigningKey = errors.New("SECRET_KEY is not set, tokens cannot be signed")
+
+// tokens signed with an empty key could be forged by anyone
+
+func SigningKeyLoaded() error {
+
+	if SECRET_KEY == "" {
+		return ErrMissingSigningKey
+	}
+
+	return nil
+
+}
+
 type SignedDetails struct {
 	Email      string
 	First_Name string
 	Last_Name  string
 	UID        string
+	Role       string
 	jwt.StandardClaims
 }
 
-func TokenGenerator(email string, firstName string, lastName string, uid string) (signedToken string, signedRefreshToken string, err error) {
+func TokenGenerator(email string, firstName string, lastName string, uid string, role string) (signedToken string, signedRefreshToken string, err error) {
 
 	claims := SignedDetails{
 
@@ -33,6 +51,7 @@ func TokenGenerator(email string, firstName string, lastName string, uid string)
 		First_Name: firstName,
 		Last_Name:  lastName,
 		UID:        uid,
+		Role:       role,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
 		},
@@ -81,6 +100,12 @@ func VerifyToken(signedToken string) (claims *SignedDetails, msg string) {
 		return
 	}
 
+	// cart and refresh tokens carry no user, they must never pass as a signed in one
+	if claims.Subject == guestCartSubject || claims.UID == "" {
+		msg = "The Token is not valid."
+		return nil, msg
+	}
+
 	if claims.ExpiresAt < time.Now().Local().Unix() {
 		msg = "Toke is expired"
 		return
@@ -90,6 +115,63 @@ func VerifyToken(signedToken string) (claims *SignedDetails, msg string) {
 
 }
 
+// cart tokens only identify an anonymous cart, they grant nothing else, so they are signed with a key of their own
+// derived from SECRET_KEY, a cart token never verifies as a user token even if a claim check is missed
+
+const guestCartSubject = "guest-cart"
+
+func cartSigningKey() []byte {
+
+	mac := hmac.New(sha256.New, []byte(SECRET_KEY))
+	mac.Write([]byte(guestCartSubject))
+	return mac.Sum(nil)
+
+}
+
+type CartClaims struct {
+	Cart_ID string
+	jwt.StandardClaims
+}
+
+func CartTokenGenerator(cartID string, expiresAt time.Time) (signedToken string, err error) {
+
+	claims := CartClaims{
+		Cart_ID: cartID,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   guestCartSubject,
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(cartSigningKey())
+
+}
+
+func VerifyCartToken(signedToken string) (claims *CartClaims, msg string) {
+
+	token, err := jwt.ParseWithClaims(signedToken, &CartClaims{}, func(t *jwt.Token) (interface{}, error) {
+
+		return cartSigningKey(), nil
+
+	})
+
+	if err != nil {
+		msg = err.Error()
+		return
+	}
+
+	claims, ok := token.Claims.(*CartClaims)
+
+	// checked as well, in case a token of another kind ever shares the key
+	if !ok || claims.Subject != guestCartSubject || claims.Cart_ID == "" {
+		msg = "The cart token is not valid."
+		return nil, msg
+	}
+
+	return claims, msg
+
+}
+
 func UpdateAllTokens(signedToken string, signedRefreshToken string, userID string) {
 
 	context, cancel := context.WithTimeout(context.Background(), 100*time.Second)